@@ -0,0 +1,429 @@
+package agentalign
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"agent-align/internal/config"
+	"agent-align/internal/docpath"
+	"agent-align/internal/fsys"
+	"agent-align/internal/mcpconfig"
+	"agent-align/internal/syncer"
+	"agent-align/internal/transforms"
+)
+
+// planAdditionalTarget runs the servers through the same pipeline as agent
+// targets and renders the result into the target's file.
+func (e *Engine) planAdditionalTarget(s *syncer.Syncer, target config.AdditionalJSONTarget, servers mcpconfig.Servers) Target {
+	prepared := Target{
+		Kind:     KindAdditional,
+		Path:     target.FilePath,
+		Format:   additionalFormat(target),
+		JSONPath: target.JSONPath,
+		Strategy: target.Strategy,
+		Mode:     0o644,
+	}
+	rendered, err := s.Render(additionalSelection(target), servers)
+	prepared.FilteredServers = rendered.Filtered
+	prepared.SkippedServers = rendered.Skipped
+	if err != nil {
+		prepared.Servers, prepared.Err = rendered.Names, err
+		return prepared
+	}
+
+	// Some transformers switch servers off outside the server entry, as
+	// Gemini does with mcp.excluded. Additional files have no such place, so
+	// those servers are left out.
+	merge := &serverMerge{strategy: target.Strategy}
+	for _, server := range rendered.Servers {
+		if !server.Disabled {
+			merge.servers = append(merge.servers, server)
+			prepared.Servers = append(prepared.Servers, server.Name)
+		}
+	}
+	if target.Strategy == "managed" {
+		path, err := docpath.Parse(target.JSONPath)
+		if err == nil {
+			merge.owned, err = e.ownedServers(target.FilePath, path)
+		}
+		if err != nil {
+			prepared.Err = err
+			return prepared
+		}
+	}
+	prepared.Content, prepared.Quarantine, prepared.Err = e.buildAdditionalContent(target, merge)
+	prepared.Keys = merge.changes
+	return prepared
+}
+
+// additionalSelection converts the server selection of an additional target
+// for the syncer.
+func additionalSelection(target config.AdditionalJSONTarget) syncer.AgentTarget {
+	return syncer.AgentTarget{
+		Name:               target.Transformer,
+		DisabledMcpServers: target.DisabledMcpServers,
+		Tags:               target.Tags,
+		ExcludeTags:        target.ExcludeTags,
+		Overrides:          target.Overrides,
+	}
+}
+
+// buildAdditionalContent renders the servers of merge into the target's file
+// in its format. The second return value reports that the existing file could
+// not be parsed and must be quarantined before the content is written (only
+// with Force).
+func (e *Engine) buildAdditionalContent(target config.AdditionalJSONTarget, merge *serverMerge) (string, bool, error) {
+	switch target.Format {
+	case "yaml":
+		return e.buildAdditionalYAMLContent(target, merge)
+	case "toml":
+		return e.buildAdditionalTOMLContent(target, merge)
+	default:
+		return e.buildAdditionalJSONContent(target, merge)
+	}
+}
+
+// additionalFormat returns the display format of an additional target.
+func additionalFormat(target config.AdditionalJSONTarget) string {
+	if target.Format == "" {
+		return "json"
+	}
+	return target.Format
+}
+
+// listNameKey returns the key that carries the server name in list-shaped
+// targets, or "" for map-shaped ones.
+func listNameKey(target config.AdditionalJSONTarget) string {
+	if target.Shape != "list" {
+		return ""
+	}
+	if target.NameKey == "" {
+		return "name"
+	}
+	return target.NameKey
+}
+
+// buildAdditionalJSONContent renders servers into the target's JSON file,
+// which may contain comments and trailing commas. The file is edited in
+// place, so everything outside the servers' path keeps its bytes. The second
+// return value reports that the existing file could not be parsed and must
+// be quarantined before the content is written (only with Force).
+func (e *Engine) buildAdditionalJSONContent(target config.AdditionalJSONTarget, merge *serverMerge) (string, bool, error) {
+	nameKey := listNameKey(target)
+	path, err := docpath.Parse(target.JSONPath)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid jsonPath %q: %w", target.JSONPath, err)
+	}
+
+	data, err := fsys.ReadIfExists(e.base, target.FilePath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", target.FilePath, err)
+	}
+	root, err := decodeJSONFile(target.FilePath, data)
+	if len(path) == 0 && merge.replaces() {
+		// The whole file is replaced, so its content only matters for the
+		// key changes.
+		if err != nil {
+			root = nil
+		}
+		payload, err := merge.mergeJSON(root, path, nameKey)
+		if err != nil {
+			return "", false, err
+		}
+		content, err := marshalJSON(payload)
+		return content, false, err
+	}
+	quarantine, err := syncer.ResolveParseError("additional JSON target", err, e.force, e.logger)
+	if err != nil {
+		return "", false, err
+	}
+	if quarantine || root == nil {
+		root, data = make(map[string]interface{}), nil
+	}
+
+	root, err = setJSONValue(root, path, 0, func(existing interface{}) (interface{}, error) {
+		return merge.mergeJSON(existing, path, nameKey)
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("cannot place servers at jsonPath %q: %w", target.JSONPath, err)
+	}
+	if _, ok := root.(map[string]interface{}); !ok {
+		// Only objects can be edited in place.
+		content, err := marshalJSON(root)
+		return content, quarantine, err
+	}
+
+	// The value is written at the longest key path leading to it: a map of
+	// servers member by member, anything else as a whole.
+	key := leadingKeys(path)
+	var content string
+	if nameKey == "" && len(key) == len(path) {
+		var keep func(string) bool
+		if !merge.replaces() {
+			removed := make(map[string]bool, len(merge.changes.Removed))
+			for _, name := range merge.changes.Removed {
+				removed[name] = true
+			}
+			keep = func(name string) bool { return !removed[name] }
+		}
+		content, err = syncer.MergeJSONServers(target.FilePath, data, key, merge.servers, keep)
+	} else {
+		content, err = syncer.SetJSONValue(target.FilePath, data, key, jsonValueAt(root, key))
+	}
+	return content, quarantine, err
+}
+
+// leadingKeys returns the object keys path starts with, up to its first
+// array index.
+func leadingKeys(path docpath.Path) []string {
+	var keys []string
+	for _, segment := range path {
+		if !segment.IsKey {
+			break
+		}
+		keys = append(keys, segment.Key)
+	}
+	return keys
+}
+
+// jsonValueAt returns the value at the object keys key of root.
+func jsonValueAt(root interface{}, key []string) interface{} {
+	for _, name := range key {
+		object, _ := root.(map[string]interface{})
+		root = object[name]
+	}
+	return root
+}
+
+// jsonPayload returns the servers as an object keyed by name or, when nameKey
+// is set, as a list of objects that carry the name under nameKey. Both keep
+// the servers in order.
+func jsonPayload(servers []transforms.Server, nameKey string) interface{} {
+	if nameKey != "" {
+		items := make([]interface{}, 0, len(servers))
+		for _, server := range servers {
+			items = append(items, namedServer{nameKey: nameKey, name: server.Name, fields: server.Fields})
+		}
+		return items
+	}
+	return serverObject(servers)
+}
+
+// serverObject is a map-shaped payload. It marshals the servers in order.
+type serverObject []transforms.Server
+
+func (s serverObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, server := range s {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(server.Name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(server.Fields)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// namedServer is a list item of a list-shaped payload. It marshals the name
+// first, followed by the server fields in key order.
+type namedServer struct {
+	nameKey string
+	name    string
+	fields  map[string]interface{}
+}
+
+func (s namedServer) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(s.fields))
+	for k := range s.fields {
+		if k != s.nameKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range append([]string{s.nameKey}, keys...) {
+		value := interface{}(s.name)
+		if i > 0 {
+			buf.WriteByte(',')
+			value = s.fields[k]
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// buildAdditionalTOMLContent renders servers as tables under the target's
+// path, keeping the rest of the TOML document.
+func (e *Engine) buildAdditionalTOMLContent(target config.AdditionalJSONTarget, merge *serverMerge) (string, bool, error) {
+	path, err := docpath.Parse(target.JSONPath)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid jsonPath %q: %w", target.JSONPath, err)
+	}
+	if path.HasIndex() {
+		return "", false, fmt.Errorf("jsonPath %q: TOML targets do not support array indices", target.JSONPath)
+	}
+	nameKey := listNameKey(target)
+	if nameKey != "" && len(path) == 0 {
+		return "", false, errors.New("TOML targets with shape list need a jsonPath")
+	}
+	if nameKey != "" && !merge.replaces() {
+		return "", false, errors.New("TOML targets with shape list only support strategy replace")
+	}
+
+	data, err := fsys.ReadIfExists(e.base, target.FilePath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", target.FilePath, err)
+	}
+	table := path.Keys()
+	var drop map[string]bool
+	if nameKey == "" {
+		names, tables := syncer.TOMLTables(string(data), table)
+		drop = merge.classify(names, func(name string) bool {
+			for _, server := range merge.servers {
+				if server.Name == name {
+					return strings.TrimSpace(tables[name]) == strings.TrimSpace(syncer.FormatTOMLServer(table, server))
+				}
+			}
+			return false
+		})
+	}
+	existing := data
+	if len(path) == 0 && merge.replaces() {
+		// The whole file is replaced.
+		existing = nil
+	}
+	var keep func(string) bool
+	if !merge.replaces() {
+		ours := make(map[string]bool, len(merge.servers))
+		for _, server := range merge.servers {
+			ours[server.Name] = true
+		}
+		keep = func(name string) bool { return !ours[name] && !drop[name] }
+	}
+
+	content, err := syncer.MergeTOMLTables(target.FilePath, existing, table, merge.servers, nameKey, keep)
+	quarantine, err := syncer.ResolveParseError("additional TOML target", err, e.force, e.logger)
+	if err != nil {
+		return "", false, err
+	}
+	if quarantine {
+		merge.classify(nil, nil)
+		content, err = syncer.MergeTOMLTables(target.FilePath, nil, table, merge.servers, nameKey, keep)
+	}
+	return content, quarantine, err
+}
+
+// decodeJSONFile decodes the existing content of a JSON target. Comments and
+// trailing commas are accepted, as in VS Code's settings.json. An empty file
+// decodes to nil.
+func decodeJSONFile(path string, data []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var out interface{}
+	if err := syncer.DecodeJSONC(path, data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func marshalJSON(value interface{}) (string, error) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// setJSONValue returns node with the value at path[depth:] replaced by what
+// value returns for the existing one, or for nil when it does not exist.
+// Missing object keys along the way are created; any other node that the
+// path cannot descend into is reported instead of being overwritten.
+func setJSONValue(node interface{}, path docpath.Path, depth int, value func(existing interface{}) (interface{}, error)) (interface{}, error) {
+	if depth == len(path) {
+		return value(node)
+	}
+	segment := path[depth]
+	switch current := node.(type) {
+	case map[string]interface{}:
+		if !segment.IsKey {
+			return nil, fmt.Errorf("%s is an object, not an array", path[:depth])
+		}
+		child, ok := current[segment.Key]
+		if !ok && depth+1 < len(path) {
+			if !path[depth+1].IsKey {
+				return nil, fmt.Errorf("%s does not exist", path[:depth+1])
+			}
+			child = make(map[string]interface{})
+		}
+		updated, err := setJSONValue(child, path, depth+1, value)
+		if err != nil {
+			return nil, err
+		}
+		current[segment.Key] = updated
+		return current, nil
+	case []interface{}:
+		if !segment.IsIndex {
+			return nil, fmt.Errorf("%s is an array, not an object", path[:depth])
+		}
+		if segment.Index >= len(current) {
+			return nil, fmt.Errorf("%s has %d elements, index %d is out of range", path[:depth], len(current), segment.Index)
+		}
+		updated, err := setJSONValue(current[segment.Index], path, depth+1, value)
+		if err != nil {
+			return nil, err
+		}
+		current[segment.Index] = updated
+		return current, nil
+	default:
+		want := "an object"
+		if !segment.IsKey {
+			want = "an array"
+		}
+		return nil, fmt.Errorf("%s is %s, not %s", path[:depth], jsonKind(node), want)
+	}
+}
+
+// jsonKind describes a decoded JSON value for error messages.
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
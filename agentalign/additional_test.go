@@ -0,0 +1,391 @@
+package agentalign
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"agent-align/internal/config"
+	"agent-align/internal/mcpconfig"
+	"agent-align/internal/transforms"
+)
+
+// canonical renders servers with their canonical fields, as additional
+// targets without a transformer receive them, for the replace strategy.
+func canonical(servers mcpconfig.Servers) *serverMerge {
+	out := make([]transforms.Server, 0, len(servers))
+	for _, spec := range servers {
+		out = append(out, transforms.Server{Name: spec.Name, Fields: spec.Fields()})
+	}
+	return &serverMerge{servers: out}
+}
+
+func TestBuildAdditionalJSONContent_MergesWithExisting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "extra.json")
+	if err := os.WriteFile(path, []byte(`{"keep": true}`), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: ".mcpServers"}
+	servers := mcpconfig.Servers{
+		{Name: "beta", Command: "node"},
+	}
+
+	content, _, err := New(Options{}).buildAdditionalJSONContent(target, canonical(servers))
+	if err != nil {
+		t.Fatalf("buildAdditionalJSONContent returned error: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if _, ok := parsed["keep"]; !ok {
+		t.Fatal("expected existing keys to be preserved")
+	}
+	if _, ok := parsed["mcpServers"]; !ok {
+		t.Fatal("expected new node to be inserted")
+	}
+}
+
+func TestBuildAdditionalJSONContent_RootPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "root.json")
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: ""}
+	servers := mcpconfig.Servers{
+		{Name: "delta", Command: "npm"},
+	}
+
+	content, _, err := New(Options{}).buildAdditionalJSONContent(target, canonical(servers))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if _, ok := parsed["delta"]; !ok {
+		t.Fatal("expected root object to contain the servers map")
+	}
+}
+
+func TestBuildAdditionalJSONContent_InvalidJSONFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(path, []byte("{ invalid"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: ".mcpServers"}
+	_, _, err := New(Options{}).buildAdditionalJSONContent(target, canonical(mcpconfig.Servers{}))
+	if err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+
+	content, quarantine, err := New(Options{Force: true}).buildAdditionalJSONContent(target, canonical(mcpconfig.Servers{}))
+	if err != nil || !quarantine {
+		t.Fatalf("expected force to quarantine the file, got quarantine=%v err=%v", quarantine, err)
+	}
+	if strings.Contains(content, "invalid") {
+		t.Fatalf("quarantined content should start from an empty object: %s", content)
+	}
+}
+
+func TestBuildAdditionalYAMLContent_KeepsCommentsAndKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	existing := `# Goose configuration
+GOOSE_PROVIDER: openai # provider
+extensions:
+  # kept in sync by agent-align
+  beta:
+    command: node
+    args: ["server.js"]
+  old:
+    command: gone
+other:
+  - a
+  - b
+`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: "extensions", Format: "yaml"}
+	servers := mcpconfig.Servers{
+		{Name: "beta", Command: "node", Args: []string{"server.js"}},
+		{Name: "alpha", URL: "https://a.test"},
+	}
+
+	content, _, err := New(Options{}).buildAdditionalContent(target, canonical(servers))
+	if err != nil {
+		t.Fatalf("buildAdditionalContent returned error: %v", err)
+	}
+	want := `# Goose configuration
+GOOSE_PROVIDER: openai # provider
+extensions:
+  # kept in sync by agent-align
+  beta:
+    command: node
+    args: ["server.js"]
+  alpha:
+    url: https://a.test
+other:
+  - a
+  - b
+`
+	if content != want {
+		t.Fatalf("unexpected YAML:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestBuildAdditionalYAMLContent_InvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "list.yaml")
+	if err := os.WriteFile(path, []byte("- a\n- b\n"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: "mcpServers", Format: "yaml"}
+	if _, _, err := New(Options{}).buildAdditionalContent(target, canonical(mcpconfig.Servers{})); err == nil {
+		t.Fatal("expected error for a YAML document that is not a mapping")
+	}
+}
+
+func TestBuildAdditionalTOMLContent_ReplacesTablesUnderPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "runner.toml")
+	existing := `# runner settings
+name = "runner"
+
+[tools.mcp.old]
+command = "gone"
+notes = """
+[not.a.table]
+"""
+
+[logging]
+level = "debug"
+`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: "tools.mcp", Format: "toml"}
+	servers := mcpconfig.Servers{{Name: "beta", Command: "node"}}
+
+	content, _, err := New(Options{}).buildAdditionalContent(target, canonical(servers))
+	if err != nil {
+		t.Fatalf("buildAdditionalContent returned error: %v", err)
+	}
+	want := `# runner settings
+name = "runner"
+
+[tools.mcp.beta]
+command = "node"
+
+[logging]
+level = "debug"
+`
+	if content != want {
+		t.Fatalf("unexpected TOML:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestBuildAdditionalJSONContent_QuotedKeysAndListShape(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	existing := `{"profiles": [{"id": "default"}, {"id": "work", "mcp.servers": {"stale": {}}}]}`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: `profiles[1]["mcp.servers"]`, Shape: "list", NameKey: "id"}
+	servers := mcpconfig.Servers{{Name: "beta", Command: "node"}}
+
+	content, _, err := New(Options{}).buildAdditionalJSONContent(target, canonical(servers))
+	if err != nil {
+		t.Fatalf("buildAdditionalJSONContent returned error: %v", err)
+	}
+	want := `{"profiles": [{"id": "default"}, {"id": "work", "mcp.servers": [{"id": "beta", "command": "node"}]}]}`
+	if content != want {
+		t.Fatalf("unexpected JSON:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestBuildAdditionalJSONContent_EditsJSONCInPlace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	existing := `{
+  // editor settings
+  "editor.fontSize": 14,
+  "mcp.servers": {
+    "theirs": { "command": "x" }, // added by hand
+  },
+  "a.setting": true,
+}
+`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: `["mcp.servers"]`, Strategy: "merge"}
+	merge := canonical(mcpconfig.Servers{{Name: "zeta", Command: "npx"}, {Name: "alpha", Command: "uvx"}})
+	merge.strategy = target.Strategy
+
+	content, _, err := New(Options{}).buildAdditionalJSONContent(target, merge)
+	if err != nil {
+		t.Fatalf("buildAdditionalJSONContent returned error: %v", err)
+	}
+	want := `{
+  // editor settings
+  "editor.fontSize": 14,
+  "mcp.servers": {
+    "theirs": { "command": "x" }, // added by hand
+    "zeta": {
+      "command": "npx"
+    },
+    "alpha": {
+      "command": "uvx"
+    },
+  },
+  "a.setting": true,
+}
+`
+	if content != want {
+		t.Fatalf("unexpected JSON:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestBuildAdditionalContent_RejectsWrongIntermediateTypes(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		format   string
+		existing string
+		path     string
+		want     string
+	}{
+		{"json", `{"mcp": "off"}`, "mcp.servers", `$["mcp"] is a string, not an object`},
+		{"json", `{"mcp": {}}`, "mcp.servers[0]", `$["mcp"]["servers"] does not exist`},
+		{"json", `{"list": [1]}`, "list[3]", `$["list"] has 1 elements, index 3 is out of range`},
+		{"json", `{"list": [1]}`, "list.key", `$["list"] is an array, not an object`},
+		{"yaml", "mcp: off\n", "mcp.servers", `$["mcp"] is a scalar, not a mapping`},
+		{"yaml", "mcp:\n  - a\n", "mcp.servers", `$["mcp"] is a sequence, not a mapping`},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, "config."+tc.format)
+		if err := os.WriteFile(path, []byte(tc.existing), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		target := config.AdditionalJSONTarget{FilePath: path, JSONPath: tc.path, Format: tc.format}
+		_, _, err := New(Options{}).buildAdditionalContent(target, canonical(mcpconfig.Servers{{Name: "a", Command: "npx"}}))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s %s: expected error containing %q, got %v", tc.format, tc.path, tc.want, err)
+		}
+	}
+}
+
+func TestBuildAdditionalYAMLContent_ListShape(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	existing := `name: assistant
+mcpServers:
+  # local tools
+  - name: beta
+    command: node
+  - name: stale
+    command: gone
+`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	target := config.AdditionalJSONTarget{FilePath: path, JSONPath: "mcpServers", Format: "yaml", Shape: "list"}
+	servers := mcpconfig.Servers{
+		{Name: "beta", Command: "node"},
+		{Name: "alpha", URL: "https://a.test"},
+	}
+
+	content, _, err := New(Options{}).buildAdditionalContent(target, canonical(servers))
+	if err != nil {
+		t.Fatalf("buildAdditionalContent returned error: %v", err)
+	}
+	want := `name: assistant
+mcpServers:
+  # local tools
+  - name: beta
+    command: node
+  - name: alpha
+    url: https://a.test
+`
+	if content != want {
+		t.Fatalf("unexpected YAML:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestPlanRunsAdditionalTargetsThroughTheServerPipeline(t *testing.T) {
+	dir := t.TempDir()
+	qwenPath := filepath.Join(dir, "qwen", "settings.json")
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), `servers:
+  api:
+    type: streamable-http
+    url: https://api.test
+    tags: [work]
+  local:
+    command: npx
+    tags: [work]
+  off:
+    command: npx
+    enabled: false
+    tags: [work]
+  home:
+    command: npx
+    tags: [personal]
+`)
+	inputs := &Inputs{
+		ConfigPath: filepath.Join(dir, "agent-align.yml"),
+		Additional: []AdditionalJSONTarget{{
+			FilePath:           qwenPath,
+			JSONPath:           "mcpServers",
+			Transformer:        "gemini",
+			Tags:               []string{"work"},
+			DisabledMcpServers: []string{"local"},
+			Overrides:          map[string]map[string]interface{}{"api": {"url": "https://qwen.test"}},
+		}},
+	}
+	servers, err := mcpconfig.Load(filepath.Join(dir, "agent-align-mcp.yml"))
+	if err != nil {
+		t.Fatalf("failed to load servers: %v", err)
+	}
+	inputs.Servers = servers
+
+	plan, err := New(Options{}).Plan(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	target := plan.Targets[0]
+	if target.Err != nil {
+		t.Fatalf("unexpected error: %v", target.Err)
+	}
+	if !reflect.DeepEqual(target.Servers, []string{"api"}) || !reflect.DeepEqual(target.FilteredServers, []string{"local", "home"}) {
+		t.Fatalf("unexpected selection: servers %v, filtered %v", target.Servers, target.FilteredServers)
+	}
+	want := `{
+  "mcpServers": {
+    "api": {
+      "httpUrl": "https://qwen.test"
+    }
+  }
+}
+`
+	if target.Content != want {
+		t.Fatalf("unexpected content:\n%s\nwant:\n%s", target.Content, want)
+	}
+}
@@ -0,0 +1,292 @@
+package agentalign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"agent-align/internal/config"
+	"agent-align/internal/docpath"
+	"agent-align/internal/fsys"
+	"agent-align/internal/syncer"
+	"agent-align/internal/transforms"
+)
+
+// buildAdditionalYAMLContent renders servers into the target's YAML file. The
+// document is edited as a yaml.Node tree so comments and unrelated keys are
+// kept, and servers whose definition did not change keep their existing node.
+func (e *Engine) buildAdditionalYAMLContent(target config.AdditionalJSONTarget, merge *serverMerge) (string, bool, error) {
+	path, err := docpath.Parse(target.JSONPath)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid jsonPath %q: %w", target.JSONPath, err)
+	}
+	nameKey := listNameKey(target)
+
+	data, err := fsys.ReadIfExists(e.base, target.FilePath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", target.FilePath, err)
+	}
+	docs, err := loadYAMLDocuments(target.FilePath, data)
+	if len(path) == 0 && merge.replaces() {
+		// The whole file is replaced, so its content only matters for the
+		// key changes.
+		var existing *yaml.Node
+		if err == nil && len(docs) > 0 && len(docs[0].Content) > 0 {
+			existing = docs[0].Content[0]
+		}
+		payload, err := merge.mergeYAML(existing, path, nameKey)
+		if err != nil {
+			return "", false, err
+		}
+		content, err := marshalYAML([]*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{payload}}}, nil)
+		return content, false, err
+	}
+	quarantine, err := syncer.ResolveParseError("additional YAML target", err, e.force, e.logger)
+	if err != nil {
+		return "", false, err
+	}
+	if quarantine {
+		docs, data = nil, nil
+	}
+	if len(docs) == 0 {
+		docs = []*yaml.Node{{Kind: yaml.DocumentNode}}
+	}
+	root := docs[0]
+	if len(root.Content) == 0 {
+		root.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	if len(path) == 0 {
+		payload, err := merge.mergeYAML(root.Content[0], path, nameKey)
+		if err != nil {
+			return "", false, fmt.Errorf("cannot place servers at jsonPath %q: %w", target.JSONPath, err)
+		}
+		root.Content[0] = payload
+	} else if err := setYAMLServers(root.Content[0], path, merge, nameKey); err != nil {
+		return "", false, fmt.Errorf("cannot place servers at jsonPath %q: %w", target.JSONPath, err)
+	}
+	content, err := marshalYAML(docs, data)
+	return content, quarantine, err
+}
+
+// loadYAMLDocuments parses every document in data; the first one receives
+// the servers.
+func loadYAMLDocuments(path string, data []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &syncer.ParseError{Path: path, Err: err}
+		}
+		docs = append(docs, &doc)
+	}
+	return docs, nil
+}
+
+// setYAMLServers replaces the value at path below node with the servers
+// merged into it.
+// Missing mapping keys along the way are created; any other node that the
+// path cannot descend into is reported instead of being overwritten.
+func setYAMLServers(node *yaml.Node, path docpath.Path, merge *serverMerge, nameKey string) error {
+	current := node
+	for depth, segment := range path {
+		var slot **yaml.Node
+		switch current.Kind {
+		case yaml.MappingNode:
+			if !segment.IsKey {
+				return fmt.Errorf("%s is a mapping, not a sequence", path[:depth])
+			}
+			for j := 0; j+1 < len(current.Content); j += 2 {
+				if current.Content[j].Value == segment.Key {
+					slot = &current.Content[j+1]
+				}
+			}
+			if slot == nil {
+				if depth+1 < len(path) && !path[depth+1].IsKey {
+					return fmt.Errorf("%s does not exist", path[:depth+1])
+				}
+				key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: segment.Key}
+				value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+				if depth+1 < len(path) {
+					value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				}
+				current.Content = append(current.Content, key, value)
+				slot = &current.Content[len(current.Content)-1]
+			}
+		case yaml.SequenceNode:
+			if !segment.IsIndex {
+				return fmt.Errorf("%s is a sequence, not a mapping", path[:depth])
+			}
+			if segment.Index >= len(current.Content) {
+				return fmt.Errorf("%s has %d elements, index %d is out of range", path[:depth], len(current.Content), segment.Index)
+			}
+			slot = &current.Content[segment.Index]
+		default:
+			want := "a mapping"
+			if !segment.IsKey {
+				want = "a sequence"
+			}
+			return fmt.Errorf("%s is %s, not %s", path[:depth], yamlKind(current), want)
+		}
+
+		if depth == len(path)-1 {
+			payload, err := merge.mergeYAML(*slot, path, nameKey)
+			if err != nil {
+				return err
+			}
+			*slot = payload
+			return nil
+		}
+		current = *slot
+	}
+	return nil
+}
+
+// yamlKind describes a node for error messages.
+func yamlKind(node *yaml.Node) string {
+	switch {
+	case node.Kind == yaml.AliasNode:
+		return "an alias"
+	case node.Tag == "!!null":
+		return "null"
+	case node.Kind == yaml.ScalarNode:
+		return "a scalar"
+	case node.Kind == yaml.MappingNode:
+		return "a mapping"
+	case node.Kind == yaml.SequenceNode:
+		return "a sequence"
+	default:
+		return "an unsupported node"
+	}
+}
+
+// yamlServersNode builds the servers in source order, as a mapping keyed by
+// name or, when nameKey is set, a sequence of mappings that carry the name
+// under nameKey. Keys and entries that would not change are taken from
+// existing so their comments and styles survive the sync.
+func yamlServersNode(servers []transforms.Server, nameKey string, existing *yaml.Node) (*yaml.Node, error) {
+	kind, tag := yaml.MappingNode, "!!map"
+	if nameKey != "" {
+		kind, tag = yaml.SequenceNode, "!!seq"
+	}
+	node := &yaml.Node{Kind: kind, Tag: tag}
+	if existing != nil && existing.Kind == kind {
+		node.Style = existing.Style
+		node.HeadComment, node.LineComment, node.FootComment = existing.HeadComment, existing.LineComment, existing.FootComment
+	}
+
+	for _, server := range servers {
+		value := &yaml.Node{}
+		if err := value.Encode(server.Fields); err != nil {
+			return nil, fmt.Errorf("failed to encode server %q as YAML: %w", server.Name, err)
+		}
+		name := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: server.Name}
+
+		if nameKey == "" {
+			if key, prev := yamlMappingEntry(existing, server.Name); key != nil {
+				name = key
+				if sameYAML(prev, value) {
+					value = prev
+				}
+			}
+			node.Content = append(node.Content, name, value)
+			continue
+		}
+
+		item := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		item.Content = append(item.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: nameKey}, name)
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if value.Content[i].Value != nameKey {
+				item.Content = append(item.Content, value.Content[i], value.Content[i+1])
+			}
+		}
+		if prev := yamlSequenceItem(existing, nameKey, server.Name); prev != nil {
+			if sameYAML(prev, item) {
+				item = prev
+			} else {
+				item.HeadComment, item.LineComment, item.FootComment = prev.HeadComment, prev.LineComment, prev.FootComment
+			}
+		}
+		node.Content = append(node.Content, item)
+	}
+	return node, nil
+}
+
+// yamlMappingEntry returns the key and value nodes for key in mapping.
+func yamlMappingEntry(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// yamlSequenceItem returns the mapping in sequence whose nameKey is name.
+func yamlSequenceItem(sequence *yaml.Node, nameKey, name string) *yaml.Node {
+	if sequence == nil || sequence.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, item := range sequence.Content {
+		if _, value := yamlMappingEntry(item, nameKey); value != nil && value.Value == name {
+			return item
+		}
+	}
+	return nil
+}
+
+// sameYAML reports whether two nodes decode to the same value.
+func sameYAML(a, b *yaml.Node) bool {
+	var left, right interface{}
+	if a.Decode(&left) != nil || b.Decode(&right) != nil {
+		return false
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// marshalYAML encodes docs using the indentation of the original data.
+func marshalYAML(docs []*yaml.Node, original []byte) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(yamlIndent(original))
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return "", fmt.Errorf("failed to marshal YAML: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// yamlIndent returns the smallest indentation used in data, or 2.
+func yamlIndent(data []byte) int {
+	indent := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if n := len(line) - len(trimmed); n > 0 && (indent == 0 || n < indent) {
+			indent = n
+		}
+	}
+	if indent < 2 {
+		return 2
+	}
+	return indent
+}
@@ -0,0 +1,726 @@
+// Package agentalign exposes agent-align's sync pipeline for use in other Go
+// programs. A sync runs in three steps:
+//
+//  1. Load resolves the target configuration and MCP server definitions.
+//  2. Plan renders every destination without writing anything.
+//  3. Apply writes the planned destinations.
+//
+// All filesystem access goes through the FS supplied in Options, and every
+// step honors context cancellation.
+package agentalign
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-align/internal/config"
+	"agent-align/internal/fsys"
+	"agent-align/internal/mcpconfig"
+	"agent-align/internal/syncer"
+)
+
+// FS is the set of filesystem operations used by an Engine.
+type FS = fsys.FS
+
+// OSFS is an FS backed by the host filesystem.
+type OSFS = fsys.OS
+
+// Logger receives warnings emitted while planning. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Re-exported configuration types so callers can inspect loaded inputs.
+type (
+	ServerSpec           = mcpconfig.ServerSpec
+	Servers              = mcpconfig.Servers
+	Transport            = mcpconfig.Transport
+	AgentTarget          = syncer.AgentTarget
+	AdditionalJSONTarget = config.AdditionalJSONTarget
+	ExtraTargetsConfig   = config.ExtraTargetsConfig
+	UsersConfig          = config.UsersConfig
+	ConfigLayer          = config.Layer
+	MergedConfig         = config.Merged
+)
+
+// Options configures an Engine.
+type Options struct {
+	// FS is used for every read and write. Defaults to the host filesystem.
+	FS FS
+	// Logger receives warnings. Defaults to discarding them.
+	Logger Logger
+	// Force replaces agent files that cannot be parsed. By default their
+	// targets fail so the files' other settings are not lost.
+	Force bool
+	// StatePath is where additional targets with the managed strategy record
+	// the servers they wrote. Defaults to DefaultStatePath().
+	StatePath string
+	// OutputDir redirects every write below this directory, mirroring the
+	// absolute path of each destination, and leaves the real files alone.
+	// Rendered content is still merged into the real files.
+	OutputDir string
+	// EmptyBase renders every destination as if its file did not exist
+	// instead of merging into it.
+	EmptyBase bool
+	// Home is the home directory of the user being configured: default agent
+	// files and "~" in the config are resolved against it. Defaults to
+	// $AGENT_ALIGN_HOME, then the invoking user's home directory.
+	Home string
+	// Root places every destination, and the files merged into, below this
+	// directory, as in a container root filesystem. Home and the paths in the
+	// config are paths inside it; the config, MCP file and extra target
+	// sources are still read from the host.
+	Root string
+	// Account configures the agent files of this user instead: its home
+	// replaces Home and Plan records its name on every target.
+	Account *Account
+	// Chown hands every file and directory Apply creates to Account, as
+	// when root provisions other users. The account's files are always read
+	// and written below its home only, without following a symbolic link out
+	// of it; see fsys.Confined.
+	Chown bool
+}
+
+// Engine runs the Load, Plan and Apply steps.
+type Engine struct {
+	// fs reads the configuration and the sources of extra targets, base
+	// reads the files rendered content is merged into, and out holds the
+	// destinations.
+	fs        FS
+	base      FS
+	out       FS
+	logger    Logger
+	force     bool
+	home      string
+	user      string
+	root      string
+	statePath string
+	// layers, when set, are the config files Load reads instead of the
+	// discovered ones; see PlanUsers.
+	layers []ConfigLayer
+}
+
+// New returns an Engine using the provided options.
+func New(opts Options) *Engine {
+	e := &Engine{fs: opts.FS, logger: opts.Logger, force: opts.Force, home: strings.TrimSpace(opts.Home), statePath: opts.StatePath}
+	if e.fs == nil {
+		e.fs = fsys.OS{}
+	}
+	if opts.Account != nil {
+		e.home, e.user = opts.Account.Home, opts.Account.Name
+	}
+	if e.home == "" {
+		e.home = strings.TrimSpace(os.Getenv("AGENT_ALIGN_HOME"))
+	}
+	e.base, e.out = e.fs, e.fs
+	if root := strings.TrimSpace(opts.Root); root != "" {
+		e.root = root
+		e.base = fsys.Rooted{FS: e.fs, Dir: root}
+		e.out = e.base
+	}
+	if opts.OutputDir != "" {
+		e.out = fsys.Rooted{FS: e.fs, Dir: opts.OutputDir}
+	}
+	if opts.Account != nil {
+		var owner *fsys.Owner
+		if opts.Chown {
+			owner = &fsys.Owner{UID: opts.Account.UID, GID: opts.Account.GID}
+		}
+		e.base = confineToHome(e.base, e.home, nil)
+		e.out = confineToHome(e.out, e.home, owner)
+	}
+	if opts.EmptyBase {
+		e.base = &mergeBaseFS{FS: e.base, noMerge: true}
+	}
+	if e.statePath == "" && e.home != "" {
+		e.statePath = filepath.Join(e.home, ".local", "state", "agent-align", "managed.json")
+	} else if e.statePath == "" {
+		e.statePath = DefaultStatePath()
+	}
+	if e.logger == nil {
+		e.logger = log.New(io.Discard, "", 0)
+	}
+	return e
+}
+
+// LoadOptions selects the configuration a sync should use.
+type LoadOptions struct {
+	// ConfigPath is the agent-align YAML configuration. It is required unless
+	// Agents is set, in which case it is only read when it exists.
+	ConfigPath string
+	// Discover treats ConfigPath as the system config and layers the user
+	// config and the project config found from ProjectDir on top of it; see
+	// config.Layers and config.MergeLayers. Missing layers are skipped, but
+	// one must exist unless Agents is set.
+	Discover   bool
+	ProjectDir string
+	// Project syncs only the project-level files of the project in this
+	// directory, such as .vscode/mcp.json: every agent target is written to
+	// the agent's project file, and additional targets, extra targets and the
+	// users section are left out. Agents without a project file are skipped
+	// with a warning. With Discover the project config is looked up from
+	// Project instead of ProjectDir.
+	Project string
+	// MCPConfigPath overrides the MCP server definitions file. When empty the
+	// configPath from the config file is used, falling back to
+	// agent-align-mcp.yml next to ConfigPath. With Discover the servers of
+	// every layer's file are merged instead.
+	MCPConfigPath string
+	// Agents replaces the agent targets from the config file. Path overrides
+	// configured for the same agent names are kept.
+	Agents []string
+}
+
+// Inputs is the resolved configuration for a sync.
+type Inputs struct {
+	ConfigPath    string
+	MCPConfigPath string
+	Agents        []AgentTarget
+	Additional    []AdditionalJSONTarget
+	Extra         ExtraTargetsConfig
+	// Users selects the accounts a system-wide config is applied for; see
+	// PlanUsers.
+	Users UsersConfig
+	// ProjectDir is where the files of project-scope agent targets are
+	// written: LoadOptions.Project, else the directory of the project config,
+	// else LoadOptions.ProjectDir.
+	ProjectDir string
+	Servers    Servers
+	// ProjectServers are the servers of project-scope agent targets when
+	// the project config adds to Servers; see config.MCPSource.Project.
+	ProjectServers Servers
+}
+
+// DefaultMCPConfigPath returns the MCP definitions file used when none is
+// configured: agent-align-mcp.yml next to the config file.
+func DefaultMCPConfigPath(configPath string) string {
+	dir := filepath.Dir(configPath)
+	if dir == "" {
+		return "agent-align-mcp.yml"
+	}
+	return filepath.Join(dir, "agent-align-mcp.yml")
+}
+
+// Load reads the configuration and MCP server definitions described by opts.
+func (e *Engine) Load(ctx context.Context, opts LoadOptions) (*Inputs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	in := &Inputs{ConfigPath: opts.ConfigPath, ProjectDir: opts.ProjectDir}
+	mcpPath := strings.TrimSpace(opts.MCPConfigPath)
+	if opts.Project != "" {
+		opts.ProjectDir, in.ProjectDir = opts.Project, opts.Project
+	}
+
+	var cfg config.Config
+	var sources []config.MCPSource
+	haveConfig := false
+	if opts.Discover {
+		merged, err := e.loadLayers(opts)
+		switch {
+		case err == nil:
+			cfg, haveConfig, sources = merged.Config, true, merged.MCPSources
+			in.ConfigPath = merged.Layers[len(merged.Layers)-1].Path
+			if last := merged.Layers[len(merged.Layers)-1]; last.Name == config.LayerProject && opts.Project == "" {
+				in.ProjectDir = filepath.Dir(last.Path)
+			}
+		case !errors.Is(err, errNoConfig) || len(opts.Agents) == 0:
+			return nil, err
+		}
+	} else if len(opts.Agents) == 0 {
+		loaded, err := e.loadConfig(opts.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg, haveConfig = loaded, true
+	} else if _, err := e.fs.Stat(opts.ConfigPath); err == nil {
+		loaded, err := e.loadConfig(opts.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg, haveConfig = loaded, true
+	}
+
+	if haveConfig {
+		in.Additional = cfg.MCP.Targets.Additional.All()
+		in.Extra = cfg.ExtraTargets
+		in.Users = cfg.Users
+		in.Agents = configTargetsToSyncer(cfg.MCP.Targets.Agents)
+		if mcpPath == "" && !opts.Discover {
+			mcpPath = cfg.MCP.ConfigPath
+		}
+	}
+
+	if len(opts.Agents) > 0 {
+		overrideLookup := make(map[string]string, len(cfg.MCP.Targets.Agents))
+		for _, agent := range cfg.MCP.Targets.Agents {
+			overrideLookup[agent.Name] = agent.Path
+		}
+		in.Agents = nil
+		for _, name := range opts.Agents {
+			normalized := strings.ToLower(strings.TrimSpace(name))
+			if normalized == "" {
+				continue
+			}
+			in.Agents = append(in.Agents, AgentTarget{
+				Name:         normalized,
+				PathOverride: overrideLookup[normalized],
+			})
+		}
+	}
+
+	if opts.Project != "" {
+		in.Agents = e.projectTargets(in.Agents)
+		in.Additional, in.Extra, in.Users = nil, ExtraTargetsConfig{}, UsersConfig{}
+	}
+
+	if len(in.Agents) == 0 && len(in.Additional) == 0 && in.Extra.IsZero() {
+		return nil, errors.New("no target agents, additional destinations, or extra copy targets configured; provide agents via config/flags or add extra targets")
+	}
+
+	if mcpPath == "" && len(sources) > 0 {
+		servers, projectServers, last, err := e.loadMCPSources(sources)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Project != "" && projectServers != nil {
+			servers, projectServers = projectServers, nil
+		}
+		in.MCPConfigPath, in.Servers, in.ProjectServers = last, servers, projectServers
+		return in, nil
+	}
+	if mcpPath == "" {
+		mcpPath = DefaultMCPConfigPath(opts.ConfigPath)
+	}
+	in.MCPConfigPath = mcpPath
+	data, err := e.fs.ReadFile(mcpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MCP configuration %q: %w", mcpPath, err)
+	}
+	servers, err := mcpconfig.Parse(mcpPath, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MCP configuration %q: %w", mcpPath, err)
+	}
+	in.Servers = servers
+	return in, nil
+}
+
+func (e *Engine) loadConfig(path string) (config.Config, error) {
+	data, err := e.fs.ReadFile(path)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("failed to load config %q: %w", path, err)
+	}
+	cfg, err := config.ParseWithHome(path, data, e.home)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("failed to load config %q: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func configTargetsToSyncer(targets []config.AgentTarget) []syncer.AgentTarget {
+	out := make([]syncer.AgentTarget, 0, len(targets))
+	for _, target := range targets {
+		agent := syncer.AgentTarget{
+			Name:                  target.Name,
+			PathOverride:          target.Path,
+			Scope:                 target.Scope,
+			DisabledMcpServers:    target.DisabledMcpServers,
+			Tags:                  target.Tags,
+			ExcludeTags:           target.ExcludeTags,
+			Overrides:             target.Overrides,
+			UnsupportedTransports: syncer.TransportPolicy(target.UnsupportedTransports),
+		}
+		for _, t := range target.Transports {
+			agent.Transports = append(agent.Transports, mcpconfig.ParseTransport(t))
+		}
+		if target.Bridge != nil {
+			agent.Bridge = &syncer.Bridge{
+				Command:    target.Bridge.Command,
+				Args:       target.Bridge.Args,
+				HeaderFlag: target.Bridge.HeaderFlag,
+				SSEArgs:    target.Bridge.SSEArgs,
+			}
+		}
+		if len(target.Projects) > 0 {
+			agent.Projects = make(map[string]syncer.ClaudeProject, len(target.Projects))
+			for path, project := range target.Projects {
+				agent.Projects[path] = syncer.ClaudeProject{
+					Servers:            project.Servers,
+					Tags:               project.Tags,
+					DisabledMcpServers: project.DisabledMcpServers,
+				}
+			}
+		}
+		out = append(out, agent)
+	}
+	return out
+}
+
+// TargetKind identifies the configuration entry that produced a target.
+type TargetKind string
+
+const (
+	// KindAgent is a built-in agent configuration file.
+	KindAgent TargetKind = "agent"
+	// KindAdditional is an additional JSON, YAML or TOML destination.
+	KindAdditional TargetKind = "additional"
+	// KindExtraFile is a single extra file copy destination.
+	KindExtraFile TargetKind = "extra-file"
+	// KindExtraDirectory is a single extra directory copy destination.
+	KindExtraDirectory TargetKind = "extra-directory"
+	// KindUser stands for an account of the users section that could not be
+	// planned. It only carries the error.
+	KindUser TargetKind = "user"
+)
+
+// Target is a single destination rendered by Plan.
+type Target struct {
+	Kind TargetKind
+	// User is the account the target belongs to in a system-wide sync.
+	User string
+	// Agent is the agent name for KindAgent targets.
+	Agent string
+	// Scope is "project" for the project-level file of an agent.
+	Scope string
+	// Path is the destination file, or directory for KindExtraDirectory.
+	Path string
+	// Format is "json" or "toml" for agent and additional targets.
+	Format string
+	// JSONPath is the node additional targets are merged into.
+	JSONPath string
+	// Strategy is the merge strategy of additional targets; empty means
+	// replace.
+	Strategy string
+	// Keys describes how an additional target changes the entries at
+	// JSONPath.
+	Keys KeyChanges
+	// Source is the file or directory copied by extra targets.
+	Source string
+	// Flatten reports whether an extra directory copy flattens its files.
+	Flatten bool
+	// Content is the file content written for every kind except
+	// KindExtraDirectory.
+	Content string
+	// Mode is the permission used when writing Content.
+	Mode fs.FileMode
+	// Files lists the individual copies made by a KindExtraDirectory target.
+	Files []FileCopy
+	// Servers lists the MCP servers written by agent and additional targets.
+	Servers []string
+	// FilteredServers lists the servers omitted through disabledMcpServers.
+	FilteredServers []string
+	// SkippedServers lists the servers omitted because the agent does not
+	// support their transport.
+	SkippedServers []string
+	// Changed reports whether applying the target would modify the destination.
+	Changed bool
+	// Err records why the target could not be prepared. Apply skips targets
+	// with an error and reports it as a failure.
+	Err error
+	// Quarantine reports that the existing file could not be parsed and, as
+	// Force was set, Content replaces it. Apply first moves the file to
+	// QuarantinePath.
+	Quarantine bool
+}
+
+// FileCopy is a single file copied by an extra directory target.
+type FileCopy struct {
+	Source string
+	Path   string
+	Mode   fs.FileMode
+	Size   int64
+	// Changed reports whether the destination differs from the source.
+	Changed bool
+}
+
+// Plan is the rendered output of every configured destination.
+type Plan struct {
+	Inputs  *Inputs
+	Targets []Target
+}
+
+// Failed returns the targets that could not be prepared.
+func (p *Plan) Failed() []Target {
+	var failed []Target
+	for _, target := range p.Targets {
+		if target.Err != nil {
+			failed = append(failed, target)
+		}
+	}
+	return failed
+}
+
+// Plan renders every destination described by in without writing anything.
+// Existing files are read so rendered content can be merged into them. A
+// destination that cannot be rendered, such as an agent whose transformer
+// rejects a server, is recorded in its Target.Err and does not stop the others.
+func (e *Engine) Plan(ctx context.Context, in *Inputs) (*Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s := syncer.New(in.Agents)
+	s.FS = e.base
+	s.Home = e.home
+	s.ProjectDir = in.ProjectDir
+	s.ProjectServers = in.ProjectServers
+	s.Logger = e.logger
+	s.Force = e.force
+	syncResult, err := s.Sync(in.Servers)
+	if err != nil {
+		return nil, fmt.Errorf("sync failed: %w", err)
+	}
+
+	plan := &Plan{Inputs: in}
+	for _, agent := range sortedAgentNames(syncResult.Agents) {
+		for _, output := range syncResult.Agents[agent] {
+			plan.Targets = append(plan.Targets, Target{
+				Kind:            KindAgent,
+				Agent:           agent,
+				Scope:           output.Config.Scope,
+				Path:            output.Config.FilePath,
+				Format:          output.Config.Format,
+				Content:         output.Content,
+				Mode:            0o644,
+				Servers:         output.Servers,
+				FilteredServers: output.Filtered,
+				SkippedServers:  output.Skipped,
+				Err:             output.Err,
+				Quarantine:      output.Quarantine,
+			})
+		}
+	}
+
+	for _, target := range in.Additional {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		plan.Targets = append(plan.Targets, e.planAdditionalTarget(s, target, syncResult.Servers))
+	}
+
+	configDir := filepath.Dir(in.ConfigPath)
+	for _, target := range in.Extra.Files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		plan.Targets = append(plan.Targets, e.planExtraFileTarget(target, configDir, in.Servers)...)
+	}
+	for _, target := range in.Extra.Directories {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		plan.Targets = append(plan.Targets, e.planExtraDirectoryTarget(ctx, target)...)
+	}
+
+	for i := range plan.Targets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		plan.Targets[i].User = e.user
+		e.detectChanges(&plan.Targets[i])
+	}
+	e.warnUnignoredSecrets(plan)
+
+	return plan, nil
+}
+
+// detectChanges compares a prepared target with what is currently on disk.
+func (e *Engine) detectChanges(target *Target) {
+	if target.Err != nil {
+		return
+	}
+	if target.Kind != KindExtraDirectory {
+		existing, err := fsys.ReadIfExists(e.out, target.Path)
+		target.Changed = err != nil || existing == nil || string(existing) != target.Content
+		return
+	}
+	target.Changed = false
+	for i := range target.Files {
+		file := &target.Files[i]
+		existing, err := fsys.ReadIfExists(e.out, file.Path)
+		if err == nil && existing != nil {
+			source, srcErr := e.fs.ReadFile(file.Source)
+			file.Changed = srcErr != nil || !bytes.Equal(source, existing)
+		} else {
+			file.Changed = true
+		}
+		if file.Changed {
+			target.Changed = true
+		}
+	}
+}
+
+// Results describes the outcome Apply would produce for every target without
+// writing anything.
+func (p *Plan) Results() []TargetResult {
+	results := make([]TargetResult, 0, len(p.Targets))
+	for _, target := range p.Targets {
+		tr := TargetResult{Target: target, Bytes: target.size(), Err: target.Err}
+		tr.Status = statusFor(target.Changed, tr.Err)
+		if tr.Err == nil && target.Changed {
+			tr.Files = target.changedFiles()
+		}
+		results = append(results, tr)
+	}
+	return results
+}
+
+func (t Target) size() int {
+	if t.Kind != KindExtraDirectory {
+		return len(t.Content)
+	}
+	var total int64
+	for _, file := range t.Files {
+		total += file.Size
+	}
+	return int(total)
+}
+
+func (t Target) changedFiles() int {
+	if t.Kind != KindExtraDirectory {
+		return 1
+	}
+	var count int
+	for _, file := range t.Files {
+		if file.Changed {
+			count++
+		}
+	}
+	return count
+}
+
+func statusFor(changed bool, err error) Status {
+	switch {
+	case err != nil:
+		return StatusFailed
+	case changed:
+		return StatusChanged
+	default:
+		return StatusUnchanged
+	}
+}
+
+// TargetResult is the outcome of applying a single target.
+type TargetResult struct {
+	Target Target
+	Status Status
+	// Files is the number of files written.
+	Files int
+	// Bytes is the size of the rendered content, or the total size of the
+	// copied files for extra directory targets.
+	Bytes int
+	// QuarantinedTo is where an unparsable existing file was moved before
+	// the target was written.
+	QuarantinedTo string
+	Err           error
+}
+
+// Result is the outcome of Apply.
+type Result struct {
+	Targets []TargetResult
+}
+
+// Failed returns the targets that could not be written.
+func (r *Result) Failed() []TargetResult {
+	var failed []TargetResult
+	for _, target := range r.Targets {
+		if target.Err != nil {
+			failed = append(failed, target)
+		}
+	}
+	return failed
+}
+
+// Apply writes every changed target in the plan. A failing target does not
+// stop the remaining ones; its error is recorded in the result. The returned
+// error is only set when ctx is cancelled, in which case the result covers the
+// targets handled so far.
+func (e *Engine) Apply(ctx context.Context, plan *Plan) (*Result, error) {
+	result := &Result{}
+	for _, target := range plan.Targets {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		tr := TargetResult{Target: target, Bytes: target.size(), Err: target.Err}
+		if tr.Err == nil && target.Quarantine {
+			tr.QuarantinedTo, tr.Err = e.quarantine(target.Path)
+		}
+		if tr.Err == nil && target.Changed {
+			tr.Files, tr.Err = e.applyTarget(ctx, target)
+		}
+		if tr.Err == nil && target.Kind == KindAdditional && target.Strategy == "managed" {
+			tr.Err = e.recordManaged(target)
+		}
+		tr.Status = statusFor(target.Changed, tr.Err)
+		result.Targets = append(result.Targets, tr)
+	}
+	return result, nil
+}
+
+// QuarantinePath returns where an unparsable file at path is moved when it is
+// replaced: path.agent-align-corrupt-<UTC timestamp>.
+func QuarantinePath(path string, now time.Time) string {
+	return path + ".agent-align-corrupt-" + now.UTC().Format("20060102T150405Z")
+}
+
+// quarantine moves an unparsable file out of the way before it is replaced.
+// Nothing is moved when the destination does not hold the file, as with
+// OutputDir.
+func (e *Engine) quarantine(path string) (string, error) {
+	if _, err := e.out.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	dest := QuarantinePath(path, time.Now())
+	if err := e.out.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("failed to move unparsable %q aside: %w", path, err)
+	}
+	return dest, nil
+}
+
+func (e *Engine) applyTarget(ctx context.Context, target Target) (int, error) {
+	if target.Kind != KindExtraDirectory {
+		if err := fsys.WriteFileAll(e.out, target.Path, []byte(target.Content), target.Mode); err != nil {
+			return 0, fmt.Errorf("failed to write %q: %w", target.Path, err)
+		}
+		return 1, nil
+	}
+
+	var copied int
+	for _, file := range target.Files {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+		if !file.Changed {
+			continue
+		}
+		data, err := e.fs.ReadFile(file.Source)
+		if err != nil {
+			return copied, fmt.Errorf("failed to copy directory %s to %s: %w", target.Source, target.Path, err)
+		}
+		if err := fsys.WriteFileAll(e.out, file.Path, data, file.Mode.Perm()); err != nil {
+			return copied, fmt.Errorf("failed to copy directory %s to %s: %w", target.Source, target.Path, err)
+		}
+		copied++
+	}
+	return copied, nil
+}
+
+func sortedAgentNames(agents map[string][]syncer.AgentResult) []string {
+	names := make([]string, 0, len(agents))
+	for name := range agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,464 @@
+package agentalign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent-align/internal/config"
+	"agent-align/internal/mcpconfig"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create dir for %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestDefaultMCPConfigPath(t *testing.T) {
+	path := "/etc/agent-align.yml"
+	got := DefaultMCPConfigPath(path)
+	if got != "/etc/agent-align-mcp.yml" {
+		t.Fatalf("unexpected default MCP path: %s", got)
+	}
+}
+
+func TestConfigTargetsToSyncer(t *testing.T) {
+	targets := []config.AgentTarget{
+		{Name: "Copilot", Path: "/tmp/custom"},
+	}
+	got := configTargetsToSyncer(targets)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 target, got %d", len(got))
+	}
+	if got[0].Name != "Copilot" || got[0].PathOverride != "/tmp/custom" {
+		t.Fatalf("unexpected conversion: %#v", got[0])
+	}
+
+	got = configTargetsToSyncer([]config.AgentTarget{{
+		Name:     "claudecode",
+		Projects: map[string]config.ClaudeProjectConfig{"/work/app": {Servers: []string{"db"}, Tags: []string{"web"}}},
+	}})
+	if project := got[0].Projects["/work/app"]; len(project.Servers) != 1 || project.Tags[0] != "web" {
+		t.Fatalf("unexpected projects: %#v", got[0].Projects)
+	}
+}
+
+func TestLoadPlanApply(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "agent-align.yml")
+	copilotPath := filepath.Join(dir, "out", "copilot.json")
+	extraJSON := filepath.Join(dir, "out", "extra.json")
+	agentsSource := filepath.Join(dir, "AGENTS.md")
+	agentsDest := filepath.Join(dir, "out", "AGENTS.md")
+	promptsSource := filepath.Join(dir, "prompts")
+	promptsDest := filepath.Join(dir, "out", "prompts")
+
+	writeFile(t, configPath, `mcpServers:
+  targets:
+    agents:
+      - name: copilot
+        path: `+copilotPath+`
+    additionalTargets:
+      json:
+        - filePath: `+extraJSON+`
+          jsonPath: .mcpServers
+extraTargets:
+  files:
+    - source: `+agentsSource+`
+      destinations:
+        - `+agentsDest+`
+  directories:
+    - source: `+promptsSource+`
+      destinations:
+        - path: `+promptsDest+`
+`)
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), `servers:
+  tool:
+    command: npx
+`)
+	writeFile(t, agentsSource, "instructions")
+	writeFile(t, filepath.Join(promptsSource, "a.md"), "a")
+	writeFile(t, filepath.Join(promptsSource, "nested", "b.md"), "b")
+
+	ctx := context.Background()
+	e := New(Options{})
+	inputs, err := e.Load(ctx, LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if inputs.MCPConfigPath != filepath.Join(dir, "agent-align-mcp.yml") {
+		t.Fatalf("unexpected MCP path: %s", inputs.MCPConfigPath)
+	}
+
+	plan, err := e.Plan(ctx, inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	kinds := make(map[TargetKind]int)
+	for _, target := range plan.Targets {
+		kinds[target.Kind]++
+		if target.Err != nil {
+			t.Fatalf("unexpected target error for %s: %v", target.Path, target.Err)
+		}
+	}
+	if kinds[KindAgent] != 1 || kinds[KindAdditional] != 1 || kinds[KindExtraFile] != 1 || kinds[KindExtraDirectory] != 1 {
+		t.Fatalf("unexpected target kinds: %v", kinds)
+	}
+	if _, err := os.Stat(copilotPath); !os.IsNotExist(err) {
+		t.Fatalf("Plan should not write files, stat err: %v", err)
+	}
+
+	result, err := e.Apply(ctx, plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if failed := result.Failed(); len(failed) > 0 {
+		t.Fatalf("unexpected failures: %v", failed[0].Err)
+	}
+
+	data, err := os.ReadFile(copilotPath)
+	if err != nil {
+		t.Fatalf("copilot config not written: %v", err)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("copilot config not valid JSON: %v", err)
+	}
+	if _, ok := parsed["mcpServers"].(map[string]interface{})["tool"]; !ok {
+		t.Fatalf("copilot config missing server: %s", data)
+	}
+	if got, _ := os.ReadFile(agentsDest); string(got) != "instructions" {
+		t.Fatalf("extra file not copied, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(promptsDest, "nested", "b.md")); err != nil {
+		t.Fatalf("extra directory not copied: %v", err)
+	}
+	for _, tr := range result.Targets {
+		if tr.Target.Kind == KindExtraDirectory && tr.Files != 2 {
+			t.Fatalf("expected 2 files copied, got %d", tr.Files)
+		}
+	}
+}
+
+func TestLoadAgentsOverrideKeepsPaths(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, `mcpServers:
+  targets:
+    agents:
+      - name: codex
+        path: /custom/codex.toml
+      - copilot
+`)
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), "servers:\n  tool:\n    command: npx\n")
+
+	inputs, err := New(Options{}).Load(context.Background(), LoadOptions{ConfigPath: configPath, Agents: []string{" Codex "}})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(inputs.Agents) != 1 || inputs.Agents[0].Name != "codex" || inputs.Agents[0].PathOverride != "/custom/codex.toml" {
+		t.Fatalf("unexpected agents: %#v", inputs.Agents)
+	}
+}
+
+func TestLoadRequiresConfigWithoutAgents(t *testing.T) {
+	_, err := New(Options{}).Load(context.Background(), LoadOptions{ConfigPath: filepath.Join(t.TempDir(), "missing.yml")})
+	if err == nil || !strings.Contains(err.Error(), "failed to load config") {
+		t.Fatalf("expected config load error, got %v", err)
+	}
+}
+
+func TestApplyRecordsFailuresAndContinues(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	writeFile(t, blocker, "file")
+	good := filepath.Join(dir, "good.json")
+
+	plan := &Plan{Targets: []Target{
+		{Kind: KindAgent, Agent: "copilot", Path: filepath.Join(blocker, "child.json"), Content: "{}", Mode: 0o644, Changed: true},
+		{Kind: KindAdditional, Path: filepath.Join(dir, "skipped.json"), Err: errors.New("prepare failed")},
+		{Kind: KindAgent, Agent: "vscode", Path: good, Content: "{}", Mode: 0o644, Changed: true},
+	}}
+
+	result, err := New(Options{}).Apply(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if failed := result.Failed(); len(failed) != 2 {
+		t.Fatalf("expected 2 failures, got %d", len(failed))
+	}
+	if _, err := os.Stat(good); err != nil {
+		t.Fatalf("later targets should still be written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "skipped.json")); !os.IsNotExist(err) {
+		t.Fatal("targets with preparation errors should not be written")
+	}
+}
+
+func TestPlanIsolatesAgentFailures(t *testing.T) {
+	dir := t.TempDir()
+	// The copilot config path is a directory, so reading it fails.
+	copilotPath := filepath.Join(dir, "copilot.json")
+	if err := os.MkdirAll(copilotPath, 0o755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	claudePath := filepath.Join(dir, "claude.json")
+	in := &Inputs{
+		Agents: []AgentTarget{
+			{Name: "copilot", PathOverride: copilotPath},
+			{Name: "claudecode", PathOverride: claudePath},
+		},
+		Servers: Servers{
+			{Name: "remote", URL: "https://example.test/mcp"},
+			{Name: "local", Command: "npx"},
+		},
+	}
+
+	e := New(Options{})
+	plan, err := e.Plan(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	failed := plan.Failed()
+	if len(failed) != 1 || failed[0].Agent != "copilot" {
+		t.Fatalf("expected only copilot to fail, got %#v", failed)
+	}
+
+	result, err := e.Apply(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if len(result.Failed()) != 1 {
+		t.Fatalf("expected the copilot failure to be reported, got %d", len(result.Failed()))
+	}
+	if _, err := os.Stat(claudePath); err != nil {
+		t.Fatalf("claudecode should still be written: %v", err)
+	}
+	if info, err := os.Stat(copilotPath); err != nil || !info.IsDir() {
+		t.Fatal("copilot config should not be written after a read error")
+	}
+}
+
+func TestPlanRefusesUnparsableAgentFiles(t *testing.T) {
+	dir := t.TempDir()
+	vscodePath := filepath.Join(dir, "mcp.json")
+	broken := "{\n  \"servers\": {\n"
+	writeFile(t, vscodePath, broken)
+	in := &Inputs{
+		Agents:  []AgentTarget{{Name: "vscode", PathOverride: vscodePath}},
+		Servers: Servers{{Name: "local", Command: "npx"}},
+	}
+
+	plan, err := New(Options{}).Plan(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if failed := plan.Failed(); len(failed) != 1 || !strings.Contains(failed[0].Err.Error(), "failed to parse") {
+		t.Fatalf("expected the vscode target to fail, got %#v", failed)
+	}
+
+	plan, err = New(Options{Force: true}).Plan(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if len(plan.Failed()) != 0 || !strings.Contains(plan.Targets[0].Content, `"local"`) {
+		t.Fatalf("expected force to replace the file, got %#v", plan.Targets)
+	}
+}
+
+func TestApplyQuarantinesUnparsableFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	broken := "{\n  \"theme\": \"dark\",\n"
+	writeFile(t, path, broken)
+	in := &Inputs{
+		Agents:  []AgentTarget{{Name: "gemini", PathOverride: path}},
+		Servers: Servers{{Name: "local", Command: "npx"}},
+	}
+
+	e := New(Options{Force: true})
+	plan, err := e.Plan(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if !plan.Targets[0].Quarantine {
+		t.Fatalf("expected the gemini target to be quarantined: %#v", plan.Targets[0])
+	}
+	result, err := e.Apply(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	tr := result.Targets[0]
+	if tr.Err != nil || !strings.HasPrefix(tr.QuarantinedTo, path+".agent-align-corrupt-") {
+		t.Fatalf("unexpected result: err=%v quarantinedTo=%q", tr.Err, tr.QuarantinedTo)
+	}
+	moved, err := os.ReadFile(tr.QuarantinedTo)
+	if err != nil || string(moved) != broken {
+		t.Fatalf("original file should be kept at %s, got %q (%v)", tr.QuarantinedTo, moved, err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(written), `"local"`) {
+		t.Fatalf("expected the new file to be written, got %q (%v)", written, err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	e := New(Options{})
+	if _, err := e.Load(ctx, LoadOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Load should honor cancellation, got %v", err)
+	}
+	if _, err := e.Plan(ctx, &Inputs{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Plan should honor cancellation, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.json")
+	result, err := e.Apply(ctx, &Plan{Targets: []Target{{Kind: KindAgent, Path: path, Content: "{}", Changed: true}}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Apply should honor cancellation, got %v", err)
+	}
+	if len(result.Targets) != 0 {
+		t.Fatalf("no targets should be applied after cancellation, got %d", len(result.Targets))
+	}
+}
+
+func TestOutputDirStagesDestinations(t *testing.T) {
+	dir := t.TempDir()
+	agentPath := filepath.Join(dir, "home", "mcp-config.json")
+	extraPath := filepath.Join(dir, "home", "AGENTS.md")
+	source := filepath.Join(dir, "AGENTS.md")
+	writeFile(t, agentPath, `{"theme": "dark"}`)
+	writeFile(t, source, "hello")
+	inputs := &Inputs{
+		ConfigPath: filepath.Join(dir, "agent-align.yml"),
+		Agents:     []AgentTarget{{Name: "copilot", PathOverride: agentPath}},
+		Extra: ExtraTargetsConfig{Files: []config.ExtraFileTarget{{
+			Source:       source,
+			Destinations: []config.ExtraFileCopyRoute{{Path: extraPath}},
+		}}},
+		Servers: mcpconfig.Servers{{Name: "a", Command: "npx"}},
+	}
+
+	for _, emptyBase := range []bool{false, true} {
+		stage := filepath.Join(t.TempDir(), "stage")
+		engine := New(Options{OutputDir: stage, EmptyBase: emptyBase})
+		plan, err := engine.Plan(context.Background(), inputs)
+		if err != nil {
+			t.Fatalf("Plan returned error: %v", err)
+		}
+		result, err := engine.Apply(context.Background(), plan)
+		if err != nil || len(result.Failed()) > 0 {
+			t.Fatalf("Apply failed: %v %+v", err, result.Failed())
+		}
+
+		staged, err := os.ReadFile(filepath.Join(stage, agentPath))
+		if err != nil {
+			t.Fatalf("agent file was not staged: %v", err)
+		}
+		if strings.Contains(string(staged), "theme") == emptyBase {
+			t.Fatalf("emptyBase=%v: unexpected staged agent file:\n%s", emptyBase, staged)
+		}
+		if data, err := os.ReadFile(filepath.Join(stage, extraPath)); err != nil || string(data) != "hello" {
+			t.Fatalf("extra file was not staged: %q, %v", data, err)
+		}
+		if data, _ := os.ReadFile(agentPath); string(data) != `{"theme": "dark"}` {
+			t.Fatalf("the real agent file changed:\n%s", data)
+		}
+		if _, err := os.Stat(extraPath); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("the real extra destination was written: %v", err)
+		}
+	}
+}
+
+func TestHomeAndRootPlaceDefaultAgentFiles(t *testing.T) {
+	root := t.TempDir()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, "mcpServers:\n  targets:\n    agents: [copilot]\n    additionalTargets:\n      json:\n        - filePath: ~/.config/tool.json\n          jsonPath: servers\n")
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), "servers:\n  a:\n    command: npx\n")
+	writeFile(t, filepath.Join(root, "home", "alice", ".config", "tool.json"), `{"keep": true}`)
+
+	t.Setenv("AGENT_ALIGN_HOME", "/home/alice")
+	engine := New(Options{Root: root})
+	inputs, err := engine.Load(context.Background(), LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	plan, err := engine.Plan(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	result, err := engine.Apply(context.Background(), plan)
+	if err != nil || len(result.Failed()) > 0 {
+		t.Fatalf("Apply failed: %v %+v", err, result.Failed())
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "home", "alice", ".copilot", "mcp-config.json")); err != nil {
+		t.Fatalf("copilot file was not placed below the root: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "home", "alice", ".config", "tool.json"))
+	if err != nil || !strings.Contains(string(data), `"keep": true`) || !strings.Contains(string(data), `"a"`) {
+		t.Fatalf("additional target was not merged below the root: %s, %v", data, err)
+	}
+
+	target, err := New(Options{Home: "/home/bob"}).Render(context.Background(), inputs, RenderOptions{Agent: "copilot", NoMerge: true})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if want := filepath.Join("/home/bob", ".copilot", "mcp-config.json"); target.Path != want {
+		t.Fatalf("Home was not used for the default path: got %s, want %s", target.Path, want)
+	}
+}
+
+func TestRootResolvesLinksInsideTheRoot(t *testing.T) {
+	root := t.TempDir()
+	host := t.TempDir()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, "mcpServers:\n  targets:\n    agents: [codex, copilot]\n")
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), "servers:\n  a:\n    command: npx\n")
+	// Absolute links in a root filesystem refer to its own files.
+	if err := os.MkdirAll(filepath.Join(root, "home", "alice"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(host, filepath.Join(root, "home", "alice", ".codex")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../../../..", filepath.Join(root, "home", "alice", ".copilot")); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := New(Options{Root: root, Home: "/home/alice"})
+	inputs, err := engine.Load(context.Background(), LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	plan, err := engine.Plan(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	result, err := engine.Apply(context.Background(), plan)
+	if err != nil || len(result.Failed()) > 0 {
+		t.Fatalf("Apply failed: %v %+v", err, result.Failed())
+	}
+
+	if _, err := os.Stat(filepath.Join(host, "config.toml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("an absolute link led out of the root: %v", err)
+	}
+	for _, path := range []string{filepath.Join(root, host, "config.toml"), filepath.Join(root, "mcp-config.json")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("%s was not written inside the root: %v", path, err)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package agentalign
 
 import _ "embed"
 
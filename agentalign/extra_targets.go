@@ -1,10 +1,11 @@
-package main
+package agentalign
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -12,6 +13,8 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"agent-align/internal/config"
+	"agent-align/internal/fsys"
+	"agent-align/internal/mcpconfig"
 )
 
 const minFrontmatterLength = 10 // "---\nx\n---" minimum valid frontmatter
@@ -69,48 +72,63 @@ func matchGlob(pattern, path string) bool {
 	return matched
 }
 
-func copyExtraFileTarget(target config.ExtraFileTarget, configDir string, mcpServers map[string]interface{}) error {
-	info, err := os.Stat(target.Source)
-	if err != nil {
-		return fmt.Errorf("failed to inspect %s: %w", target.Source, err)
-	}
-	if info.IsDir() {
-		return fmt.Errorf("extra file target %s is a directory; use directories instead", target.Source)
+// planExtraFileTarget renders one target per destination of an extra file target.
+func (e *Engine) planExtraFileTarget(target config.ExtraFileTarget, configDir string, mcpServers mcpconfig.Servers) []Target {
+	targets := make([]Target, 0, len(target.Destinations))
+	info, err := e.fs.Stat(target.Source)
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("extra file target %s is a directory; use directories instead", target.Source)
+	} else if err != nil {
+		err = fmt.Errorf("failed to inspect %s: %w", target.Source, err)
 	}
 	for _, dest := range target.Destinations {
-		if err := copyFileContentsWithSkillsAndFrontmatter(target.Source, dest, info.Mode(), configDir, mcpServers); err != nil {
-			return fmt.Errorf("failed to copy %s to %s: %w", target.Source, dest.Path, err)
+		t := Target{Kind: KindExtraFile, Path: dest.Path, Source: target.Source, Err: err}
+		if err == nil {
+			t.Mode = info.Mode().Perm()
+			content, renderErr := e.renderExtraFile(target.Source, dest, configDir, mcpServers)
+			if renderErr != nil {
+				t.Err = fmt.Errorf("failed to copy %s to %s: %w", target.Source, dest.Path, renderErr)
+			}
+			t.Content = content
 		}
+		targets = append(targets, t)
 	}
-	return nil
+	return targets
 }
 
-func copyExtraDirectoryTarget(target config.ExtraDirectoryTarget) (int, error) {
-	sourceInfo, err := os.Stat(target.Source)
-	if err != nil {
-		return 0, fmt.Errorf("failed to inspect %s: %w", target.Source, err)
+// planExtraDirectoryTarget lists the file copies for every destination of an
+// extra directory target.
+func (e *Engine) planExtraDirectoryTarget(ctx context.Context, target config.ExtraDirectoryTarget) []Target {
+	targets := make([]Target, 0, len(target.Destinations))
+	sourceInfo, err := e.fs.Stat(target.Source)
+	if err == nil && !sourceInfo.IsDir() {
+		err = fmt.Errorf("extra directory target %s is not a directory", target.Source)
+	} else if err != nil {
+		err = fmt.Errorf("failed to inspect %s: %w", target.Source, err)
 	}
-	if !sourceInfo.IsDir() {
-		return 0, fmt.Errorf("extra directory target %s is not a directory", target.Source)
-	}
-
-	var total int
 	for _, dest := range target.Destinations {
-		count, err := copyDirectory(target.Source, dest.Path, dest.Flatten, dest.ExcludeGlobs)
-		if err != nil {
-			return total, fmt.Errorf("failed to copy directory %s to %s: %w", target.Source, dest.Path, err)
+		t := Target{Kind: KindExtraDirectory, Path: dest.Path, Source: target.Source, Flatten: dest.Flatten, Err: err}
+		if err == nil {
+			files, listErr := e.listDirectoryCopies(ctx, target.Source, dest.Path, dest.Flatten, dest.ExcludeGlobs)
+			if listErr != nil {
+				t.Err = fmt.Errorf("failed to copy directory %s to %s: %w", target.Source, dest.Path, listErr)
+			}
+			t.Files = files
 		}
-		total += count
+		targets = append(targets, t)
 	}
-	return total, nil
+	return targets
 }
 
-func copyDirectory(source, destination string, flatten bool, excludeGlobs []string) (int, error) {
-	var copied int
-	walkErr := filepath.WalkDir(source, func(path string, entry fs.DirEntry, walkErr error) error {
+func (e *Engine) listDirectoryCopies(ctx context.Context, source, destination string, flatten bool, excludeGlobs []string) ([]FileCopy, error) {
+	var files []FileCopy
+	walkErr := e.fs.WalkDir(source, func(path string, entry fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if entry.IsDir() {
 			return nil
 		}
@@ -139,91 +157,57 @@ func copyDirectory(source, destination string, flatten bool, excludeGlobs []stri
 		if err != nil {
 			return err
 		}
-		if err := copyFileContents(path, destPath, info.Mode()); err != nil {
-			return err
-		}
-		copied++
+		files = append(files, FileCopy{Source: path, Path: destPath, Mode: info.Mode(), Size: info.Size(), Changed: true})
 		return nil
 	})
 	if walkErr != nil {
-		return copied, walkErr
+		return files, walkErr
 	}
-	return copied, nil
+	return files, nil
 }
 
-func copyFileContentsWithSkillsAndFrontmatter(source string, dest config.ExtraFileCopyRoute, mode os.FileMode, configDir string, mcpServers map[string]interface{}) error {
+// renderExtraFile builds the content written to a single extra file destination.
+func (e *Engine) renderExtraFile(source string, dest config.ExtraFileCopyRoute, configDir string, mcpServers mcpconfig.Servers) (string, error) {
 	// Read source file content
-	sourceData, err := os.ReadFile(source)
+	sourceData, err := e.fs.ReadFile(source)
 	if err != nil {
-		return err
-	}
-
-	if err := os.MkdirAll(filepath.Dir(dest.Path), 0o755); err != nil {
-		return fmt.Errorf("failed to create directory for %s: %w", dest.Path, err)
+		return "", err
 	}
 
-	out, err := os.OpenFile(dest.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
-	if err != nil {
-		return fmt.Errorf("failed to open %s: %w", dest.Path, err)
-	}
-	defer out.Close()
+	var out bytes.Buffer
 
 	// If FrontmatterPath is specified, use frontmatter template processing
 	if dest.FrontmatterPath != "" {
-		if err := processFrontmatterTemplate(out, dest.FrontmatterPath, string(sourceData), mcpServers); err != nil {
-			return fmt.Errorf("failed to process frontmatter template: %w", err)
-		}
-	} else {
-		// Otherwise, copy source content directly
-		if _, err := out.Write(sourceData); err != nil {
-			return fmt.Errorf("failed to copy %s to %s: %w", source, dest.Path, err)
-		}
-
-		// If PathToSkills is specified (deprecated), append skills content
-		if dest.PathToSkills != "" {
-			if err := appendSkillsContent(out, dest.PathToSkills, configDir, nil); err != nil {
-				return fmt.Errorf("failed to append skills content: %w", err)
-			}
-		}
-
-		// If AppendSkills is specified (new format), append skills content with filtering
-		for _, appendSkill := range dest.AppendSkills {
-			if err := appendSkillsContent(out, appendSkill.Path, configDir, appendSkill.IgnoredSkills); err != nil {
-				return fmt.Errorf("failed to append skills content from %s: %w", appendSkill.Path, err)
-			}
+		if err := e.processFrontmatterTemplate(&out, dest.FrontmatterPath, string(sourceData), mcpServers); err != nil {
+			return "", fmt.Errorf("failed to process frontmatter template: %w", err)
 		}
+		return out.String(), nil
 	}
 
-	return nil
-}
+	// Otherwise, copy source content directly
+	out.Write(sourceData)
 
-func copyFileContents(source, dest string, mode os.FileMode) error {
-	in, err := os.Open(source)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
-		return fmt.Errorf("failed to create directory for %s: %w", dest, err)
+	// If PathToSkills is specified (deprecated), append skills content
+	if dest.PathToSkills != "" {
+		if err := e.appendSkillsContent(&out, dest.PathToSkills, configDir, nil); err != nil {
+			return "", fmt.Errorf("failed to append skills content: %w", err)
+		}
 	}
 
-	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
-	if err != nil {
-		return fmt.Errorf("failed to open %s: %w", dest, err)
+	// If AppendSkills is specified (new format), append skills content with filtering
+	for _, appendSkill := range dest.AppendSkills {
+		if err := e.appendSkillsContent(&out, appendSkill.Path, configDir, appendSkill.IgnoredSkills); err != nil {
+			return "", fmt.Errorf("failed to append skills content from %s: %w", appendSkill.Path, err)
+		}
 	}
-	defer out.Close()
 
-	if _, err := io.Copy(out, in); err != nil {
-		return fmt.Errorf("failed to copy %s to %s: %w", source, dest, err)
-	}
-	return nil
+	return out.String(), nil
 }
 
 // processFrontmatterTemplate processes a frontmatter template file, replacing [CONTENT] and [MCP] placeholders
-func processFrontmatterTemplate(out *os.File, frontmatterPath, sourceContent string, mcpServers map[string]interface{}) error {
+func (e *Engine) processFrontmatterTemplate(out io.Writer, frontmatterPath, sourceContent string, mcpServers mcpconfig.Servers) error {
 	// Read the frontmatter template
-	templateData, err := os.ReadFile(frontmatterPath)
+	templateData, err := e.fs.ReadFile(frontmatterPath)
 	if err != nil {
 		return fmt.Errorf("failed to read frontmatter template %s: %w", frontmatterPath, err)
 	}
@@ -235,7 +219,7 @@ func processFrontmatterTemplate(out *os.File, frontmatterPath, sourceContent str
 
 	// Build MCP server list in the format 'server_name/*'
 	var mcpList []string
-	for serverName := range mcpServers {
+	for _, serverName := range mcpServers.Names() {
 		mcpList = append(mcpList, fmt.Sprintf("'%s/*'", serverName))
 	}
 
@@ -247,7 +231,7 @@ func processFrontmatterTemplate(out *os.File, frontmatterPath, sourceContent str
 	template = strings.ReplaceAll(template, "[MCP]", mcpReplacement)
 
 	// Write the processed template to the output file
-	if _, err := out.WriteString(template); err != nil {
+	if _, err := io.WriteString(out, template); err != nil {
 		return fmt.Errorf("failed to write processed template: %w", err)
 	}
 
@@ -255,22 +239,21 @@ func processFrontmatterTemplate(out *os.File, frontmatterPath, sourceContent str
 }
 
 // appendSkillsContent reads skills.md from configDir and appends it along with discovered SKILL.md files
-func appendSkillsContent(out *os.File, pathToSkills, configDir string, ignoredSkills []string) error {
+func (e *Engine) appendSkillsContent(out io.Writer, pathToSkills, configDir string, ignoredSkills []string) error {
 	// First, try to read and append the skills.md template from configDir. If it
 	// doesn't exist, fall back to the embedded default so the binary can be
 	// distributed standalone.
 	skillsTemplatePath := filepath.Join(configDir, "skills.md")
-	templateData, err := os.ReadFile(skillsTemplatePath)
+	templateData, err := fsys.ReadIfExists(e.fs, skillsTemplatePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			templateData = []byte(embeddedSkillsMD)
-		} else {
-			return fmt.Errorf("failed to read skills template %s: %w", skillsTemplatePath, err)
-		}
+		return fmt.Errorf("failed to read skills template %s: %w", skillsTemplatePath, err)
+	}
+	if templateData == nil {
+		templateData = []byte(embeddedSkillsMD)
 	}
 
 	// Write a newline before appending to ensure separation
-	if _, err := out.WriteString("\n"); err != nil {
+	if _, err := io.WriteString(out, "\n"); err != nil {
 		return err
 	}
 
@@ -279,14 +262,14 @@ func appendSkillsContent(out *os.File, pathToSkills, configDir string, ignoredSk
 	}
 
 	// Discover and append SKILL.md files from pathToSkills
-	skills, err := discoverSkills(pathToSkills, ignoredSkills)
+	skills, err := e.discoverSkills(pathToSkills, ignoredSkills)
 	if err != nil {
 		return fmt.Errorf("failed to discover skills: %w", err)
 	}
 
 	for _, skill := range skills {
 		skillSection := fmt.Sprintf("\n### **Skill: %s**\n**Description / Use when:**  \n%s\n", skill.Name, skill.Description)
-		if _, err := out.WriteString(skillSection); err != nil {
+		if _, err := io.WriteString(out, skillSection); err != nil {
 			return fmt.Errorf("failed to write skill %s: %w", skill.Name, err)
 		}
 	}
@@ -301,7 +284,7 @@ type Skill struct {
 }
 
 // discoverSkills walks the pathToSkills directory and finds all SKILL.md files
-func discoverSkills(pathToSkills string, ignoredSkills []string) ([]Skill, error) {
+func (e *Engine) discoverSkills(pathToSkills string, ignoredSkills []string) ([]Skill, error) {
 	var skills []Skill
 
 	// Create a map for faster lookup of ignored skills
@@ -310,7 +293,7 @@ func discoverSkills(pathToSkills string, ignoredSkills []string) ([]Skill, error
 		ignoredMap[ignored] = true
 	}
 
-	err := filepath.WalkDir(pathToSkills, func(path string, entry fs.DirEntry, walkErr error) error {
+	err := e.fs.WalkDir(pathToSkills, func(path string, entry fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
@@ -321,10 +304,10 @@ func discoverSkills(pathToSkills string, ignoredSkills []string) ([]Skill, error
 			return nil
 		}
 
-		skill, err := parseSkillFile(path)
+		skill, err := e.parseSkillFile(path)
 		if err != nil {
 			// Log but don't fail on individual skill parsing errors
-			fmt.Fprintf(os.Stderr, "Warning: failed to parse skill file %s: %v\n", path, err)
+			e.logger.Printf("Warning: failed to parse skill file %s: %v", path, err)
 			return nil
 		}
 
@@ -341,8 +324,8 @@ func discoverSkills(pathToSkills string, ignoredSkills []string) ([]Skill, error
 }
 
 // parseSkillFile reads a SKILL.md file and extracts name and description from frontmatter
-func parseSkillFile(path string) (Skill, error) {
-	data, err := os.ReadFile(path)
+func (e *Engine) parseSkillFile(path string) (Skill, error) {
+	data, err := e.fs.ReadFile(path)
 	if err != nil {
 		return Skill{}, err
 	}
@@ -1,14 +1,59 @@
-package main
+package agentalign
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"agent-align/internal/config"
+	"agent-align/internal/mcpconfig"
 )
 
+// copyExtraFileTarget plans and applies every destination of an extra file target.
+func copyExtraFileTarget(target config.ExtraFileTarget, configDir string, mcpServers mcpconfig.Servers) error {
+	e := New(Options{})
+	plan := &Plan{Targets: e.planExtraFileTarget(target, configDir, mcpServers)}
+	return applyPlan(e, plan)
+}
+
+// copyExtraDirectoryTarget plans and applies every destination of an extra
+// directory target and returns the number of files copied.
+func copyExtraDirectoryTarget(target config.ExtraDirectoryTarget) (int, error) {
+	e := New(Options{})
+	plan := &Plan{Targets: e.planExtraDirectoryTarget(context.Background(), target)}
+	for i := range plan.Targets {
+		e.detectChanges(&plan.Targets[i])
+	}
+	result, err := e.Apply(context.Background(), plan)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, tr := range result.Targets {
+		if tr.Err != nil {
+			return total, tr.Err
+		}
+		total += tr.Files
+	}
+	return total, nil
+}
+
+func applyPlan(e *Engine, plan *Plan) error {
+	for i := range plan.Targets {
+		e.detectChanges(&plan.Targets[i])
+	}
+	result, err := e.Apply(context.Background(), plan)
+	if err != nil {
+		return err
+	}
+	if failed := result.Failed(); len(failed) > 0 {
+		return failed[0].Err
+	}
+	return nil
+}
+
 func TestCopyExtraFileTarget(t *testing.T) {
 	dir := t.TempDir()
 	source := filepath.Join(dir, "AGENTS.md")
@@ -25,7 +70,7 @@ func TestCopyExtraFileTarget(t *testing.T) {
 			{Path: dest2},
 		},
 	}
-	mcpServers := map[string]interface{}{}
+	var mcpServers mcpconfig.Servers
 	if err := copyExtraFileTarget(target, dir, mcpServers); err != nil {
 		t.Fatalf("copyExtraFileTarget returned error: %v", err)
 	}
@@ -339,7 +384,7 @@ description: Use when reviewing code for best practices and common issues
 		},
 	}
 
-	mcpServers := map[string]interface{}{}
+	var mcpServers mcpconfig.Servers
 	if err := copyExtraFileTarget(target, dir, mcpServers); err != nil {
 		t.Fatalf("copyExtraFileTarget returned error: %v", err)
 	}
@@ -399,7 +444,7 @@ func TestCopyExtraFileTargetMixedDestinations(t *testing.T) {
 		},
 	}
 
-	mcpServers := map[string]interface{}{}
+	var mcpServers mcpconfig.Servers
 	if err := copyExtraFileTarget(target, dir, mcpServers); err != nil {
 		t.Fatalf("copyExtraFileTarget returned error: %v", err)
 	}
@@ -425,11 +470,11 @@ func TestCopyExtraFileTargetMixedDestinations(t *testing.T) {
 
 func TestParseFrontmatter(t *testing.T) {
 	tests := []struct {
-		name        string
-		content     string
-		wantName    string
-		wantDesc    string
-		wantErr     bool
+		name     string
+		content  string
+		wantName string
+		wantDesc string
+		wantErr  bool
 	}{
 		{
 			name: "valid frontmatter",
@@ -444,9 +489,9 @@ description: A test skill description
 			wantErr:  false,
 		},
 		{
-			name:     "missing frontmatter",
-			content:  "# No frontmatter",
-			wantErr:  true,
+			name:    "missing frontmatter",
+			content: "# No frontmatter",
+			wantErr: true,
 		},
 		{
 			name: "missing closing delimiter",
@@ -527,10 +572,10 @@ tools: ['edit', 'view', [MCP]]
 	}
 
 	// Create MCP servers
-	mcpServers := map[string]interface{}{
-		"github":  map[string]interface{}{"command": "npx"},
-		"azure":   map[string]interface{}{"command": "docker"},
-		"qdrant":  map[string]interface{}{"command": "uvx"},
+	mcpServers := mcpconfig.Servers{
+		{Name: "github", Command: "npx"},
+		{Name: "azure", Command: "docker"},
+		{Name: "qdrant", Command: "uvx"},
 	}
 
 	if err := copyExtraFileTarget(target, dir, mcpServers); err != nil {
@@ -608,7 +653,7 @@ description: Second skill
 		t.Fatalf("failed to write README: %v", err)
 	}
 
-	skills, err := discoverSkills(dir, nil)
+	skills, err := New(Options{}).discoverSkills(dir, nil)
 	if err != nil {
 		t.Fatalf("discoverSkills returned error: %v", err)
 	}
@@ -671,7 +716,7 @@ description: Third skill
 
 	// Test with ignore list
 	ignoredSkills := []string{"skill-two"}
-	skills, err := discoverSkills(dir, ignoredSkills)
+	skills, err := New(Options{}).discoverSkills(dir, ignoredSkills)
 	if err != nil {
 		t.Fatalf("discoverSkills returned error: %v", err)
 	}
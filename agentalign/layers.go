@@ -0,0 +1,106 @@
+package agentalign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"agent-align/internal/config"
+	"agent-align/internal/fsys"
+	"agent-align/internal/mcpconfig"
+)
+
+// errNoConfig reports that none of the discovered config layers exists.
+var errNoConfig = errors.New("no config file found")
+
+// Effective returns the configuration Load uses for opts, merged from its
+// layers, with the file every value came from. Without Discover it is
+// ConfigPath alone.
+func (e *Engine) Effective(ctx context.Context, opts LoadOptions) (MergedConfig, error) {
+	if err := ctx.Err(); err != nil {
+		return MergedConfig{}, err
+	}
+	return e.loadLayers(opts)
+}
+
+// ConfigLayers returns the config files Load reads for opts, lowest
+// precedence first, whether or not they exist.
+func (e *Engine) ConfigLayers(opts LoadOptions) []ConfigLayer {
+	if e.layers != nil {
+		return e.layers
+	}
+	if !opts.Discover {
+		return []ConfigLayer{{Name: config.LayerSystem, Path: opts.ConfigPath}}
+	}
+	return config.Layers(opts.ConfigPath, e.home, opts.ProjectDir)
+}
+
+// loadLayers reads and merges the config layers of opts. Missing layers are
+// skipped when discovering.
+func (e *Engine) loadLayers(opts LoadOptions) (MergedConfig, error) {
+	layers := e.ConfigLayers(opts)
+	var found []config.LayerData
+	var paths []string
+	for _, layer := range layers {
+		paths = append(paths, layer.Path)
+		data, err := fsys.ReadIfExists(e.fs, layer.Path)
+		if err != nil {
+			return MergedConfig{}, fmt.Errorf("failed to load config %q: %w", layer.Path, err)
+		}
+		if data == nil && !opts.Discover {
+			return MergedConfig{}, fmt.Errorf("failed to load config %q: %w", layer.Path, fs.ErrNotExist)
+		}
+		if data != nil {
+			found = append(found, config.LayerData{Layer: layer, Data: data})
+		}
+	}
+	if len(found) == 0 {
+		return MergedConfig{}, fmt.Errorf("%w: looked for %s", errNoConfig, strings.Join(paths, ", "))
+	}
+	merged, err := config.MergeLayers(found, e.home)
+	if err != nil {
+		return MergedConfig{}, fmt.Errorf("failed to load config: %w", err)
+	}
+	return merged, nil
+}
+
+// loadMCPSources reads the MCP definitions of every config layer and returns
+// the merged servers with the last file read. When the project config has
+// servers, they are merged into projectServers only, over the others.
+func (e *Engine) loadMCPSources(sources []config.MCPSource) (Servers, Servers, string, error) {
+	var (
+		servers        Servers
+		projectServers Servers
+		last           string
+		paths          []string
+	)
+	for _, source := range sources {
+		paths = append(paths, source.Path)
+		data, err := e.fs.ReadFile(source.Path)
+		if err != nil {
+			if source.Optional && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, nil, "", fmt.Errorf("failed to load MCP configuration %q: %w", source.Path, err)
+		}
+		parsed, err := mcpconfig.Parse(source.Path, data)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to load MCP configuration %q: %w", source.Path, err)
+		}
+		if source.Project {
+			if projectServers == nil {
+				projectServers = servers
+			}
+			projectServers = projectServers.Merge(parsed)
+		} else {
+			servers = servers.Merge(parsed)
+		}
+		last = source.Path
+	}
+	if last == "" {
+		return nil, nil, "", fmt.Errorf("failed to load MCP configuration: none of %s exists", strings.Join(paths, ", "))
+	}
+	return servers, projectServers, last, nil
+}
@@ -0,0 +1,71 @@
+package agentalign
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDiscoversConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home")
+	systemPath := filepath.Join(dir, "etc", "agent-align.yml")
+	writeFile(t, systemPath, "mcpServers:\n  targets:\n    agents: [codex]\n")
+	writeFile(t, filepath.Join(dir, "etc", "agent-align-mcp.yml"), "servers:\n  a:\n    command: system\n  b:\n    command: system\n")
+	writeFile(t, filepath.Join(home, ".config", "agent-align", "config.yml"), "mcpServers:\n  configPath: ~/servers.yml\n  targets:\n    agents: [gemini]\n")
+	writeFile(t, filepath.Join(home, "servers.yml"), "servers:\n  a:\n    command: user\n")
+	projectPath := filepath.Join(dir, "repo", ".agent-align.yml")
+	writeFile(t, projectPath, "mcpServers:\n  targets:\n    agents: [claudecode]\n")
+	writeFile(t, filepath.Join(dir, "repo", "agent-align-mcp.yml"), "servers:\n  c:\n    command: project\n")
+
+	engine := New(Options{Home: home})
+	opts := LoadOptions{ConfigPath: systemPath, Discover: true, ProjectDir: filepath.Join(dir, "repo", "src")}
+	inputs, err := engine.Load(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	var agents []string
+	for _, agent := range inputs.Agents {
+		agents = append(agents, agent.Name)
+	}
+	if want := []string{"codex", "gemini", "claudecode"}; !reflect.DeepEqual(agents, want) {
+		t.Fatalf("agents = %v, want %v", agents, want)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(inputs.Servers.Names(), want) {
+		t.Fatalf("servers = %v, want %v", inputs.Servers.Names(), want)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(inputs.ProjectServers.Names(), want) {
+		t.Fatalf("project servers = %v, want %v", inputs.ProjectServers.Names(), want)
+	}
+	if server, _ := inputs.Servers.Get("a"); server.Command != "user" {
+		t.Fatalf("the user layer did not replace server a: %+v", server)
+	}
+	if inputs.ConfigPath != projectPath {
+		t.Fatalf("ConfigPath = %s, want the project config", inputs.ConfigPath)
+	}
+
+	// The project's servers only reach the project's files.
+	plan, err := engine.Plan(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	for _, target := range plan.Targets {
+		if target.Err != nil {
+			t.Fatalf("%s failed: %v", target.Path, target.Err)
+		}
+		if project := target.Scope == "project"; project != strings.Contains(target.Content, `"project"`) {
+			t.Fatalf("%s (scope %q) has the wrong servers:\n%s", target.Path, target.Scope, target.Content)
+		}
+	}
+
+	writeFile(t, projectPath, "extraTargets:\n  files:\n    - source: /etc/shadow\n      destinations: [~/shadow]\n")
+	if _, err := engine.Load(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "extraTargets") {
+		t.Fatalf("expected the project config's extraTargets to be refused, got %v", err)
+	}
+
+	if _, err := New(Options{Home: filepath.Join(dir, "nobody")}).Load(context.Background(), LoadOptions{ConfigPath: filepath.Join(dir, "missing.yml"), Discover: true}); err == nil {
+		t.Fatal("expected an error when no layer exists")
+	}
+}
@@ -0,0 +1,119 @@
+package agentalign
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"agent-align/internal/syncer"
+)
+
+// projectTargets turns the agent targets into those of a project-only sync.
+// Agents with a project-scope target keep only those; the user targets of
+// the other agents move to the agent's project file and lose their path and
+// Claude Code projects.
+// Agents without a project file are skipped.
+func (e *Engine) projectTargets(targets []AgentTarget) []AgentTarget {
+	explicit := make(map[string]bool)
+	for _, target := range targets {
+		if target.Scope == syncer.ScopeProject {
+			explicit[target.Name] = true
+		}
+	}
+	supported := syncer.SupportedAgents()
+	skipped := make(map[string]bool)
+	var out []AgentTarget
+	for _, target := range targets {
+		if slices.Contains(supported, target.Name) && !syncer.HasProjectConfig(target.Name) {
+			if !skipped[target.Name] {
+				e.logger.Printf("warning: skipping %s: it has no project-level config", target.Name)
+				skipped[target.Name] = true
+			}
+			continue
+		}
+		if target.Scope != syncer.ScopeProject {
+			if explicit[target.Name] {
+				continue
+			}
+			target.Scope, target.PathOverride, target.Projects = syncer.ScopeProject, "", nil
+		}
+		out = append(out, target)
+	}
+	return out
+}
+
+// warnUnignoredSecrets warns about project-level files that would hold
+// values expanded from environment variables, such as API tokens, but are
+// not ignored by git and so could be committed. Nothing is reported when git
+// cannot tell, for example outside a work tree.
+func (e *Engine) warnUnignoredSecrets(plan *Plan) {
+	servers := plan.Inputs.Servers
+	if plan.Inputs.ProjectServers != nil {
+		servers = plan.Inputs.ProjectServers
+	}
+	var secrets []string
+	for _, server := range servers {
+		secrets = append(secrets, server.Expanded...)
+	}
+	if len(secrets) == 0 {
+		return
+	}
+	for _, target := range plan.Targets {
+		if target.Kind != KindAgent || target.Scope != syncer.ScopeProject || target.Err != nil {
+			continue
+		}
+		if !containsAny(target.Content, secrets) {
+			continue
+		}
+		if ignored, known := gitIgnored(filepath.Join(e.root, target.Path)); known && !ignored {
+			e.logger.Printf("warning: %s contains values expanded from environment variables and is not ignored by git; add it to .gitignore or reference the variables instead", target.Path)
+		}
+	}
+}
+
+// containsAny reports whether content holds any of the values, as is or
+// escaped as a JSON string.
+func containsAny(content string, values []string) bool {
+	for _, value := range values {
+		if strings.Contains(content, value) {
+			return true
+		}
+		if quoted, err := json.Marshal(value); err == nil && strings.Contains(content, string(quoted[1:len(quoted)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitIgnored reports whether git ignores path, which need not exist yet. The
+// second result is false when git cannot tell.
+func gitIgnored(path string) (ignored, known bool) {
+	dir := filepath.Dir(path)
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false, false
+		}
+		dir = parent
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false, false
+	}
+	err = exec.Command("git", "-C", dir, "check-ignore", "-q", "--", rel).Run()
+	if err == nil {
+		return true, true
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,113 @@
+package agentalign
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type logRecorder []string
+
+func (l *logRecorder) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func TestLoadProjectSync(t *testing.T) {
+	dir := t.TempDir()
+	project := filepath.Join(dir, "repo")
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, `mcpServers:
+  targets:
+    agents:
+      - codex
+      - vscode
+      - name: claudecode
+        path: `+filepath.Join(dir, "claude.json")+`
+      - gemini
+      - name: gemini
+        scope: project
+        path: config/gemini.json
+    additionalTargets:
+      json:
+        - filePath: `+filepath.Join(dir, "tool.json")+`
+          jsonPath: servers
+`)
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), "servers:\n  a:\n    command: npx\n")
+
+	var logs logRecorder
+	engine := New(Options{Home: filepath.Join(dir, "home"), Logger: &logs})
+	inputs, err := engine.Load(context.Background(), LoadOptions{ConfigPath: configPath, Project: project})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if inputs.ProjectDir != project || len(inputs.Additional) != 0 {
+		t.Fatalf("unexpected inputs: project %q, additional %v", inputs.ProjectDir, inputs.Additional)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "skipping codex") {
+		t.Fatalf("expected a warning about codex, got %v", logs)
+	}
+
+	plan, err := engine.Plan(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	want := map[string]string{
+		"vscode":     filepath.Join(project, ".vscode", "mcp.json"),
+		"claudecode": filepath.Join(project, ".mcp.json"),
+		"gemini":     filepath.Join(project, "config", "gemini.json"),
+	}
+	if len(plan.Targets) != len(want) {
+		t.Fatalf("expected %d targets, got %+v", len(want), plan.Targets)
+	}
+	for _, target := range plan.Targets {
+		if target.Err != nil || target.Scope != "project" || target.Path != want[target.Agent] {
+			t.Errorf("unexpected %s target: %s (%q) %v", target.Agent, target.Path, target.Scope, target.Err)
+		}
+	}
+}
+
+func TestPlanWarnsAboutUnignoredSecrets(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	project := filepath.Join(dir, "repo")
+	if out, err := exec.Command("git", "init", "-q", project).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\n%s", err, out)
+	}
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, "mcpServers:\n  targets:\n    agents: [claudecode, vscode]\n")
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), `servers:
+  api:
+    command: npx
+    env:
+      TOKEN: ${PROJECT_TOKEN}
+`)
+	t.Setenv("PROJECT_TOKEN", `tok"123`)
+	writeFile(t, filepath.Join(project, ".gitignore"), ".vscode/\n")
+
+	var logs logRecorder
+	engine := New(Options{Logger: &logs})
+	inputs, err := engine.Load(context.Background(), LoadOptions{ConfigPath: configPath, Project: project})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if _, err := engine.Plan(context.Background(), inputs); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], filepath.Join(project, ".mcp.json")) {
+		t.Fatalf("expected a single warning about the .mcp.json file, got %v", logs)
+	}
+
+	writeFile(t, filepath.Join(project, ".gitignore"), ".vscode/\n.mcp.json\n")
+	logs = nil
+	if _, err := engine.Plan(context.Background(), inputs); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if len(logs) != 0 {
+		t.Fatalf("expected no warning once the file is ignored, got %v", logs)
+	}
+}
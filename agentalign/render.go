@@ -0,0 +1,140 @@
+package agentalign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// RenderOptions selects the single target Render produces.
+type RenderOptions struct {
+	// Agent renders the file of the named agent. Path replaces its
+	// destination and picks between several configured targets of the agent.
+	Agent string
+	Path  string
+	// Additional renders the file written by the additional targets whose
+	// filePath is this path.
+	Additional string
+	// NoMerge renders from scratch instead of merging into the existing file.
+	NoMerge bool
+	// Settings renders the settings file an agent writes next to its MCP
+	// file, such as the permissions and disabledMcpjsonServers Claude Code
+	// keeps in .claude/settings.json, instead of the MCP file.
+	Settings bool
+}
+
+// Render runs the pipeline of Plan for one destination and returns its
+// target without writing anything. The existing file is only read as the
+// merge base, and not at all with NoMerge. When several additional targets
+// write the same file, each one is merged into the output of the previous.
+// An agent that writes a settings file as well, like claudecode, renders
+// its MCP file unless Settings is set; a settings file that would not change
+// is reported as an error.
+func (e *Engine) Render(ctx context.Context, in *Inputs, opts RenderOptions) (Target, error) {
+	agent := strings.ToLower(strings.TrimSpace(opts.Agent))
+	additional := strings.TrimSpace(opts.Additional)
+	switch {
+	case agent != "" && additional != "":
+		return Target{}, errors.New("render either an agent or an additional target, not both")
+	case agent == "" && additional == "":
+		return Target{}, errors.New("render needs an agent or an additional target")
+	case opts.Settings && agent == "":
+		return Target{}, errors.New("only an agent has a settings file to render")
+	}
+
+	base := &mergeBaseFS{FS: e.base, noMerge: opts.NoMerge, files: make(map[string][]byte)}
+	renderer := &Engine{fs: e.fs, base: base, out: e.out, logger: e.logger, force: e.force, home: e.home, user: e.user, root: e.root, statePath: e.statePath}
+	inputs := &Inputs{ConfigPath: in.ConfigPath, MCPConfigPath: in.MCPConfigPath, ProjectDir: in.ProjectDir, Servers: in.Servers, ProjectServers: in.ProjectServers}
+
+	if agent != "" {
+		target, err := selectAgentTarget(in.Agents, agent, strings.TrimSpace(opts.Path))
+		if err != nil {
+			return Target{}, err
+		}
+		inputs.Agents = []AgentTarget{target}
+		plan, err := renderer.Plan(ctx, inputs)
+		if err != nil {
+			return Target{}, err
+		}
+		if !opts.Settings || plan.Targets[0].Err != nil {
+			return plan.Targets[0], plan.Targets[0].Err
+		}
+		if len(plan.Targets) < 2 {
+			return Target{}, fmt.Errorf("agent %q has no settings file to change", agent)
+		}
+		return plan.Targets[1], plan.Targets[1].Err
+	}
+
+	var targets []AdditionalJSONTarget
+	for _, target := range in.Additional {
+		if filepath.Clean(target.FilePath) == filepath.Clean(additional) {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return Target{}, fmt.Errorf("no additional target writes %q", additional)
+	}
+	var rendered Target
+	for _, target := range targets {
+		inputs.Additional = []AdditionalJSONTarget{target}
+		plan, err := renderer.Plan(ctx, inputs)
+		if err != nil {
+			return Target{}, err
+		}
+		rendered = plan.Targets[0]
+		if rendered.Err != nil {
+			return rendered, rendered.Err
+		}
+		base.files[rendered.Path] = []byte(rendered.Content)
+	}
+	return rendered, nil
+}
+
+// selectAgentTarget returns the configured target of agent, or a default one
+// when the agent is not configured. path picks between several targets of
+// the agent and otherwise becomes the destination of the selected one.
+func selectAgentTarget(configured []AgentTarget, agent, path string) (AgentTarget, error) {
+	var candidates []AgentTarget
+	for _, target := range configured {
+		if target.Name != agent {
+			continue
+		}
+		if path != "" && target.PathOverride != "" && filepath.Clean(target.PathOverride) == filepath.Clean(path) {
+			return target, nil
+		}
+		candidates = append(candidates, target)
+	}
+	if path == "" && len(candidates) > 1 {
+		return AgentTarget{}, fmt.Errorf("agent %q has %d targets in the config; choose one with a path", agent, len(candidates))
+	}
+	selected := AgentTarget{Name: agent}
+	if len(candidates) > 0 {
+		selected = candidates[0]
+	}
+	if path != "" {
+		selected.PathOverride = path
+	}
+	return selected, nil
+}
+
+// mergeBaseFS serves the files rendered content is merged into. Files set in
+// files replace their content on disk, and with noMerge every other file
+// reads as missing.
+type mergeBaseFS struct {
+	FS
+	noMerge bool
+	files   map[string][]byte
+}
+
+func (m *mergeBaseFS) ReadFile(name string) ([]byte, error) {
+	if data, ok := m.files[name]; ok {
+		return data, nil
+	}
+	if m.noMerge {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return m.FS.ReadFile(name)
+}
@@ -0,0 +1,137 @@
+package agentalign
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent-align/internal/mcpconfig"
+)
+
+func TestRenderAgentMergesIntoExistingFileWithoutWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mcp-config.json")
+	existing := `{"theme": "dark", "mcpServers": {"old": {"command": "x"}}}`
+	writeFile(t, path, existing)
+	inputs := &Inputs{
+		ConfigPath: filepath.Join(dir, "agent-align.yml"),
+		Agents: []AgentTarget{
+			{Name: "copilot", PathOverride: filepath.Join(dir, "other.json")},
+			{Name: "copilot", PathOverride: path, DisabledMcpServers: []string{"skip"}},
+		},
+		Servers: mcpconfig.Servers{{Name: "a", Command: "npx"}, {Name: "skip", Command: "npx"}},
+	}
+	engine := New(Options{})
+
+	if _, err := engine.Render(context.Background(), inputs, RenderOptions{Agent: "copilot"}); err == nil {
+		t.Fatal("expected an error when the agent has several targets and no path")
+	}
+
+	target, err := engine.Render(context.Background(), inputs, RenderOptions{Agent: "Copilot", Path: path})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if !strings.Contains(target.Content, `"theme": "dark"`) || !strings.Contains(target.Content, `"a"`) || strings.Contains(target.Content, `"skip"`) {
+		t.Fatalf("unexpected content:\n%s", target.Content)
+	}
+	if data, _ := os.ReadFile(path); string(data) != existing {
+		t.Fatalf("Render modified %s:\n%s", path, data)
+	}
+
+	target, err = engine.Render(context.Background(), inputs, RenderOptions{Agent: "copilot", Path: path, NoMerge: true})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if strings.Contains(target.Content, "theme") {
+		t.Fatalf("NoMerge kept existing settings:\n%s", target.Content)
+	}
+}
+
+func TestRenderProjectScopeAgent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".vscode", "mcp.json")
+	writeFile(t, path, `{"inputs": [], "servers": {}}`)
+	inputs := &Inputs{
+		ConfigPath: filepath.Join(dir, ".agent-align.yml"),
+		Agents:     []AgentTarget{{Name: "vscode", Scope: "project"}},
+		ProjectDir: dir,
+		Servers:    mcpconfig.Servers{{Name: "a", Command: "npx"}},
+	}
+	engine := New(Options{})
+
+	target, err := engine.Render(context.Background(), inputs, RenderOptions{Agent: "vscode"})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if target.Path != path || !strings.Contains(target.Content, `"inputs"`) || !strings.Contains(target.Content, `"a"`) {
+		t.Fatalf("unexpected target %s:\n%s", target.Path, target.Content)
+	}
+
+	inputs.ProjectDir = ""
+	_, err = engine.Render(context.Background(), inputs, RenderOptions{Agent: "vscode"})
+	if err == nil || !strings.Contains(err.Error(), "requires a project directory") || strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected the missing project directory to be reported, got %v", err)
+	}
+}
+
+func TestRenderClaudeSettings(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".claude.json")
+	inputs := &Inputs{
+		ConfigPath: filepath.Join(dir, "agent-align.yml"),
+		Agents:     []AgentTarget{{Name: "claudecode", PathOverride: path}},
+		Servers:    mcpconfig.Servers{{Name: "a", Command: "npx", Extra: map[string]interface{}{"alwaysAllow": []interface{}{"read"}}}},
+	}
+	engine := New(Options{})
+
+	target, err := engine.Render(context.Background(), inputs, RenderOptions{Agent: "claudecode"})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if target.Path != path || !strings.Contains(target.Content, `"mcpServers"`) {
+		t.Fatalf("expected the MCP file, got %s:\n%s", target.Path, target.Content)
+	}
+
+	target, err = engine.Render(context.Background(), inputs, RenderOptions{Agent: "claudecode", Settings: true})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if want := filepath.Join(dir, ".claude", "settings.json"); target.Path != want || !strings.Contains(target.Content, `"mcp__a__read"`) {
+		t.Fatalf("expected the settings file, got %s:\n%s", target.Path, target.Content)
+	}
+
+	inputs.Agents = []AgentTarget{{Name: "copilot", PathOverride: filepath.Join(dir, "mcp-config.json")}}
+	if _, err := engine.Render(context.Background(), inputs, RenderOptions{Agent: "copilot", Settings: true}); err == nil {
+		t.Fatal("expected an error for an agent without a settings file")
+	}
+}
+
+func TestRenderAdditionalChainsTargetsOfTheSameFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	writeFile(t, path, `{"keep": true}`)
+	inputs := &Inputs{
+		ConfigPath: filepath.Join(dir, "agent-align.yml"),
+		Additional: []AdditionalJSONTarget{
+			{FilePath: path, JSONPath: "first"},
+			{FilePath: filepath.Join(dir, "elsewhere.json"), JSONPath: "ignored"},
+			{FilePath: path, JSONPath: "second", Shape: "list", NameKey: "name"},
+		},
+		Servers: mcpconfig.Servers{{Name: "a", Command: "npx"}},
+	}
+
+	target, err := New(Options{}).Render(context.Background(), inputs, RenderOptions{Additional: path})
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	want := `{"keep": true, "first": {"a": {"command": "npx"}}, "second": [{"name": "a", "command": "npx"}]}`
+	if target.Content != want {
+		t.Fatalf("unexpected content:\n%s\nwant:\n%s", target.Content, want)
+	}
+
+	if _, err := New(Options{}).Render(context.Background(), inputs, RenderOptions{Additional: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Fatal("expected an error for a file no additional target writes")
+	}
+}
@@ -0,0 +1,155 @@
+package agentalign
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Status describes what happened to a target.
+type Status string
+
+const (
+	// StatusChanged means the destination was (or would be) written.
+	StatusChanged Status = "changed"
+	// StatusUnchanged means the destination already had the rendered content.
+	StatusUnchanged Status = "unchanged"
+	// StatusFailed means the target could not be prepared or written.
+	StatusFailed Status = "failed"
+)
+
+// Report is a machine-readable summary of a sync run.
+type Report struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DurationMS int64     `json:"durationMs"`
+	DryRun     bool      `json:"dryRun"`
+	// Cancelled is set when the changes were declined at the prompt; the
+	// targets are reported as for a dry run and nothing was written.
+	Cancelled bool           `json:"cancelled,omitempty"`
+	Targets   []TargetReport `json:"targets"`
+	Summary   Summary        `json:"summary"`
+}
+
+// TargetReport is the report entry for a single target.
+type TargetReport struct {
+	Kind            TargetKind `json:"kind"`
+	User            string     `json:"user,omitempty"`
+	Agent           string     `json:"agent,omitempty"`
+	Scope           string     `json:"scope,omitempty"`
+	Path            string     `json:"path"`
+	Source          string     `json:"source,omitempty"`
+	Status          Status     `json:"status"`
+	Error           string     `json:"error,omitempty"`
+	Bytes           int        `json:"bytes"`
+	Files           int        `json:"files,omitempty"`
+	Servers         []string   `json:"servers,omitempty"`
+	FilteredServers []string   `json:"filteredServers,omitempty"`
+	SkippedServers  []string   `json:"skippedServers,omitempty"`
+	// Strategy and the key lists describe how an additional target changes
+	// the entries at its path.
+	Strategy string   `json:"strategy,omitempty"`
+	Added    []string `json:"added,omitempty"`
+	Updated  []string `json:"updated,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Foreign  []string `json:"foreign,omitempty"`
+	// Quarantine is set when an unparsable existing file is (or would be)
+	// moved aside; QuarantinedTo is where it went.
+	Quarantine    bool   `json:"quarantine,omitempty"`
+	QuarantinedTo string `json:"quarantinedTo,omitempty"`
+}
+
+// Summary counts targets by status.
+type Summary struct {
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	Failed    int `json:"failed"`
+}
+
+// NewReport builds a report from target results. Pass Plan.Results for a dry
+// run or Result.Targets after Apply.
+func NewReport(results []TargetResult, dryRun bool, started, finished time.Time) Report {
+	report := Report{
+		StartedAt:  started,
+		FinishedAt: finished,
+		DurationMS: finished.Sub(started).Milliseconds(),
+		DryRun:     dryRun,
+		Targets:    make([]TargetReport, 0, len(results)),
+	}
+	for _, tr := range results {
+		entry := TargetReport{
+			Kind:            tr.Target.Kind,
+			User:            tr.Target.User,
+			Agent:           tr.Target.Agent,
+			Scope:           tr.Target.Scope,
+			Path:            tr.Target.Path,
+			Source:          tr.Target.Source,
+			Status:          tr.Status,
+			Bytes:           tr.Bytes,
+			Files:           tr.Files,
+			Servers:         tr.Target.Servers,
+			FilteredServers: tr.Target.FilteredServers,
+			SkippedServers:  tr.Target.SkippedServers,
+			Strategy:        tr.Target.Strategy,
+			Added:           tr.Target.Keys.Added,
+			Updated:         tr.Target.Keys.Updated,
+			Removed:         tr.Target.Keys.Removed,
+			Foreign:         tr.Target.Keys.Foreign,
+			Quarantine:      tr.Target.Quarantine,
+			QuarantinedTo:   tr.QuarantinedTo,
+		}
+		if tr.Err != nil {
+			entry.Error = tr.Err.Error()
+		}
+		switch tr.Status {
+		case StatusChanged:
+			report.Summary.Changed++
+		case StatusUnchanged:
+			report.Summary.Unchanged++
+		case StatusFailed:
+			report.Summary.Failed++
+		}
+		report.Targets = append(report.Targets, entry)
+	}
+	return report
+}
+
+// WriteJSON writes the report as a single indented JSON document.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteNDJSON writes one JSON object per line: a "target" record for every
+// target followed by a final "summary" record.
+func (r Report) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, target := range r.Targets {
+		record := struct {
+			Type string `json:"type"`
+			TargetReport
+		}{Type: "target", TargetReport: target}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	summary := struct {
+		Type       string    `json:"type"`
+		StartedAt  time.Time `json:"startedAt"`
+		FinishedAt time.Time `json:"finishedAt"`
+		DurationMS int64     `json:"durationMs"`
+		DryRun     bool      `json:"dryRun"`
+		Cancelled  bool      `json:"cancelled,omitempty"`
+		Summary
+	}{
+		Type:       "summary",
+		StartedAt:  r.StartedAt,
+		FinishedAt: r.FinishedAt,
+		DurationMS: r.DurationMS,
+		DryRun:     r.DryRun,
+		Cancelled:  r.Cancelled,
+		Summary:    r.Summary,
+	}
+	return enc.Encode(summary)
+}
@@ -0,0 +1,130 @@
+package agentalign
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func planAndApply(t *testing.T, e *Engine, configPath string) *Result {
+	t.Helper()
+	ctx := context.Background()
+	inputs, err := e.Load(ctx, LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	plan, err := e.Plan(ctx, inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	result, err := e.Apply(ctx, plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	return result
+}
+
+func TestReportStatusesAndServers(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "agent-align.yml")
+	vscodePath := filepath.Join(dir, "mcp.json")
+	writeFile(t, configPath, `mcpServers:
+  targets:
+    agents:
+      - name: vscode
+        path: `+vscodePath+`
+        disabledMcpServers: [secret]
+`)
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), `servers:
+  tool:
+    command: npx
+  secret:
+    command: uvx
+`)
+
+	e := New(Options{})
+	first := planAndApply(t, e, configPath)
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	report := NewReport(first.Targets, false, started, started.Add(1500*time.Millisecond))
+
+	if report.DurationMS != 1500 {
+		t.Fatalf("unexpected duration: %d", report.DurationMS)
+	}
+	if len(report.Targets) != 1 {
+		t.Fatalf("expected one target, got %d", len(report.Targets))
+	}
+	entry := report.Targets[0]
+	if entry.Kind != KindAgent || entry.Agent != "vscode" || entry.Path != vscodePath {
+		t.Fatalf("unexpected target identity: %#v", entry)
+	}
+	if entry.Status != StatusChanged || entry.Bytes == 0 {
+		t.Fatalf("first run should change the file: %#v", entry)
+	}
+	if len(entry.Servers) != 1 || entry.Servers[0] != "tool" {
+		t.Fatalf("unexpected servers: %v", entry.Servers)
+	}
+	if len(entry.FilteredServers) != 1 || entry.FilteredServers[0] != "secret" {
+		t.Fatalf("unexpected filtered servers: %v", entry.FilteredServers)
+	}
+	if report.Summary != (Summary{Changed: 1}) {
+		t.Fatalf("unexpected summary: %#v", report.Summary)
+	}
+
+	second := planAndApply(t, e, configPath)
+	if second.Targets[0].Status != StatusUnchanged {
+		t.Fatalf("second run should be unchanged, got %s", second.Targets[0].Status)
+	}
+}
+
+func TestReportWriters(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	results := []TargetResult{
+		{Target: Target{Kind: KindAgent, Agent: "codex", Path: "/a"}, Status: StatusChanged, Bytes: 10, Files: 1},
+		{Target: Target{Kind: KindAdditional, Path: "/b"}, Status: StatusFailed, Err: errString("boom")},
+	}
+	report := NewReport(results, true, started, started)
+	report.Cancelled = true
+
+	var doc bytes.Buffer
+	if err := report.WriteJSON(&doc); err != nil {
+		t.Fatalf("WriteJSON returned error: %v", err)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(doc.Bytes(), &parsed); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if parsed["dryRun"] != true || parsed["cancelled"] != true || len(parsed["targets"].([]interface{})) != 2 {
+		t.Fatalf("unexpected report document: %s", doc.String())
+	}
+
+	var lines bytes.Buffer
+	if err := report.WriteNDJSON(&lines); err != nil {
+		t.Fatalf("WriteNDJSON returned error: %v", err)
+	}
+	var types []string
+	scanner := bufio.NewScanner(&lines)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		types = append(types, record["type"].(string))
+		if record["type"] == "target" && record["status"] == "failed" && record["error"] != "boom" {
+			t.Fatalf("failed target should carry its error: %v", record)
+		}
+		if record["type"] == "summary" && (record["failed"] != float64(1) || record["cancelled"] != true) {
+			t.Fatalf("unexpected summary record: %v", record)
+		}
+	}
+	if len(types) != 3 || types[2] != "summary" {
+		t.Fatalf("unexpected NDJSON record types: %v", types)
+	}
+}
+
+type errString string
+
+func (e errString) Error() string { return string(e) }
@@ -0,0 +1,362 @@
+package agentalign
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"agent-align/internal/docpath"
+	"agent-align/internal/fsys"
+	"agent-align/internal/transforms"
+)
+
+// KeyChanges describes how the servers of an additional target differ from
+// the entries already at its path.
+type KeyChanges struct {
+	// Added lists servers that are not at the path yet.
+	Added []string
+	// Updated lists servers whose existing entry changes.
+	Updated []string
+	// Removed lists existing entries the sync deletes.
+	Removed []string
+	// Foreign lists existing entries agent-align does not own and keeps.
+	Foreign []string
+}
+
+// serverMerge combines the servers of an additional target with the entries
+// already at its path according to the target's strategy, and records the
+// resulting KeyChanges.
+type serverMerge struct {
+	strategy string
+	servers  []transforms.Server
+	// owned holds the servers a previous sync wrote, for the managed
+	// strategy.
+	owned   map[string]bool
+	changes KeyChanges
+}
+
+// replaces reports whether the value at the path is overwritten wholesale.
+func (m *serverMerge) replaces() bool {
+	return m.strategy == "" || m.strategy == "replace"
+}
+
+// classify records the changes against the existing entries, given by name
+// in document order, and returns the names to delete. same reports whether
+// the existing entry of one of our servers already matches it.
+func (m *serverMerge) classify(existing []string, same func(name string) bool) map[string]bool {
+	ours := make(map[string]bool, len(m.servers))
+	for _, server := range m.servers {
+		ours[server.Name] = true
+	}
+	present := make(map[string]bool, len(existing))
+	drop := make(map[string]bool)
+	m.changes = KeyChanges{}
+	for _, name := range existing {
+		if present[name] {
+			continue
+		}
+		present[name] = true
+		switch {
+		case ours[name]:
+		case m.replaces() || (m.strategy == "managed" && m.owned[name]):
+			drop[name] = true
+			m.changes.Removed = append(m.changes.Removed, name)
+		default:
+			m.changes.Foreign = append(m.changes.Foreign, name)
+		}
+	}
+	for _, server := range m.servers {
+		switch {
+		case !present[server.Name]:
+			m.changes.Added = append(m.changes.Added, server.Name)
+		case !same(server.Name):
+			m.changes.Updated = append(m.changes.Updated, server.Name)
+		}
+	}
+	return drop
+}
+
+// mergeJSON returns the value to store at path given the existing one, which
+// is nil when the path does not exist yet.
+func (m *serverMerge) mergeJSON(existing interface{}, path docpath.Path, nameKey string) (interface{}, error) {
+	payload := jsonPayload(m.servers, nameKey)
+	if nameKey == "" {
+		entries, ok := existing.(map[string]interface{})
+		if !ok && existing != nil && !m.replaces() {
+			return nil, fmt.Errorf("%s is %s, not an object", path, jsonKind(existing))
+		}
+		names := make([]string, 0, len(entries))
+		for name := range entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := make(map[string]interface{}, len(m.servers))
+		for _, server := range m.servers {
+			fields[server.Name] = server.Fields
+		}
+		drop := m.classify(names, func(name string) bool { return sameJSON(entries[name], fields[name]) })
+		if m.replaces() {
+			return payload, nil
+		}
+		for name := range drop {
+			delete(entries, name)
+		}
+		if entries == nil {
+			entries = make(map[string]interface{}, len(fields))
+		}
+		for name, value := range fields {
+			entries[name] = value
+		}
+		return entries, nil
+	}
+
+	items, ok := existing.([]interface{})
+	if !ok && existing != nil && !m.replaces() {
+		return nil, fmt.Errorf("%s is %s, not an array", path, jsonKind(existing))
+	}
+	ours := make(map[string]interface{}, len(m.servers))
+	for _, item := range payload.([]interface{}) {
+		server := item.(namedServer)
+		ours[server.name] = server
+	}
+	var names []string
+	byName := make(map[string]interface{})
+	for _, item := range items {
+		if name, ok := jsonItemName(item, nameKey); ok {
+			names = append(names, name)
+			if _, seen := byName[name]; !seen {
+				byName[name] = item
+			}
+		}
+	}
+	drop := m.classify(names, func(name string) bool { return sameJSON(byName[name], ours[name]) })
+	if m.replaces() {
+		return payload, nil
+	}
+	merged := make([]interface{}, 0, len(items)+len(m.servers))
+	written := make(map[string]bool, len(ours))
+	for _, item := range items {
+		name, named := jsonItemName(item, nameKey)
+		switch {
+		case !named:
+			merged = append(merged, item)
+		case ours[name] != nil:
+			if !written[name] {
+				merged = append(merged, ours[name])
+				written[name] = true
+			}
+		case !drop[name]:
+			merged = append(merged, item)
+		}
+	}
+	for _, server := range m.servers {
+		if !written[server.Name] {
+			merged = append(merged, ours[server.Name])
+		}
+	}
+	return merged, nil
+}
+
+// jsonItemName returns the name a list item carries under nameKey.
+func jsonItemName(item interface{}, nameKey string) (string, bool) {
+	object, ok := item.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	name, ok := object[nameKey].(string)
+	return name, ok
+}
+
+// sameJSON reports whether the decoded JSON value existing encodes like
+// value.
+func sameJSON(existing, value interface{}) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(existing, decoded)
+}
+
+// mergeYAML returns the node to store at path given the existing one, which
+// is nil when the path does not exist yet.
+func (m *serverMerge) mergeYAML(existing *yaml.Node, path docpath.Path, nameKey string) (*yaml.Node, error) {
+	kind, want := yaml.MappingNode, "a mapping"
+	if nameKey != "" {
+		kind, want = yaml.SequenceNode, "a sequence"
+	}
+	if existing != nil && existing.Kind != kind {
+		if existing.Tag != "!!null" && !m.replaces() {
+			return nil, fmt.Errorf("%s is %s, not %s", path, yamlKind(existing), want)
+		}
+		existing = nil
+	}
+
+	built, err := yamlServersNode(m.servers, nameKey, existing)
+	if err != nil {
+		return nil, err
+	}
+	// yamlServersNode reuses the existing node of every unchanged server,
+	// so entries are the same when they are the same node.
+	ours := make(map[string][]*yaml.Node, len(m.servers))
+	for i, server := range m.servers {
+		if nameKey == "" {
+			ours[server.Name] = built.Content[2*i : 2*i+2]
+		} else {
+			ours[server.Name] = built.Content[i : i+1]
+		}
+	}
+	var (
+		names   []string
+		entries [][]*yaml.Node
+	)
+	if existing != nil {
+		if nameKey == "" {
+			for i := 0; i+1 < len(existing.Content); i += 2 {
+				names = append(names, existing.Content[i].Value)
+				entries = append(entries, existing.Content[i:i+2])
+			}
+		} else {
+			for _, item := range existing.Content {
+				name := ""
+				if _, value := yamlMappingEntry(item, nameKey); value != nil {
+					name = value.Value
+				}
+				names = append(names, name)
+				entries = append(entries, []*yaml.Node{item})
+			}
+		}
+	}
+	named := make([]string, 0, len(names))
+	prev := make(map[string]*yaml.Node)
+	for i, name := range names {
+		if name == "" && nameKey != "" {
+			continue
+		}
+		named = append(named, name)
+		if _, seen := prev[name]; !seen {
+			prev[name] = entries[i][len(entries[i])-1]
+		}
+	}
+	drop := m.classify(named, func(name string) bool {
+		nodes := ours[name]
+		return nodes[len(nodes)-1] == prev[name]
+	})
+	if m.replaces() || existing == nil {
+		return built, nil
+	}
+
+	merged := *existing
+	merged.Content = nil
+	written := make(map[string]bool, len(ours))
+	for i, name := range names {
+		switch {
+		case name == "" && nameKey != "":
+			merged.Content = append(merged.Content, entries[i]...)
+		case ours[name] != nil:
+			if !written[name] {
+				merged.Content = append(merged.Content, ours[name]...)
+				written[name] = true
+			}
+		case !drop[name]:
+			merged.Content = append(merged.Content, entries[i]...)
+		}
+	}
+	for _, server := range m.servers {
+		if !written[server.Name] {
+			merged.Content = append(merged.Content, ours[server.Name]...)
+		}
+	}
+	return &merged, nil
+}
+
+// managedState records, per file and path, the servers the managed strategy
+// wrote, so the next sync removes only those.
+type managedState struct {
+	Targets map[string]map[string][]string `json:"targets"`
+}
+
+// DefaultStatePath returns where the managed strategy records its servers
+// when no home directory is configured: agent-align/managed.json below
+// $XDG_STATE_HOME, or ~/.local/state when it is not set.
+func DefaultStatePath() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "agent-align", "managed.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "agent-align", "managed.json")
+}
+
+func (e *Engine) loadManagedState() (managedState, error) {
+	state := managedState{Targets: make(map[string]map[string][]string)}
+	if e.statePath == "" {
+		return state, errors.New("cannot locate the state file of managed targets")
+	}
+	data, err := fsys.ReadIfExists(e.base, e.statePath)
+	if err != nil {
+		return state, fmt.Errorf("failed to read %s: %w", e.statePath, err)
+	}
+	if len(data) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse %s: %w", e.statePath, err)
+	}
+	if state.Targets == nil {
+		state.Targets = make(map[string]map[string][]string)
+	}
+	return state, nil
+}
+
+// ownedServers returns the servers a previous sync wrote to the path of a
+// managed target.
+func (e *Engine) ownedServers(file string, path docpath.Path) (map[string]bool, error) {
+	state, err := e.loadManagedState()
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, name := range state.Targets[file][path.String()] {
+		owned[name] = true
+	}
+	return owned, nil
+}
+
+// recordManaged stores the servers a managed target wrote.
+func (e *Engine) recordManaged(target Target) error {
+	path, err := docpath.Parse(target.JSONPath)
+	if err != nil {
+		return fmt.Errorf("invalid jsonPath %q: %w", target.JSONPath, err)
+	}
+	state, err := e.loadManagedState()
+	if err != nil {
+		return err
+	}
+	if state.Targets[target.Path] == nil {
+		state.Targets[target.Path] = make(map[string][]string)
+	}
+	servers := append([]string{}, target.Servers...)
+	sort.Strings(servers)
+	state.Targets[target.Path][path.String()] = servers
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal managed state: %w", err)
+	}
+	if err := fsys.WriteFileAll(e.out, e.statePath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to record managed servers in %s: %w", e.statePath, err)
+	}
+	return nil
+}
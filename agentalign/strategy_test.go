@@ -0,0 +1,149 @@
+package agentalign
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"agent-align/internal/mcpconfig"
+)
+
+func TestManagedStrategyRemovesOnlyOwnedServers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	writeFile(t, path, `{"mcpServers": {"other": {"command": "theirs"}}}`)
+	engine := New(Options{StatePath: filepath.Join(dir, "state", "managed.json")})
+	inputs := &Inputs{
+		ConfigPath: filepath.Join(dir, "agent-align.yml"),
+		Additional: []AdditionalJSONTarget{{FilePath: path, JSONPath: "mcpServers", Strategy: "managed"}},
+		Servers:    mcpconfig.Servers{{Name: "a", Command: "npx"}, {Name: "b", Command: "uvx"}},
+	}
+
+	plan, err := engine.Plan(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	want := KeyChanges{Added: []string{"a", "b"}, Foreign: []string{"other"}}
+	if got := plan.Targets[0].Keys; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first sync changes = %+v, want %+v", got, want)
+	}
+	result, err := engine.Apply(context.Background(), plan)
+	if err != nil || len(result.Failed()) > 0 {
+		t.Fatalf("Apply failed: %v %+v", err, result.Failed())
+	}
+
+	inputs.Servers = mcpconfig.Servers{{Name: "a", Command: "bunx"}}
+	plan, err = engine.Plan(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	target := plan.Targets[0]
+	want = KeyChanges{Updated: []string{"a"}, Removed: []string{"b"}, Foreign: []string{"other"}}
+	if !reflect.DeepEqual(target.Keys, want) {
+		t.Fatalf("second sync changes = %+v, want %+v", target.Keys, want)
+	}
+	wantContent := `{"mcpServers": {"other": {"command": "theirs"}, "a": {"command": "bunx"}}}`
+	if target.Content != wantContent {
+		t.Fatalf("unexpected content:\n%s\nwant:\n%s", target.Content, wantContent)
+	}
+}
+
+func TestMergeStrategyKeepsForeignEntries(t *testing.T) {
+	dir := t.TempDir()
+	servers := mcpconfig.Servers{{Name: "a", Command: "npx"}, {Name: "b", Command: "uvx"}}
+	tests := []struct {
+		name     string
+		target   AdditionalJSONTarget
+		existing string
+		want     string
+	}{
+		{
+			name:     "json list",
+			target:   AdditionalJSONTarget{JSONPath: "servers", Shape: "list", NameKey: "name"},
+			existing: `{"servers": [{"name": "theirs", "command": "x"}, {"name": "a", "command": "old"}]}`,
+			want:     `{"servers": [{"command": "x", "name": "theirs"}, {"name": "a", "command": "npx"}, {"name": "b", "command": "uvx"}]}`,
+		},
+		{
+			name:   "yaml map",
+			target: AdditionalJSONTarget{JSONPath: "mcp", Format: "yaml"},
+			existing: `mcp:
+  # registered by another tool
+  theirs:
+    command: x
+  a:
+    command: old
+`,
+			want: `mcp:
+  # registered by another tool
+  theirs:
+    command: x
+  a:
+    command: npx
+  b:
+    command: uvx
+`,
+		},
+		{
+			name:   "toml map",
+			target: AdditionalJSONTarget{JSONPath: "mcp", Format: "toml"},
+			existing: `[mcp.a]
+command = "old"
+
+[mcp.theirs]
+command = "x"
+`,
+			want: `[mcp.a]
+command = "npx"
+
+[mcp.b]
+command = "uvx"
+
+[mcp.theirs]
+command = "x"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.target.FilePath = filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "."))
+			tt.target.Strategy = "merge"
+			writeFile(t, tt.target.FilePath, tt.existing)
+			merge := canonical(servers)
+			merge.strategy = "merge"
+
+			content, _, err := New(Options{}).buildAdditionalContent(tt.target, merge)
+			if err != nil {
+				t.Fatalf("buildAdditionalContent returned error: %v", err)
+			}
+			if content != tt.want {
+				t.Fatalf("unexpected content:\n%s\nwant:\n%s", content, tt.want)
+			}
+			want := KeyChanges{Added: []string{"b"}, Updated: []string{"a"}, Foreign: []string{"theirs"}}
+			if !reflect.DeepEqual(merge.changes, want) {
+				t.Fatalf("changes = %+v, want %+v", merge.changes, want)
+			}
+		})
+	}
+}
+
+func TestReplaceStrategyReportsRemovedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if err := os.WriteFile(path, []byte(`{"mcpServers": {"a": {"command": "npx"}, "theirs": {}}}`), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	merge := canonical(mcpconfig.Servers{{Name: "a", Command: "npx"}})
+
+	content, _, err := New(Options{}).buildAdditionalJSONContent(AdditionalJSONTarget{FilePath: path, JSONPath: "mcpServers"}, merge)
+	if err != nil {
+		t.Fatalf("buildAdditionalJSONContent returned error: %v", err)
+	}
+	if strings.Contains(content, "theirs") {
+		t.Fatalf("replace kept a foreign entry:\n%s", content)
+	}
+	if want := (KeyChanges{Removed: []string{"theirs"}}); !reflect.DeepEqual(merge.changes, want) {
+		t.Fatalf("changes = %+v, want %+v", merge.changes, want)
+	}
+}
@@ -0,0 +1,120 @@
+package agentalign
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"agent-align/internal/accounts"
+	"agent-align/internal/config"
+	"agent-align/internal/fsys"
+)
+
+// Account is a user a system-wide sync configures.
+type Account = accounts.Account
+
+// UserPlan is the plan of one account selected by the users section.
+type UserPlan struct {
+	Account Account
+	// Engine is configured for the account and applies Plan.
+	Engine *Engine
+	Plan   *Plan
+}
+
+// PlanUsers loads and plans the config once for every account selected by
+// users, each with an engine built from opts whose home is the account's. The
+// config is loaded again per account so "~" resolves to each home, from the
+// files the invoking user's config came from except the project config: the
+// accounts are synced with the invoking user's rights, so neither an
+// account's own config nor one in the working directory is read. An account
+// that cannot be loaded or planned, or a name that does not exist, yields a
+// plan with a single failed KindUser target and does not stop the others. The
+// account databases are read below opts.Root when it is set.
+func PlanUsers(ctx context.Context, opts Options, load LoadOptions, users UsersConfig) ([]UserPlan, error) {
+	var sys FS = opts.FS
+	if sys == nil {
+		sys = fsys.OS{}
+	}
+	if root := strings.TrimSpace(opts.Root); root != "" {
+		sys = fsys.Rooted{FS: sys, Dir: root}
+	}
+	selection, err := accounts.Select(sys, users.Names, users.Groups, users.HomesUnder)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []ConfigLayer
+	for _, layer := range New(opts).ConfigLayers(load) {
+		if layer.Name != config.LayerProject {
+			layers = append(layers, layer)
+		}
+	}
+
+	var plans []UserPlan
+	failed := func(account Account, err error) {
+		plans = append(plans, UserPlan{
+			Account: account,
+			Engine:  New(opts),
+			Plan: &Plan{Inputs: &Inputs{}, Targets: []Target{{
+				Kind: KindUser,
+				User: account.Name,
+				Path: account.Home,
+				Err:  err,
+			}}},
+		})
+	}
+	for _, name := range selection.UnknownUsers {
+		failed(Account{Name: name}, fmt.Errorf("user %q does not exist", name))
+	}
+	for _, group := range selection.UnknownGroups {
+		failed(Account{}, fmt.Errorf("group %q does not exist", group))
+	}
+	for _, account := range selection.Accounts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		userOpts := opts
+		userOpts.Account = &account
+		engine := New(userOpts)
+		engine.layers = layers
+		inputs, err := engine.Load(ctx, load)
+		if err != nil {
+			failed(account, err)
+			continue
+		}
+		plan, err := engine.Plan(ctx, inputs)
+		if err != nil {
+			failed(account, err)
+			continue
+		}
+		plans = append(plans, UserPlan{Account: account, Engine: engine, Plan: plan})
+	}
+	return plans, nil
+}
+
+// confineToHome returns an FS that serves only the files below home, which
+// the account controls; see fsys.Confined. A Rooted FS keeps mapping paths
+// below its directory, with home confined at its place there. The files are
+// read and written on the host filesystem.
+func confineToHome(base FS, home string, owner *fsys.Owner) FS {
+	if rooted, ok := base.(fsys.Rooted); ok {
+		dir, err := rooted.Path(home)
+		if err != nil {
+			return failingFS{err: err}
+		}
+		return fsys.Rooted{FS: fsys.Confined{Dir: dir, Owner: owner}, Dir: rooted.Dir}
+	}
+	return fsys.Confined{Dir: home, Owner: owner}
+}
+
+// failingFS fails every operation with err, for a home that cannot be
+// placed below the root.
+type failingFS struct{ err error }
+
+func (f failingFS) ReadFile(string) ([]byte, error)              { return nil, f.err }
+func (f failingFS) WriteFile(string, []byte, fs.FileMode) error  { return f.err }
+func (f failingFS) MkdirAll(string, fs.FileMode) error           { return f.err }
+func (f failingFS) Stat(string) (fs.FileInfo, error)             { return nil, f.err }
+func (f failingFS) WalkDir(root string, fn fs.WalkDirFunc) error { return fn(root, nil, f.err) }
+func (f failingFS) Rename(string, string) error                  { return f.err }
@@ -0,0 +1,157 @@
+package agentalign
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanUsersIsolatesAndChownsEachAccount(t *testing.T) {
+	root := t.TempDir()
+	dir := t.TempDir()
+	// Both accounts are the test's own user, so handing files to them works
+	// without privileges.
+	uid, gid := os.Getuid(), os.Getgid()
+	writeFile(t, filepath.Join(root, "etc", "passwd"), fmt.Sprintf("alice:x:%d:%d::/home/alice:/bin/sh\nbob:x:%d:%d::/home/bob:/bin/sh\n", uid, gid, uid, gid))
+	writeFile(t, filepath.Join(root, "etc", "group"), "dev:x:2000:alice,bob\n")
+	writeFile(t, filepath.Join(root, "home", "bob", ".copilot", "mcp-config.json"), "{not json")
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, "users:\n  names: [ghost]\n  groups: [dev]\nmcpServers:\n  targets:\n    agents: [copilot]\n")
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), "servers:\n  a:\n    command: npx\n")
+
+	opts := Options{Root: root, Chown: true}
+	inputs, err := New(opts).Load(context.Background(), LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	plans, err := PlanUsers(context.Background(), opts, LoadOptions{ConfigPath: configPath}, inputs.Users)
+	if err != nil {
+		t.Fatalf("PlanUsers returned error: %v", err)
+	}
+	if len(plans) != 3 {
+		t.Fatalf("expected plans for ghost, alice and bob, got %d", len(plans))
+	}
+	if target := plans[0].Plan.Targets[0]; target.Kind != KindUser || target.User != "ghost" || target.Err == nil {
+		t.Fatalf("unknown user was not reported as a failure: %+v", target)
+	}
+
+	var applied []string
+	for _, up := range plans[1:] {
+		result, err := up.Engine.Apply(context.Background(), up.Plan)
+		if err != nil {
+			t.Fatalf("Apply returned error: %v", err)
+		}
+		for _, tr := range result.Targets {
+			if tr.Target.User != up.Account.Name {
+				t.Fatalf("target %s has user %q, want %q", tr.Target.Path, tr.Target.User, up.Account.Name)
+			}
+			if tr.Err == nil {
+				applied = append(applied, tr.Target.Path)
+			}
+		}
+	}
+	if want := []string{"/home/alice/.copilot/mcp-config.json"}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v; bob's unparsable file must only fail bob", applied, want)
+	}
+}
+
+func TestPlanUsersRefusesLinksOutOfTheHome(t *testing.T) {
+	root := t.TempDir()
+	dir := t.TempDir()
+	uid, gid := os.Getuid(), os.Getgid()
+	writeFile(t, filepath.Join(root, "etc", "passwd"), fmt.Sprintf("alice:x:%d:%d::/home/alice:/bin/sh\n", uid, gid))
+	secret := filepath.Join(root, "etc", "shadow")
+	writeFile(t, secret, "root:secret\n")
+	home := filepath.Join(root, "home", "alice")
+	writeFile(t, filepath.Join(home, ".copilot", "keep"), "")
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, "users:\n  names: [alice]\nmcpServers:\n  targets:\n    agents: [copilot, codex]\n")
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), "servers:\n  a:\n    command: npx\n")
+	link := func() {
+		t.Helper()
+		if err := os.Symlink(secret, filepath.Join(home, ".copilot", "mcp-config.json")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(root, "etc"), filepath.Join(home, ".codex")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlink := func() {
+		t.Helper()
+		for _, name := range []string{filepath.Join(home, ".copilot", "mcp-config.json"), filepath.Join(home, ".codex")} {
+			if err := os.Remove(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	plan := func() UserPlan {
+		t.Helper()
+		opts := Options{Root: root, Chown: true, Force: true}
+		plans, err := PlanUsers(context.Background(), opts, LoadOptions{ConfigPath: configPath}, UsersConfig{Names: []string{"alice"}})
+		if err != nil {
+			t.Fatalf("PlanUsers returned error: %v", err)
+		}
+		return plans[0]
+	}
+
+	// Files are not read through links out of the home.
+	link()
+	for _, target := range plan().Plan.Targets {
+		if target.Err == nil || strings.Contains(target.Content, "secret") {
+			t.Fatalf("%s was read through a link: %v %s", target.Path, target.Err, target.Content)
+		}
+	}
+
+	// Nor written through links that appear after planning.
+	unlink()
+	up := plan()
+	link()
+	result, err := up.Engine.Apply(context.Background(), up.Plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if failed := result.Failed(); len(failed) != len(result.Targets) {
+		t.Fatalf("expected every target to fail, got %+v", result.Targets)
+	}
+	if data, _ := os.ReadFile(secret); string(data) != "root:secret\n" {
+		t.Fatalf("a file outside the home was changed: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc", "config.toml")); err == nil {
+		t.Fatal("a file was written through a linked directory")
+	}
+}
+
+func TestPlanUsersIgnoresAccountAndProjectConfigs(t *testing.T) {
+	root := t.TempDir()
+	dir := t.TempDir()
+	uid, gid := os.Getuid(), os.Getgid()
+	writeFile(t, filepath.Join(root, "etc", "passwd"), fmt.Sprintf("alice:x:%d:%d::/home/alice:/bin/sh\n", uid, gid))
+	configPath := filepath.Join(dir, "agent-align.yml")
+	writeFile(t, configPath, "users:\n  names: [alice]\nmcpServers:\n  targets:\n    agents: [copilot]\n")
+	writeFile(t, filepath.Join(dir, "agent-align-mcp.yml"), "servers:\n  a:\n    command: npx\n")
+	// The account's own config and a project config would add targets.
+	writeFile(t, filepath.Join(root, "home", "alice", ".config", "agent-align", "config.yml"), "mcpServers:\n  targets:\n    agents: [codex]\n")
+	project := t.TempDir()
+	writeFile(t, filepath.Join(project, ".agent-align.yml"), "mcpServers:\n  targets:\n    agents: [claudecode]\n")
+
+	opts := Options{Root: root, Home: t.TempDir()}
+	load := LoadOptions{ConfigPath: configPath, Discover: true, ProjectDir: project}
+	plans, err := PlanUsers(context.Background(), opts, load, UsersConfig{Names: []string{"alice"}})
+	if err != nil {
+		t.Fatalf("PlanUsers returned error: %v", err)
+	}
+	var paths []string
+	for _, target := range plans[0].Plan.Targets {
+		if target.Err != nil {
+			t.Fatalf("%s failed: %v", target.Path, target.Err)
+		}
+		paths = append(paths, target.Path)
+	}
+	if want := []string{"/home/alice/.copilot/mcp-config.json"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("planned %v, want %v", paths, want)
+	}
+}
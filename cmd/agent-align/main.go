@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	_ "embed"
 	"errors"
 	"flag"
@@ -14,10 +15,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"gopkg.in/yaml.v3"
 
+	"agent-align/agentalign"
 	"agent-align/internal/config"
 	"agent-align/internal/mcpconfig"
 	"agent-align/internal/syncer"
@@ -31,6 +34,10 @@ var (
 	collectConfig = promptForConfig
 )
 
+// humanOut receives progress and review output. It is switched to stderr when
+// a machine-readable report is written to stdout.
+var humanOut io.Writer = os.Stdout
+
 //go:embed config.embedded.yml
 var exampleConfig string
 
@@ -41,26 +48,54 @@ func main() {
 		}
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfigCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("config failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRenderCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("render failed: %v", err)
+		}
+		return
+	}
 	if err := validateCommand(os.Args); err != nil {
 		log.Fatal(err)
 	}
+	// "sync" names the default command explicitly.
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 
 	defaultAgents := strings.Join(syncer.SupportedAgents(), ",")
 	agents := flag.String("agents", "", fmt.Sprintf("comma-separated list of agents to keep in sync (defaults to %s)", defaultAgents))
-	configPath := flag.String("config", defaultConfigPath(), "path to YAML configuration file describing target agents and overrides")
+	configPath := flag.String("config", defaultConfigPath(), "path to YAML configuration file describing target agents and overrides; when not given, the user and project configs are layered on top of this one")
 	mcpConfigPath := flag.String("mcp-config", "", "path to YAML file that defines MCP servers (defaults to agent-align-mcp.yml next to the target config)")
 	dryRun := flag.Bool("dry-run", false, "only show what would be changed without applying changes")
 	debug := flag.Bool("debug", false, "print shell commands to test each MCP server and exit")
 	confirm := flag.Bool("confirm", false, "skip user confirmation prompt (useful for cron jobs)")
 	showVersion := flag.Bool("version", false, "print version and exit")
+	output := flag.String("output", "text", "output format for the sync report: text, json, or ndjson")
+	force := flag.Bool("force", false, "replace agent config files that cannot be parsed instead of skipping them")
+	outputDir := flag.String("output-dir", "", "write every destination below this directory, mirroring its absolute path, instead of in place")
+	emptyBase := flag.Bool("empty-base", false, "render every destination from scratch instead of merging into the existing file")
+	home := flag.String("home", "", "home directory of the user to configure (defaults to $AGENT_ALIGN_HOME, then the current user's)")
+	root := flag.String("root", "", "place every destination below this directory, such as a container root filesystem")
+	project := flag.String("project", "", "sync only the project-level agent files, such as .vscode/mcp.json, of the project in this directory")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "agent-align version %s\n\n", version)
-		fmt.Fprintf(os.Stderr, "Usage: agent-align [OPTIONS]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: agent-align [sync] [OPTIONS]\n")
+		fmt.Fprintf(os.Stderr, "       agent-align sync -project DIR [OPTIONS]\n")
+		fmt.Fprintf(os.Stderr, "       agent-align init [-config FILE]\n")
+		fmt.Fprintf(os.Stderr, "       agent-align render (-agent NAME [-path FILE] [-settings] | -additional FILE) [-no-merge]\n")
+		fmt.Fprintf(os.Stderr, "       agent-align config show [--effective]\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nDefault config file location: %s\n", defaultConfigPath())
-		fmt.Fprintf(os.Stderr, "Default MCP config file location: %s\n", defaultMCPConfigPath(defaultConfigPath()))
+		fmt.Fprintf(os.Stderr, "Layered on top of it: $XDG_CONFIG_HOME/agent-align/config.yml and the nearest %s\n", config.ProjectConfigName)
+		fmt.Fprintf(os.Stderr, "Default MCP config file location: %s\n", agentalign.DefaultMCPConfigPath(defaultConfigPath()))
 		fmt.Fprintf(os.Stderr, "\nExample config file:\n%s\n", exampleConfig)
 		fmt.Fprintf(os.Stderr, "Tip: add agent-align to cron for continuous syncing, e.g.:\n")
 		fmt.Fprintf(os.Stderr, "  0 * * * * agent-align -confirm >/tmp/agent-align.log 2>&1\n\n")
@@ -73,252 +108,459 @@ func main() {
 		return
 	}
 
+	outputFormat, err := parseOutputFormat(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if outputFormat != outputText {
+		humanOut = os.Stderr
+	}
+
+	started := time.Now()
 	resolvedConfigPath := *configPath
-	resolvedMCPPath := strings.TrimSpace(*mcpConfigPath)
 	agentsFlagValue := strings.TrimSpace(*agents)
 
-	var cfg config.Config
-	var haveConfig bool
-	var additionalTargets []config.AdditionalJSONTarget
-	var extraTargets config.ExtraTargetsConfig
-	var targetAgents []syncer.AgentTarget
-
-	if agentsFlagValue == "" {
+	loadOpts := configLoadOptions(flag.CommandLine, resolvedConfigPath, *mcpConfigPath)
+	if dir := strings.TrimSpace(*project); dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			log.Fatalf("invalid -project directory: %v", err)
+		}
+		loadOpts.Project = abs
+	}
+	if agentsFlagValue != "" {
+		loadOpts.Agents = parseAgents(agentsFlagValue)
+		if len(loadOpts.Agents) == 0 {
+			log.Fatal("the -agents flag must list at least one agent")
+		}
+	} else if !configExists(agentalign.New(agentalign.Options{Home: *home}), loadOpts) {
 		if err := ensureConfigFile(resolvedConfigPath); err != nil {
 			log.Fatalf("configuration unavailable: %v", err)
 		}
-		data, err := config.Load(resolvedConfigPath)
-		if err != nil {
-			log.Fatalf("failed to load config %q: %v", resolvedConfigPath, err)
+	}
+
+	ctx := context.Background()
+	opts := agentalign.Options{
+		Logger:    log.Default(),
+		Force:     *force,
+		OutputDir: strings.TrimSpace(*outputDir),
+		EmptyBase: *emptyBase,
+		Home:      *home,
+		Root:      *root,
+	}
+	engine := agentalign.New(opts)
+	inputs, err := engine.Load(ctx, loadOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// If debug flag is provided, print a shell-ready command for each server and exit.
+	if *debug {
+		printDebugCommands(inputs.Servers)
+		return
+	}
+
+	if !inputs.Users.IsZero() {
+		if strings.TrimSpace(*home) != "" {
+			log.Fatal("the -home flag cannot be combined with a users section in the config")
 		}
-		cfg = data
-		haveConfig = true
-	} else if _, err := os.Stat(resolvedConfigPath); err == nil {
-		data, err := config.Load(resolvedConfigPath)
+		// Only root can hand the files it writes to other users.
+		opts.Chown = os.Geteuid() == 0
+		code, err := syncUsers(ctx, opts, loadOpts, inputs.Users, *dryRun, *confirm, outputFormat, started)
 		if err != nil {
-			log.Fatalf("failed to load config %q: %v", resolvedConfigPath, err)
+			log.Fatal(err)
 		}
-		cfg = data
-		haveConfig = true
+		os.Exit(code)
+	}
+
+	plan, err := engine.Plan(ctx, inputs)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if haveConfig {
-		additionalTargets = cfg.MCP.Targets.Additional.JSON
-		extraTargets = cfg.ExtraTargets
-		targetAgents = configTargetsToSyncer(cfg.MCP.Targets.Agents)
-		if resolvedMCPPath == "" {
-			resolvedMCPPath = cfg.MCP.ConfigPath
+	printPlan(plan)
+
+	// If dry-run mode, exit without making changes
+	if *dryRun {
+		results := plan.Results()
+		fmt.Fprintln(humanOut, "Dry run complete. No changes were made.")
+		if err := writeReport(outputFormat, agentalign.NewReport(results, true, started, time.Now())); err != nil {
+			log.Fatal(err)
 		}
+		if code := reportFailures("Encountered errors while preparing changes:", results); code != 0 {
+			os.Exit(code)
+		}
+		return
 	}
 
-	if resolvedMCPPath == "" {
-		resolvedMCPPath = defaultMCPConfigPath(resolvedConfigPath)
+	// If not in confirm mode, ask for user confirmation
+	if !*confirm {
+		if !promptUser("Apply these changes? [y/N]: ", false) {
+			fmt.Fprintln(humanOut, "Changes cancelled.")
+			if err := writeCancelledReport(outputFormat, plan.Results(), started); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 	}
 
-	if agentsFlagValue != "" {
-		names := parseAgents(agentsFlagValue)
-		if len(names) == 0 {
-			log.Fatal("the -agents flag must list at least one agent")
+	// Apply the changes
+	fmt.Fprintln(humanOut, "\nApplying changes...")
+	if dir := strings.TrimSpace(*outputDir); dir != "" {
+		fmt.Fprintf(humanOut, "  Writing below %s instead of the destinations\n", dir)
+	}
+	result, err := engine.Apply(ctx, plan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printApplyResult(result)
+	fmt.Fprintln(humanOut, "\nConfiguration sync complete.")
+	if err := writeReport(outputFormat, agentalign.NewReport(result.Targets, false, started, time.Now())); err != nil {
+		log.Fatal(err)
+	}
+	if code := reportFailures("Encountered errors while applying changes:", result.Targets); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// syncUsers runs the sync for every account selected by the users section of
+// the config and returns the exit code. The plans of all accounts are shown
+// and confirmed together; a failing account is reported like a failing
+// target and does not stop the others.
+func syncUsers(ctx context.Context, opts agentalign.Options, load agentalign.LoadOptions, users agentalign.UsersConfig, dryRun, confirm bool, outputFormat string, started time.Time) (int, error) {
+	plans, err := agentalign.PlanUsers(ctx, opts, load, users)
+	if err != nil {
+		return exitFailure, err
+	}
+	if len(plans) == 0 {
+		return exitFailure, errors.New("the users section of the config selects no accounts")
+	}
+
+	var results []agentalign.TargetResult
+	for _, up := range plans {
+		if up.Account.Name == "" {
+			continue
 		}
-		overrideLookup := make(map[string]string, len(cfg.MCP.Targets.Agents))
-		for _, agent := range cfg.MCP.Targets.Agents {
-			overrideLookup[agent.Name] = agent.Path
+		fmt.Fprintf(humanOut, "\n##### User: %s (%s) #####\n", up.Account.Name, up.Account.Home)
+		if failed := up.Plan.Failed(); len(failed) == 1 && failed[0].Kind == agentalign.KindUser {
+			fmt.Fprintf(humanOut, "  (error preparing user: %v)\n", failed[0].Err)
+			continue
 		}
-		targetAgents = nil
-		for _, name := range names {
-			normalized := strings.ToLower(strings.TrimSpace(name))
-			targetAgents = append(targetAgents, syncer.AgentTarget{
-				Name:         normalized,
-				PathOverride: overrideLookup[normalized],
-			})
+		printPlan(up.Plan)
+	}
+	if dryRun {
+		for _, up := range plans {
+			results = append(results, up.Plan.Results()...)
 		}
+		fmt.Fprintln(humanOut, "Dry run complete. No changes were made.")
+		if err := writeReport(outputFormat, agentalign.NewReport(results, true, started, time.Now())); err != nil {
+			return exitFailure, err
+		}
+		return reportFailures("Encountered errors while preparing changes:", results), nil
 	}
 
-	if len(targetAgents) == 0 && len(additionalTargets) == 0 && extraTargets.IsZero() {
-		log.Fatal("no target agents, additional destinations, or extra copy targets configured; provide agents via config/flags or add extra targets")
+	if !confirm {
+		if !promptUser("Apply these changes? [y/N]: ", false) {
+			fmt.Fprintln(humanOut, "Changes cancelled.")
+			for _, up := range plans {
+				results = append(results, up.Plan.Results()...)
+			}
+			if err := writeCancelledReport(outputFormat, results, started); err != nil {
+				return exitFailure, err
+			}
+			return 0, nil
+		}
 	}
 
-	servers, err := mcpconfig.Load(resolvedMCPPath)
-	if err != nil {
-		log.Fatalf("failed to load MCP configuration %q: %v", resolvedMCPPath, err)
+	fmt.Fprintln(humanOut, "\nApplying changes...")
+	if opts.OutputDir != "" {
+		fmt.Fprintf(humanOut, "  Writing below %s instead of the destinations\n", opts.OutputDir)
+	}
+	for _, up := range plans {
+		if up.Account.Name != "" {
+			fmt.Fprintf(humanOut, "User: %s\n", up.Account.Name)
+		}
+		result, err := up.Engine.Apply(ctx, up.Plan)
+		if err != nil {
+			return exitFailure, err
+		}
+		printApplyResult(result)
+		results = append(results, result.Targets...)
+	}
+	fmt.Fprintln(humanOut, "\nConfiguration sync complete.")
+	if err := writeReport(outputFormat, agentalign.NewReport(results, false, started, time.Now())); err != nil {
+		return exitFailure, err
 	}
+	return reportFailures("Encountered errors while applying changes:", results), nil
+}
 
-	// If debug flag is provided, print a shell-ready command for each server and exit.
-	if *debug {
-		printDebugCommands(servers)
-		return
+// Exit codes used when some targets fail. Fatal errors that stop the run
+// before any target is processed also exit with exitFailure.
+const (
+	exitFailure        = 1
+	exitPartialFailure = 2
+)
+
+// reportFailures prints every failed target under heading and returns the exit
+// code for the run: 0 when nothing failed, exitPartialFailure when at least one
+// target succeeded, and exitFailure when every target failed.
+func reportFailures(heading string, results []agentalign.TargetResult) int {
+	var messages []string
+	for _, tr := range results {
+		if tr.Err != nil {
+			messages = append(messages, targetErrorMessage(tr))
+		}
+	}
+	if len(messages) == 0 {
+		return 0
 	}
+	fmt.Fprintln(humanOut, heading)
+	for _, msg := range messages {
+		fmt.Fprintf(humanOut, "  - %s\n", msg)
+	}
+	if len(messages) == len(results) {
+		return exitFailure
+	}
+	return exitPartialFailure
+}
 
-	s := syncer.New(targetAgents)
+// targetErrorMessage describes a failed target. Targets that could not be
+// rendered are reported differently from those that failed to write. In a
+// system-wide sync the message starts with the user the target belongs to.
+func targetErrorMessage(tr agentalign.TargetResult) string {
+	target := tr.Target
+	if target.Kind == agentalign.KindUser {
+		if target.User == "" {
+			return fmt.Sprintf("error selecting users: %v", tr.Err)
+		}
+		return fmt.Sprintf("error preparing user %s: %v", target.User, tr.Err)
+	}
+	if user := target.User; user != "" {
+		tr.Target.User = ""
+		return fmt.Sprintf("user %s: %s", user, targetErrorMessage(tr))
+	}
+	if target.Err != nil {
+		switch target.Kind {
+		case agentalign.KindAgent:
+			return fmt.Sprintf("error rendering config for %s: %v", target.Agent, tr.Err)
+		case agentalign.KindAdditional:
+			return fmt.Sprintf("error preparing additional %s %s: %v", strings.ToUpper(target.Format), target.Path, tr.Err)
+		case agentalign.KindExtraFile:
+			return fmt.Sprintf("error preparing extra file %s: %v", target.Source, tr.Err)
+		default:
+			return fmt.Sprintf("error preparing extra directory %s: %v", target.Source, tr.Err)
+		}
+	}
+	switch target.Kind {
+	case agentalign.KindAgent:
+		return fmt.Sprintf("error writing config for %s: %v", target.Agent, tr.Err)
+	case agentalign.KindAdditional:
+		return fmt.Sprintf("error writing additional %s %s: %v", strings.ToUpper(target.Format), target.Path, tr.Err)
+	case agentalign.KindExtraFile:
+		return fmt.Sprintf("error copying extra file %s: %v", target.Source, tr.Err)
+	default:
+		return fmt.Sprintf("error copying extra directory %s: %v", target.Source, tr.Err)
+	}
+}
 
-	syncResult, err := s.Sync(servers)
-	if err != nil {
-		log.Fatalf("sync failed: %v", err)
-	}
-
-	// Display the dry run results
-	fmt.Println("\n=== Dry Run Results ===")
-	fmt.Println("The following configuration changes will be made:")
-	fmt.Println()
-
-	var agentNames []string
-	for name := range syncResult.Agents {
-		agentNames = append(agentNames, name)
-	}
-	sort.Strings(agentNames)
-
-	for _, agent := range agentNames {
-		outputs := syncResult.Agents[agent]
-		for _, output := range outputs {
-			fmt.Printf("Agent: %s\n", agent)
-			fmt.Printf("  File: %s\n", output.Config.FilePath)
-			fmt.Printf("  Format: %s\n", output.Config.Format)
-			fmt.Printf("  Content:\n")
-			// Indent the content for readability
-			lines := strings.Split(output.Content, "\n")
-			for _, line := range lines {
-				fmt.Printf("    %s\n", line)
-			}
-			fmt.Println()
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+// parseOutputFormat validates the value of the -output flag.
+func parseOutputFormat(value string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(value))
+	switch format {
+	case "", outputText:
+		return outputText, nil
+	case outputJSON, outputNDJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported -output value %q (expected text, json, or ndjson)", value)
+	}
+}
+
+// writeReport prints the machine-readable report to stdout. Text output has
+// already been printed while the sync ran, so nothing is written for it.
+func writeReport(format string, report agentalign.Report) error {
+	switch format {
+	case outputJSON:
+		return report.WriteJSON(os.Stdout)
+	case outputNDJSON:
+		return report.WriteNDJSON(os.Stdout)
+	default:
+		return nil
+	}
+}
+
+// writeCancelledReport prints the report of a run whose changes were declined:
+// the planned targets, as for a dry run, marked cancelled.
+func writeCancelledReport(format string, results []agentalign.TargetResult, started time.Time) error {
+	report := agentalign.NewReport(results, true, started, time.Now())
+	report.Cancelled = true
+	return writeReport(format, report)
+}
+
+// printPlan displays the rendered targets so the user can review them before applying.
+func printPlan(plan *agentalign.Plan) {
+	fmt.Fprintln(humanOut, "\n=== Dry Run Results ===")
+	fmt.Fprintln(humanOut, "The following configuration changes will be made:")
+	fmt.Fprintln(humanOut)
+
+	for _, target := range plan.Targets {
+		if target.Kind != agentalign.KindAgent {
+			continue
 		}
+		if target.Scope == syncer.ScopeProject {
+			fmt.Fprintf(humanOut, "Agent: %s (project)\n", target.Agent)
+		} else {
+			fmt.Fprintf(humanOut, "Agent: %s\n", target.Agent)
+		}
+		fmt.Fprintf(humanOut, "  File: %s\n", target.Path)
+		fmt.Fprintf(humanOut, "  Format: %s\n", target.Format)
+		if target.Err != nil {
+			fmt.Fprintf(humanOut, "  (error preparing content: %v)\n\n", target.Err)
+			continue
+		}
+		printQuarantineNotice(target)
+		fmt.Fprintf(humanOut, "  Content:\n")
+		// Indent the content for readability
+		printIndented(target.Content, "    ")
+		fmt.Fprintln(humanOut)
 	}
 
-	if len(additionalTargets) > 0 {
-		fmt.Println("Additional destinations:")
-		for _, target := range additionalTargets {
-			fmt.Printf("Additional JSON: %s\n", target.FilePath)
-			fmt.Printf("  JSON Path: %s\n", displayJSONPath(target.JSONPath))
-			content, err := buildAdditionalJSONContent(target, syncResult.Servers)
-			if err != nil {
-				fmt.Printf("  (error preparing content: %v)\n\n", err)
+	if len(plan.Inputs.Additional) > 0 {
+		fmt.Fprintln(humanOut, "Additional destinations:")
+		for _, target := range plan.Targets {
+			if target.Kind != agentalign.KindAdditional {
 				continue
 			}
-			content = strings.TrimRight(content, "\n")
-			if content == "" {
-				fmt.Println("  Content: <empty>")
-				fmt.Println()
+			format := strings.ToUpper(target.Format)
+			fmt.Fprintf(humanOut, "Additional %s: %s\n", format, target.Path)
+			fmt.Fprintf(humanOut, "  %s Path: %s\n", format, displayJSONPath(target.JSONPath))
+			if target.Err != nil {
+				fmt.Fprintf(humanOut, "  (error preparing content: %v)\n\n", target.Err)
 				continue
 			}
-			fmt.Println("  Content:")
-			lines := strings.Split(content, "\n")
-			for _, line := range lines {
-				fmt.Printf("    %s\n", line)
+			printQuarantineNotice(target)
+			printKeyChanges(target)
+			content := strings.TrimRight(target.Content, "\n")
+			if content == "" {
+				fmt.Fprintln(humanOut, "  Content: <empty>")
+				fmt.Fprintln(humanOut)
+				continue
 			}
-			fmt.Println()
+			fmt.Fprintln(humanOut, "  Content:")
+			printIndented(content, "    ")
+			fmt.Fprintln(humanOut)
 		}
 	}
 
+	extraTargets := plan.Inputs.Extra
 	if !extraTargets.IsZero() {
-		fmt.Println("Extra copy targets:")
+		fmt.Fprintln(humanOut, "Extra copy targets:")
 		for _, target := range extraTargets.Files {
-			fmt.Printf("File Source: %s\n", target.Source)
+			fmt.Fprintf(humanOut, "File Source: %s\n", target.Source)
 			for _, dest := range target.Destinations {
-				fmt.Printf("  -> %s\n", dest)
+				fmt.Fprintf(humanOut, "  -> %s\n", dest)
 			}
-			fmt.Println()
+			fmt.Fprintln(humanOut)
 		}
 		for _, target := range extraTargets.Directories {
-			fmt.Printf("Directory Source: %s\n", target.Source)
-			fmt.Println("  Destinations:")
+			fmt.Fprintf(humanOut, "Directory Source: %s\n", target.Source)
+			fmt.Fprintln(humanOut, "  Destinations:")
 			for _, dest := range target.Destinations {
 				label := dest.Path
 				if dest.Flatten {
 					label = fmt.Sprintf("%s (flatten)", label)
 				}
-				fmt.Printf("    - %s\n", label)
+				fmt.Fprintf(humanOut, "    - %s\n", label)
 			}
-			fmt.Println()
+			fmt.Fprintln(humanOut)
 		}
 	}
+}
 
-	// If dry-run mode, exit without making changes
-	if *dryRun {
-		fmt.Println("Dry run complete. No changes were made.")
-		return
-	}
-
-	// If not in confirm mode, ask for user confirmation
-	if !*confirm {
-		if !promptUser("Apply these changes? [y/N]: ", false) {
-			fmt.Println("Changes cancelled.")
-			return
+// printApplyResult reports each applied target. Failed targets are logged as
+// they are encountered and summarized later by reportFailures.
+func printApplyResult(result *agentalign.Result) {
+	for _, tr := range result.Targets {
+		target := tr.Target
+		if tr.Err != nil {
+			log.Print(targetErrorMessage(tr))
+			continue
+		}
+		if tr.QuarantinedTo != "" {
+			fmt.Fprintf(humanOut, "  Moved unparsable %s to %s\n", target.Path, tr.QuarantinedTo)
+		}
+		if tr.Status == agentalign.StatusUnchanged {
+			fmt.Fprintf(humanOut, "  Unchanged: %s\n", target.Path)
+			continue
 		}
-	}
 
-	// Apply the changes
-	fmt.Println("\nApplying changes...")
-	var applyErrors []string
-	for _, agent := range agentNames {
-		outputs := syncResult.Agents[agent]
-		for _, output := range outputs {
-			if err := writeAgentConfig(output.Config.FilePath, output.Content); err != nil {
-				msg := fmt.Sprintf("error writing config for %s: %v", agent, err)
-				log.Print(msg)
-				applyErrors = append(applyErrors, msg)
-				continue
+		switch target.Kind {
+		case agentalign.KindAgent:
+			fmt.Fprintf(humanOut, "  Updated: %s\n", target.Path)
+		case agentalign.KindAdditional:
+			format := strings.ToUpper(target.Format)
+			fmt.Fprintf(humanOut, "  Updated additional %s: %s\n", format, target.Path)
+			if target.JSONPath != "" {
+				fmt.Fprintf(humanOut, "    %s Path: %s\n", format, target.JSONPath)
+			}
+		case agentalign.KindExtraFile:
+			fmt.Fprintf(humanOut, "  Copied extra file: %s -> %s\n", target.Source, target.Path)
+		case agentalign.KindExtraDirectory:
+			fmt.Fprintf(humanOut, "  Copied extra directory: %s -> %s (%d files)\n", target.Source, target.Path, tr.Files)
+			if target.Flatten {
+				fmt.Fprintln(humanOut, "    Applied flatten")
 			}
-			fmt.Printf("  Updated: %s\n", output.Config.FilePath)
 		}
 	}
+}
 
-	for _, target := range additionalTargets {
-		content, err := buildAdditionalJSONContent(target, syncResult.Servers)
-		if err != nil {
-			msg := fmt.Sprintf("error preparing additional JSON %s: %v", target.FilePath, err)
-			log.Print(msg)
-			applyErrors = append(applyErrors, msg)
-			continue
-		}
-		if err := writeAgentConfig(target.FilePath, content); err != nil {
-			msg := fmt.Sprintf("error writing additional JSON %s: %v", target.FilePath, err)
-			log.Print(msg)
-			applyErrors = append(applyErrors, msg)
-			continue
-		}
-		fmt.Printf("  Updated additional JSON: %s\n", target.FilePath)
-		if target.JSONPath != "" {
-			fmt.Printf("    JSON Path: %s\n", target.JSONPath)
-		}
+// printQuarantineNotice tells the user an unparsable file will be moved aside.
+func printQuarantineNotice(target agentalign.Target) {
+	if target.Quarantine {
+		fmt.Fprintf(humanOut, "  Existing file cannot be parsed; it will be moved to %s.agent-align-corrupt-<timestamp>\n", target.Path)
 	}
+}
 
-	configDir := filepath.Dir(resolvedConfigPath)
-	for _, target := range extraTargets.Files {
-		if err := copyExtraFileTarget(target, configDir, servers); err != nil {
-			msg := fmt.Sprintf("error copying extra file %s: %v", target.Source, err)
-			log.Print(msg)
-			applyErrors = append(applyErrors, msg)
-			continue
+// printKeyChanges lists how an additional target changes the entries at its
+// path.
+func printKeyChanges(target agentalign.Target) {
+	strategy := target.Strategy
+	if strategy == "" {
+		strategy = "replace"
+	}
+	fmt.Fprintf(humanOut, "  Strategy: %s\n", strategy)
+	for _, group := range []struct {
+		label string
+		keys  []string
+	}{
+		{"Added", target.Keys.Added},
+		{"Updated", target.Keys.Updated},
+		{"Removed", target.Keys.Removed},
+		{"Foreign (kept)", target.Keys.Foreign},
+	} {
+		if len(group.keys) > 0 {
+			fmt.Fprintf(humanOut, "  %s: %s\n", group.label, strings.Join(group.keys, ", "))
 		}
-		fmt.Printf("  Copied extra file: %s -> %d destinations\n", target.Source, len(target.Destinations))
 	}
-	for _, target := range extraTargets.Directories {
-		count, err := copyExtraDirectoryTarget(target)
-		if err != nil {
-			msg := fmt.Sprintf("error copying extra directory %s: %v", target.Source, err)
-			log.Print(msg)
-			applyErrors = append(applyErrors, msg)
-			continue
-		}
-		fmt.Printf("  Copied extra directory: %s -> %d destination(s) (%d files)\n", target.Source, len(target.Destinations), count)
-		var flattened bool
-		for _, dest := range target.Destinations {
-			if dest.Flatten {
-				flattened = true
-				break
-			}
-		}
-		if flattened {
-			fmt.Println("    Applied flatten to some destinations")
-		}
+}
+
+func printIndented(content, indent string) {
+	for _, line := range strings.Split(content, "\n") {
+		fmt.Fprintf(humanOut, "%s%s\n", indent, line)
 	}
-	fmt.Println("\nConfiguration sync complete.")
-	if len(applyErrors) > 0 {
-		fmt.Println("Encountered errors while applying changes:")
-		for _, msg := range applyErrors {
-			fmt.Printf("  - %s\n", msg)
-		}
-		os.Exit(1)
+}
+
+func displayJSONPath(path string) string {
+	if trimmed := strings.TrimSpace(path); trimmed != "" {
+		return trimmed
 	}
+	return "<root>"
 }
 
 func parseAgents(agents string) []string {
@@ -334,18 +576,6 @@ func parseAgents(agents string) []string {
 	return out
 }
 
-func configTargetsToSyncer(targets []config.AgentTarget) []syncer.AgentTarget {
-	out := make([]syncer.AgentTarget, 0, len(targets))
-	for _, target := range targets {
-		out = append(out, syncer.AgentTarget{
-			Name:               target.Name,
-			PathOverride:       target.Path,
-			DisabledMcpServers: target.DisabledMcpServers,
-		})
-	}
-	return out
-}
-
 func defaultConfigPath() string {
 	switch runtime.GOOS {
 	case "darwin":
@@ -360,14 +590,6 @@ func defaultConfigPath() string {
 	}
 }
 
-func defaultMCPConfigPath(configPath string) string {
-	dir := filepath.Dir(configPath)
-	if dir == "" {
-		return "agent-align-mcp.yml"
-	}
-	return filepath.Join(dir, "agent-align-mcp.yml")
-}
-
 func ensureConfigFile(path string) error {
 	if _, err := os.Stat(path); err == nil {
 		return nil
@@ -387,7 +609,7 @@ func ensureConfigFile(path string) error {
 	if err := writeConfigFile(path, cfg); err != nil {
 		return err
 	}
-	fmt.Printf("Created configuration file at %s\n", path)
+	fmt.Fprintf(humanOut, "Created configuration file at %s\n", path)
 	return nil
 }
 
@@ -401,7 +623,7 @@ func runInitCommand(args []string) error {
 	path := *configPath
 	if _, err := os.Stat(path); err == nil {
 		if !promptUser(fmt.Sprintf("Configuration already exists at %s. Overwrite? [y/N]: ", path), false) {
-			fmt.Println("Init cancelled.")
+			fmt.Fprintln(humanOut, "Init cancelled.")
 			return nil
 		}
 	} else if !errors.Is(err, os.ErrNotExist) {
@@ -415,14 +637,157 @@ func runInitCommand(args []string) error {
 	if err := writeConfigFile(path, cfg); err != nil {
 		return err
 	}
-	fmt.Printf("Created configuration file at %s\n", path)
+	fmt.Fprintf(humanOut, "Created configuration file at %s\n", path)
+	return nil
+}
+
+// runRenderCommand writes the content of a single target to stdout instead of
+// syncing, so other tools can install the file themselves.
+func runRenderCommand(args []string, stdout io.Writer) error {
+	renderFlags := flag.NewFlagSet("render", flag.ExitOnError)
+	configPath := renderFlags.String("config", defaultConfigPath(), "path to YAML configuration file describing target agents and overrides; when not given, the user and project configs are layered on top of this one")
+	mcpConfigPath := renderFlags.String("mcp-config", "", "path to YAML file that defines MCP servers (defaults to agent-align-mcp.yml next to the target config)")
+	agent := renderFlags.String("agent", "", "render the config file of this agent")
+	path := renderFlags.String("path", "", "destination of the agent file, or which of its configured destinations to render")
+	additional := renderFlags.String("additional", "", "render the additional target file at this path")
+	noMerge := renderFlags.Bool("no-merge", false, "render from scratch instead of merging into the existing file")
+	force := renderFlags.Bool("force", false, "render from scratch when the existing file cannot be parsed")
+	home := renderFlags.String("home", "", "home directory of the user to configure (defaults to $AGENT_ALIGN_HOME, then the current user's)")
+	root := renderFlags.String("root", "", "read the files to merge into below this directory, such as a container root filesystem")
+	settings := renderFlags.Bool("settings", false, "render the settings file the agent writes next to its MCP file, such as Claude Code's .claude/settings.json")
+	project := renderFlags.String("project", "", "render the project-level file of the agent, such as .vscode/mcp.json, for the project in this directory")
+	renderFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: agent-align render (-agent NAME [-path FILE] [-settings] | -additional FILE) [OPTIONS]\n\n")
+		fmt.Fprintf(os.Stderr, "Prints the file a sync would write for one target without writing it.\n\nOptions:\n")
+		renderFlags.PrintDefaults()
+	}
+	if err := renderFlags.Parse(args); err != nil {
+		return err
+	}
+	if renderFlags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(renderFlags.Args(), " "))
+	}
+
+	opts := configLoadOptions(renderFlags, *configPath, *mcpConfigPath)
+	if dir := strings.TrimSpace(*project); dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("invalid -project directory: %w", err)
+		}
+		opts.Project = abs
+	}
+	ctx := context.Background()
+	engine := agentalign.New(agentalign.Options{Logger: log.Default(), Force: *force, Home: *home, Root: *root})
+	if !configExists(engine, opts) && strings.TrimSpace(*agent) != "" {
+		opts.Agents = []string{*agent}
+	}
+	inputs, err := engine.Load(ctx, opts)
+	if err != nil {
+		return err
+	}
+	target, err := engine.Render(ctx, inputs, agentalign.RenderOptions{
+		Agent:      *agent,
+		Path:       *path,
+		Additional: *additional,
+		NoMerge:    *noMerge,
+		Settings:   *settings,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(stdout, target.Content)
+	return err
+}
+
+// runConfigCommand implements "config show", which lists the config files a
+// sync reads and, with --effective, prints their merged content with the file
+// every value came from.
+func runConfigCommand(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "show" {
+		return errors.New("unknown config command; use \"agent-align config show [--effective]\"")
+	}
+	showFlags := flag.NewFlagSet("config show", flag.ExitOnError)
+	configPath := showFlags.String("config", defaultConfigPath(), "show only this config file instead of the system, user and project layers")
+	effective := showFlags.Bool("effective", false, "print the merged config with the file each value came from")
+	home := showFlags.String("home", "", "home directory whose user config is layered (defaults to $AGENT_ALIGN_HOME, then the current user's)")
+	showFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: agent-align config show [--effective] [OPTIONS]\n\n")
+		fmt.Fprintf(os.Stderr, "Lists the config files a sync reads, lowest precedence first.\n\nOptions:\n")
+		showFlags.PrintDefaults()
+	}
+	if err := showFlags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if showFlags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(showFlags.Args(), " "))
+	}
+
+	opts := configLoadOptions(showFlags, *configPath, "")
+	engine := agentalign.New(agentalign.Options{Home: *home})
+	if !*effective {
+		for _, layer := range engine.ConfigLayers(opts) {
+			note := ""
+			if _, err := os.Stat(layer.Path); err != nil {
+				note = " (not found)"
+			}
+			fmt.Fprintf(stdout, "%-8s %s%s\n", layer.Name, layer.Path, note)
+		}
+		return nil
+	}
+
+	merged, err := engine.Effective(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	data, err := merged.MarshalWithOrigins()
+	if err != nil {
+		return err
+	}
+	if _, err := stdout.Write(data); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "# MCP definitions, later files replacing servers of the same name:")
+	for _, source := range merged.MCPSources {
+		note := ""
+		if _, err := os.Stat(source.Path); err != nil && source.Optional {
+			note = ", not found"
+		}
+		fmt.Fprintf(stdout, "#   %s (from %s%s)\n", source.Path, source.Origin, note)
+	}
 	return nil
 }
 
+// configLoadOptions returns the LoadOptions for the -config and -mcp-config
+// flags of set. Unless -config is given explicitly, the system config is
+// layered with the user config and the project config found from the
+// working directory.
+func configLoadOptions(set *flag.FlagSet, configPath, mcpConfigPath string) agentalign.LoadOptions {
+	opts := agentalign.LoadOptions{ConfigPath: configPath, MCPConfigPath: mcpConfigPath, Discover: true}
+	set.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			opts.Discover = false
+		}
+	})
+	if opts.Discover {
+		opts.ProjectDir, _ = os.Getwd()
+	}
+	return opts
+}
+
+// configExists reports whether any config file Load would read exists.
+func configExists(engine *agentalign.Engine, opts agentalign.LoadOptions) bool {
+	for _, layer := range engine.ConfigLayers(opts) {
+		if _, err := os.Stat(layer.Path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func askYes(prompt string, defaultYes bool) bool {
 	reader := bufio.NewReader(os.Stdin)
 	for {
-		fmt.Print(prompt)
+		fmt.Fprint(humanOut, prompt)
 		input, err := reader.ReadString('\n')
 		if err != nil {
 			return defaultYes
@@ -439,14 +804,14 @@ func askYes(prompt string, defaultYes bool) bool {
 		case "n", "no":
 			return false
 		default:
-			fmt.Println("Please answer 'y' or 'n'.")
+			fmt.Fprintln(humanOut, "Please answer 'y' or 'n'.")
 		}
 	}
 }
 
 func promptForConfig() (config.Config, error) {
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("\nLet's create your agent-align configuration.")
+	fmt.Fprintln(humanOut, "\nLet's create your agent-align configuration.")
 	targets, err := promptTargetAgents(reader)
 	if err != nil {
 		return config.Config{}, err
@@ -480,20 +845,20 @@ func promptTargetAgents(reader *bufio.Reader) ([]config.AgentTarget, error) {
 	options := syncer.SupportedAgents()
 
 	sort.Strings(options)
-	fmt.Println("\nSelect target agents (enter comma-separated numbers, e.g. 1,3):")
+	fmt.Fprintln(humanOut, "\nSelect target agents (enter comma-separated numbers, e.g. 1,3):")
 	for i, agent := range options {
-		fmt.Printf("  %d) %s\n", i+1, agent)
+		fmt.Fprintf(humanOut, "  %d) %s\n", i+1, agent)
 	}
 
 	for {
-		fmt.Print("Enter one or more choices: ")
+		fmt.Fprint(humanOut, "Enter one or more choices: ")
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			return nil, err
 		}
 		selections, parseErr := parseSelectionIndices(line)
 		if parseErr != nil {
-			fmt.Println(parseErr)
+			fmt.Fprintln(humanOut, parseErr)
 			continue
 		}
 		seen := make(map[int]struct{}, len(selections))
@@ -501,7 +866,7 @@ func promptTargetAgents(reader *bufio.Reader) ([]config.AgentTarget, error) {
 		valid := true
 		for _, idx := range selections {
 			if idx < 1 || idx > len(options) {
-				fmt.Printf("Selection %d is out of range. Please use numbers from the list.\n", idx)
+				fmt.Fprintf(humanOut, "Selection %d is out of range. Please use numbers from the list.\n", idx)
 				valid = false
 				break
 			}
@@ -515,7 +880,7 @@ func promptTargetAgents(reader *bufio.Reader) ([]config.AgentTarget, error) {
 			continue
 		}
 		if len(targets) == 0 {
-			fmt.Println("Please select at least one target agent.")
+			fmt.Fprintln(humanOut, "Please select at least one target agent.")
 			continue
 		}
 		return targets, nil
@@ -545,7 +910,7 @@ func parseSelectionIndices(input string) ([]int, error) {
 }
 
 func promptAdditionalJSONTargets(reader *bufio.Reader) ([]config.AdditionalJSONTarget, error) {
-	fmt.Println("\nAdd optional MCP destinations (custom files outside the built-in agents).")
+	fmt.Fprintln(humanOut, "\nAdd optional MCP destinations (custom files outside the built-in agents).")
 	var targets []config.AdditionalJSONTarget
 
 	for {
@@ -575,7 +940,7 @@ func promptAdditionalJSONTargets(reader *bufio.Reader) ([]config.AdditionalJSONT
 
 func promptYesNoInput(reader *bufio.Reader, prompt string, defaultYes bool) (bool, error) {
 	for {
-		fmt.Print(prompt)
+		fmt.Fprint(humanOut, prompt)
 		input, err := reader.ReadString('\n')
 		if err != nil && !errors.Is(err, io.EOF) {
 			return false, err
@@ -592,7 +957,7 @@ func promptYesNoInput(reader *bufio.Reader, prompt string, defaultYes bool) (boo
 		case "n", "no":
 			return false, nil
 		default:
-			fmt.Println("Please answer 'y' or 'n'.")
+			fmt.Fprintln(humanOut, "Please answer 'y' or 'n'.")
 			if err != nil && errors.Is(err, io.EOF) {
 				return defaultYes, nil
 			}
@@ -602,14 +967,14 @@ func promptYesNoInput(reader *bufio.Reader, prompt string, defaultYes bool) (boo
 
 func promptRequiredValue(reader *bufio.Reader, prompt, emptyMsg string) (string, error) {
 	for {
-		fmt.Print(prompt)
+		fmt.Fprint(humanOut, prompt)
 		input, err := reader.ReadString('\n')
 		if err != nil && !errors.Is(err, io.EOF) {
 			return "", err
 		}
 		value := strings.TrimSpace(input)
 		if value == "" {
-			fmt.Println(emptyMsg)
+			fmt.Fprintln(humanOut, emptyMsg)
 			if err != nil && errors.Is(err, io.EOF) {
 				return "", errors.New(emptyMsg)
 			}
@@ -639,104 +1004,49 @@ func printManualConfigInstructions(path string, contents []byte) {
 	fmt.Fprintf(os.Stderr, "\nUnable to write the config file automatically. Please create %s with the following contents:\n\n%s\n", path, contents)
 }
 
-func writeAgentConfig(path, content string) error {
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return fmt.Errorf("failed to ensure directory %q: %w", dir, err)
-	}
-	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
-		return fmt.Errorf("failed to write config %q: %w", path, err)
-	}
-	return nil
-}
-
 func validateCommand(args []string) error {
 	if len(args) <= 1 {
 		return nil
 	}
 	arg := args[1]
-	if arg == "" || arg == "init" || strings.HasPrefix(arg, "-") {
+	if arg == "" || arg == "sync" || arg == "init" || arg == "render" || arg == "config" || strings.HasPrefix(arg, "-") {
 		return nil
 	}
-	return fmt.Errorf("unknown command %q. Use -h for usage or run \"init\" to create a config.", arg)
+	return fmt.Errorf("unknown command %q. Use -h for usage, run \"init\" to create a config, \"render\" to print a single target or \"config show\" to inspect the config.", arg)
 }
 
 // printDebugCommands emits a shell-ready test command for every MCP server definition
-// found in the provided map and prints them to stdout.
-func printDebugCommands(servers map[string]interface{}) {
-	var names []string
-	for name := range servers {
-		names = append(names, name)
-	}
-	sort.Strings(names)
-	for _, name := range names {
-		serverRaw := servers[name]
-		m, ok := serverRaw.(map[string]interface{})
-		if !ok {
-			// skip unexpected shapes
-			continue
-		}
-		cmd := formatServerCommand(m)
+// found in the provided list and prints them to stdout.
+func printDebugCommands(servers mcpconfig.Servers) {
+	sorted := append(mcpconfig.Servers(nil), servers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	for _, spec := range sorted {
+		cmd := formatServerCommand(spec)
 		if cmd == "" {
-			fmt.Printf("%s: <cannot render command>\n", name)
+			fmt.Printf("%s: <cannot render command>\n", spec.Name)
 			continue
 		}
-		fmt.Printf("%s: %s\n", name, cmd)
+		fmt.Printf("%s: %s\n", spec.Name, cmd)
 	}
 }
 
-// formatServerCommand builds a single-line shell command for a server mapping.
+// formatServerCommand builds a single-line shell command for a server definition.
 // It concatenates environment assignments (KEY=VALUE) before the command and
 // properly quotes arguments.
-func formatServerCommand(m map[string]interface{}) string {
-	// command
-	cmdVal, ok := m["command"]
-	if !ok {
+func formatServerCommand(spec mcpconfig.ServerSpec) string {
+	if strings.TrimSpace(spec.Command) == "" {
 		return ""
 	}
-	cmdStr, ok := cmdVal.(string)
-	if !ok || strings.TrimSpace(cmdStr) == "" {
-		return ""
-	}
-
-	// args may be an array
-	var args []string
-	if rawArgs, ok := m["args"]; ok {
-		switch v := rawArgs.(type) {
-		case []interface{}:
-			for _, ai := range v {
-				if s, ok := ai.(string); ok {
-					args = append(args, s)
-				}
-			}
-		case []string:
-			args = append(args, v...)
-		case string:
-			// single string argument
-			args = append(args, v)
-		}
-	}
 
-	// env may be a map
 	var envParts []string
-	if rawEnv, ok := m["env"]; ok {
-		if envMap, ok := rawEnv.(map[string]interface{}); ok {
-			// preserve insertion order by sorting keys
-			var keys []string
-			for k := range envMap {
-				keys = append(keys, k)
-			}
-			sort.Strings(keys)
-			for _, k := range keys {
-				v := envMap[k]
-				sval := fmt.Sprintf("%v", v)
-				// if value looks like ${VAR} or starts with $ keep as-is
-				if (strings.HasPrefix(sval, "${") && strings.HasSuffix(sval, "}")) || strings.HasPrefix(sval, "$") {
-					envParts = append(envParts, fmt.Sprintf("%s=%s", k, sval))
-				} else {
-					envParts = append(envParts, fmt.Sprintf("%s=%s", k, shellQuote(sval)))
-				}
-			}
+	// sort keys for a stable order
+	for _, k := range mcpconfig.SortedKeys(spec.Env) {
+		sval := spec.Env[k]
+		// if value looks like ${VAR} or starts with $ keep as-is
+		if (strings.HasPrefix(sval, "${") && strings.HasSuffix(sval, "}")) || strings.HasPrefix(sval, "$") {
+			envParts = append(envParts, fmt.Sprintf("%s=%s", k, sval))
+		} else {
+			envParts = append(envParts, fmt.Sprintf("%s=%s", k, shellQuote(sval)))
 		}
 	}
 
@@ -746,8 +1056,8 @@ func formatServerCommand(m map[string]interface{}) string {
 		parts = append(parts, strings.Join(envParts, " "))
 	}
 	// quote the command itself if needed
-	parts = append(parts, shellQuote(cmdStr))
-	for _, a := range args {
+	parts = append(parts, shellQuote(spec.Command))
+	for _, a := range spec.Args {
 		parts = append(parts, shellQuote(a))
 	}
 	return strings.Join(parts, " ")
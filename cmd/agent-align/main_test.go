@@ -2,7 +2,10 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -10,7 +13,9 @@ import (
 	"strings"
 	"testing"
 
+	"agent-align/agentalign"
 	"agent-align/internal/config"
+	"agent-align/internal/mcpconfig"
 )
 
 func TestParseAgents(t *testing.T) {
@@ -77,29 +82,48 @@ func TestValidateCommand(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
+	if err := validateCommand([]string{"agent-align", "sync", "-project", "."}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := validateCommand([]string{"agent-align", "render"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := validateCommand([]string{"agent-align", "config"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
 	if err := validateCommand([]string{"agent-align", "run"}); err == nil {
 		t.Fatal("expected error for unknown command")
 	}
 }
 
-func TestDefaultMCPConfigPath(t *testing.T) {
-	path := "/etc/agent-align.yml"
-	got := defaultMCPConfigPath(path)
-	if got != "/etc/agent-align-mcp.yml" {
-		t.Fatalf("unexpected default MCP path: %s", got)
+func TestRunRenderCommandWritesOnlyTheTargetContent(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "tool.json")
+	configPath := filepath.Join(dir, "agent-align.yml")
+	files := map[string]string{
+		configPath: "mcpServers:\n  targets:\n    additionalTargets:\n      json:\n        - filePath: " + target + "\n          jsonPath: servers\n",
+		filepath.Join(dir, "agent-align-mcp.yml"): "servers:\n  a:\n    command: npx\n",
+		target: `{"keep": true}`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
 	}
-}
 
-func TestConfigTargetsToSyncer(t *testing.T) {
-	targets := []config.AgentTarget{
-		{Name: "Copilot", Path: "/tmp/custom"},
+	var out strings.Builder
+	if err := runRenderCommand([]string{"-config", configPath, "-additional", target, "-no-merge"}, &out); err != nil {
+		t.Fatalf("runRenderCommand returned error: %v", err)
 	}
-	got := configTargetsToSyncer(targets)
-	if len(got) != 1 {
-		t.Fatalf("expected 1 target, got %d", len(got))
+	want := "{\n  \"servers\": {\n    \"a\": {\n      \"command\": \"npx\"\n    }\n  }\n}\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
 	}
-	if got[0].Name != "Copilot" || got[0].PathOverride != "/tmp/custom" {
-		t.Fatalf("unexpected conversion: %#v", got[0])
+	if data, _ := os.ReadFile(target); string(data) != `{"keep": true}` {
+		t.Fatalf("render modified the target: %s", data)
 	}
 }
 
@@ -109,10 +133,15 @@ func TestEnsureConfigFileCreatesFile(t *testing.T) {
 
 	origPrompt := promptUser
 	origCollect := collectConfig
+	origOut := humanOut
 	defer func() {
 		promptUser = origPrompt
 		collectConfig = origCollect
+		humanOut = origOut
 	}()
+	// With a structured -output format, messages go to stderr, not stdout.
+	var human bytes.Buffer
+	humanOut = &human
 
 	promptUser = func(string, bool) bool { return true }
 	collectConfig = func() (config.Config, error) {
@@ -140,6 +169,9 @@ func TestEnsureConfigFileCreatesFile(t *testing.T) {
 	if !strings.Contains(content, "agents:") {
 		t.Fatalf("expected agents block in config: %s", data)
 	}
+	if !strings.Contains(human.String(), "Created configuration file at "+path) {
+		t.Fatalf("expected the message on the human writer, got %q", human.String())
+	}
 }
 
 func TestPromptTargetAgents(t *testing.T) {
@@ -188,3 +220,70 @@ func TestVersionFlagOutput(t *testing.T) {
 		t.Fatalf("unexpected -version output:\ngot: %q\nwant: %q", string(output), expected)
 	}
 }
+
+func TestFormatServerCommand(t *testing.T) {
+	spec := mcpconfig.ServerSpec{
+		Name:    "db",
+		Command: "python",
+		Args:    []string{"-m", "db server"},
+		Env:     map[string]string{"B": "two words", "A": "${TOKEN}"},
+	}
+	got := formatServerCommand(spec)
+	want := "A=${TOKEN} B='two words' python -m 'db server'"
+	if got != want {
+		t.Fatalf("formatServerCommand() = %q, want %q", got, want)
+	}
+
+	if got := formatServerCommand(mcpconfig.ServerSpec{URL: "https://example.test"}); got != "" {
+		t.Fatalf("expected empty command for URL server, got %q", got)
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	cases := map[string]string{"": "text", "text": "text", "JSON": "json", " ndjson ": "ndjson"}
+	for input, want := range cases {
+		got, err := parseOutputFormat(input)
+		if err != nil || got != want {
+			t.Errorf("parseOutputFormat(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := parseOutputFormat("yaml"); err == nil {
+		t.Fatal("expected error for unsupported output format")
+	}
+}
+
+func TestReportFailuresExitCodes(t *testing.T) {
+	prev := humanOut
+	humanOut = io.Discard
+	defer func() { humanOut = prev }()
+
+	ok := agentalign.TargetResult{Target: agentalign.Target{Kind: agentalign.KindAgent, Agent: "codex"}}
+	renderFailed := agentalign.TargetResult{
+		Target: agentalign.Target{Kind: agentalign.KindAgent, Agent: "copilot", Err: errors.New("invalid")},
+		Err:    errors.New("invalid"),
+	}
+
+	if code := reportFailures("errors:", []agentalign.TargetResult{ok}); code != 0 {
+		t.Fatalf("expected 0 without failures, got %d", code)
+	}
+	if code := reportFailures("errors:", []agentalign.TargetResult{ok, renderFailed}); code != exitPartialFailure {
+		t.Fatalf("expected partial failure code, got %d", code)
+	}
+	if code := reportFailures("errors:", []agentalign.TargetResult{renderFailed}); code != exitFailure {
+		t.Fatalf("expected failure code, got %d", code)
+	}
+	if msg := targetErrorMessage(renderFailed); !strings.Contains(msg, "error rendering config for copilot") {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	renderFailed.Target.User = "alice"
+	if msg := targetErrorMessage(renderFailed); !strings.HasPrefix(msg, "user alice: error rendering config for copilot") {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	userFailed := agentalign.TargetResult{
+		Target: agentalign.Target{Kind: agentalign.KindUser, User: "ghost", Err: errors.New("missing")},
+		Err:    errors.New("missing"),
+	}
+	if msg := targetErrorMessage(userFailed); msg != "error preparing user ghost: missing" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
@@ -0,0 +1,173 @@
+// Package accounts reads the user accounts of a system from its passwd and
+// group files, for configs that are applied to several users.
+package accounts
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"agent-align/internal/fsys"
+)
+
+// Locations of the account databases.
+const (
+	PasswdPath = "/etc/passwd"
+	GroupPath  = "/etc/group"
+)
+
+// Account is a user whose agent files are rendered.
+type Account struct {
+	Name string
+	UID  int
+	GID  int
+	Home string
+}
+
+// Selection is the result of Select.
+type Selection struct {
+	// Accounts are the selected accounts, sorted by name.
+	Accounts []Account
+	// UnknownUsers and UnknownGroups list the names that do not exist.
+	UnknownUsers  []string
+	UnknownGroups []string
+}
+
+// Select returns the accounts named in names, the members of groups (listed
+// in the group file or having it as their primary group) and every account
+// whose home directory is below homesUnder. The databases are read from fs.
+func Select(fs fsys.FS, names, groups []string, homesUnder string) (Selection, error) {
+	users, err := readPasswd(fs)
+	if err != nil {
+		return Selection{}, err
+	}
+	var members map[string][]string
+	var gids map[string]int
+	if len(groups) > 0 {
+		if gids, members, err = readGroups(fs); err != nil {
+			return Selection{}, err
+		}
+	}
+
+	var sel Selection
+	selected := make(map[string]bool)
+	add := func(account Account) {
+		if !selected[account.Name] {
+			selected[account.Name] = true
+			sel.Accounts = append(sel.Accounts, account)
+		}
+	}
+	byName := make(map[string]Account, len(users))
+	for _, account := range users {
+		if _, seen := byName[account.Name]; !seen {
+			byName[account.Name] = account
+		}
+	}
+
+	for _, name := range names {
+		account, ok := byName[name]
+		if !ok {
+			sel.UnknownUsers = append(sel.UnknownUsers, name)
+			continue
+		}
+		add(account)
+	}
+	for _, group := range groups {
+		gid, ok := gids[group]
+		if !ok {
+			sel.UnknownGroups = append(sel.UnknownGroups, group)
+			continue
+		}
+		for _, name := range members[group] {
+			if account, ok := byName[name]; ok {
+				add(account)
+			}
+		}
+		for _, account := range users {
+			if account.GID == gid {
+				add(account)
+			}
+		}
+	}
+	if homesUnder != "" {
+		for _, account := range users {
+			if isBelow(account.Home, homesUnder) {
+				add(account)
+			}
+		}
+	}
+
+	sort.Slice(sel.Accounts, func(i, j int) bool { return sel.Accounts[i].Name < sel.Accounts[j].Name })
+	return sel, nil
+}
+
+// isBelow reports whether path is strictly inside dir.
+func isBelow(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readPasswd parses name:password:uid:gid:gecos:home:shell lines.
+func readPasswd(fs fsys.FS) ([]Account, error) {
+	data, err := fs.ReadFile(PasswdPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", PasswdPath, err)
+	}
+	var users []Account
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("%s line %d: expected at least 6 fields", PasswdPath, i+1)
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid uid %q", PasswdPath, i+1, fields[2])
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid gid %q", PasswdPath, i+1, fields[3])
+		}
+		users = append(users, Account{Name: fields[0], UID: uid, GID: gid, Home: fields[5]})
+	}
+	return users, nil
+}
+
+// readGroups parses name:password:gid:member,member lines.
+func readGroups(fs fsys.FS) (map[string]int, map[string][]string, error) {
+	data, err := fs.ReadFile(GroupPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", GroupPath, err)
+	}
+	gids := make(map[string]int)
+	members := make(map[string][]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			return nil, nil, fmt.Errorf("%s line %d: expected 4 fields", GroupPath, i+1)
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s line %d: invalid gid %q", GroupPath, i+1, fields[2])
+		}
+		if _, seen := gids[fields[0]]; seen {
+			continue
+		}
+		gids[fields[0]] = gid
+		for _, member := range strings.Split(fields[3], ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				members[fields[0]] = append(members[fields[0]], member)
+			}
+		}
+	}
+	return gids, members, nil
+}
@@ -0,0 +1,56 @@
+package accounts
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"agent-align/internal/fsys"
+)
+
+func TestSelect(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0o755); err != nil {
+		t.Fatalf("failed to create etc: %v", err)
+	}
+	passwd := `root:x:0:0:root:/root:/bin/bash
+# comment
+alice:x:1000:1000:Alice:/home/alice:/bin/bash
+bob:x:1001:100::/home/bob:/bin/sh
+carol:x:1002:1002::/srv/carol:/bin/sh
+svc:x:999:999::/home:/usr/sbin/nologin
+`
+	group := `users:x:100:
+dev:x:2000:carol,alice
+`
+	if err := os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(passwd), 0o644); err != nil {
+		t.Fatalf("failed to write passwd: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "group"), []byte(group), 0o644); err != nil {
+		t.Fatalf("failed to write group: %v", err)
+	}
+	sys := fsys.Rooted{FS: fsys.OS{}, Dir: root}
+
+	sel, err := Select(sys, []string{"root", "ghost"}, []string{"dev", "users", "nope"}, "/home")
+	if err != nil {
+		t.Fatalf("Select returned error: %v", err)
+	}
+	var names []string
+	for _, account := range sel.Accounts {
+		names = append(names, account.Name)
+	}
+	if want := []string{"alice", "bob", "carol", "root"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("selected %v, want %v", names, want)
+	}
+	if want := (Account{Name: "bob", UID: 1001, GID: 100, Home: "/home/bob"}); sel.Accounts[1] != want {
+		t.Fatalf("bob = %+v, want %+v", sel.Accounts[1], want)
+	}
+	if !reflect.DeepEqual(sel.UnknownUsers, []string{"ghost"}) || !reflect.DeepEqual(sel.UnknownGroups, []string{"nope"}) {
+		t.Fatalf("unexpected unknown names: %v %v", sel.UnknownUsers, sel.UnknownGroups)
+	}
+
+	if _, err := Select(fsys.Rooted{FS: fsys.OS{}, Dir: t.TempDir()}, []string{"alice"}, nil, ""); err == nil {
+		t.Fatal("expected an error without a passwd file")
+	}
+}
@@ -1,18 +1,41 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"agent-align/internal/docpath"
+	"agent-align/internal/fsys"
 )
 
 // Config describes the MCP sync behavior and extra file/directory copies.
 type Config struct {
 	MCP          MCPConfig          `yaml:"mcpServers"`
 	ExtraTargets ExtraTargetsConfig `yaml:"extraTargets"`
+	// Users applies a system-wide config to several accounts instead of the
+	// invoking user.
+	Users UsersConfig `yaml:"users,omitempty"`
+}
+
+// UsersConfig selects the accounts a system-wide config is applied for. An
+// account matching any of the fields is selected.
+type UsersConfig struct {
+	Names  []string `yaml:"names,omitempty"`
+	Groups []string `yaml:"groups,omitempty"`
+	// HomesUnder selects every account whose home directory is below this
+	// directory, such as /home.
+	HomesUnder string `yaml:"homesUnder,omitempty"`
+}
+
+// IsZero reports whether no accounts are selected.
+func (u UsersConfig) IsZero() bool {
+	return len(u.Names) == 0 && len(u.Groups) == 0 && u.HomesUnder == ""
 }
 
 // MCPConfig groups the MCP definition source and the target agents.
@@ -31,13 +54,52 @@ type TargetsConfig struct {
 type AgentTarget struct {
 	Name string `yaml:"name"`
 	Path string `yaml:"path,omitempty"`
+	// Scope is user (the default) for the agent's file below the home
+	// directory or project for its file in the project being synced.
+	Scope string `yaml:"scope,omitempty"`
 	// DisabledMcpServers lists MCP IDs that should be omitted for this agent.
 	DisabledMcpServers []string `yaml:"disabledMcpServers,omitempty"`
+	// Tags keeps only servers tagged with one of the tags; ExcludeTags drops
+	// servers tagged with any of them.
+	Tags        []string `yaml:"tags,omitempty"`
+	ExcludeTags []string `yaml:"excludeTags,omitempty"`
+	// Overrides replaces fields of individual servers for this target, keyed
+	// by server name. A null value removes the field.
+	Overrides map[string]map[string]interface{} `yaml:"overrides,omitempty"`
+	// Transports overrides the transports the agent supports (stdio, http, sse).
+	Transports []string `yaml:"transports,omitempty"`
+	// UnsupportedTransports is skip, fail or bridge.
+	UnsupportedTransports string `yaml:"unsupportedTransports,omitempty"`
+	// Bridge customizes the stdio bridge command used by the bridge policy.
+	Bridge *BridgeConfig `yaml:"bridge,omitempty"`
+	// Projects writes servers into Claude Code's per-project mcpServers in
+	// ~/.claude.json, keyed by absolute project path. Only claudecode targets
+	// accept it.
+	Projects map[string]ClaudeProjectConfig `yaml:"projects,omitempty"`
+}
+
+// ClaudeProjectConfig selects the servers of one Claude Code project.
+type ClaudeProjectConfig struct {
+	// Servers keeps only the named servers; empty keeps every server.
+	Servers            []string `yaml:"servers,omitempty"`
+	Tags               []string `yaml:"tags,omitempty"`
+	DisabledMcpServers []string `yaml:"disabledMcpServers,omitempty"`
+}
+
+// BridgeConfig describes the stdio command that wraps remote servers.
+type BridgeConfig struct {
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args,omitempty"`
+	HeaderFlag string   `yaml:"headerFlag,omitempty"`
+	SSEArgs    []string `yaml:"sseArgs,omitempty"`
 }
 
-// AdditionalTargets lists paths for JSON-style destinations.
+// AdditionalTargets lists files outside the supported agents that mirror the
+// MCP payload, grouped by document format.
 type AdditionalTargets struct {
 	JSON []AdditionalJSONTarget `yaml:"json"`
+	YAML []AdditionalJSONTarget `yaml:"yaml"`
+	TOML []AdditionalJSONTarget `yaml:"toml"`
 }
 
 // ExtraTargetsConfig describes file/directory copy operations outside the MCP sync.
@@ -79,10 +141,34 @@ type ExtraDirectoryCopyRoute struct {
 	Flatten      bool     `yaml:"flatten"`
 }
 
-// AdditionalJSONTarget describes a JSON file that should receive the MCP payload.
+// AdditionalJSONTarget describes a JSON, YAML or TOML file that should receive
+// the MCP payload at JSONPath, a JSONPath subset or JSON Pointer (see
+// internal/docpath).
 type AdditionalJSONTarget struct {
 	FilePath string `yaml:"filePath"`
 	JSONPath string `yaml:"jsonPath"`
+	// Shape is "map" (the default), which renders servers as an object keyed
+	// by name, or "list", which renders a list of objects that carry the
+	// server name under NameKey ("name" unless set).
+	Shape   string `yaml:"shape,omitempty"`
+	NameKey string `yaml:"nameKey,omitempty"`
+	// Transformer renders the servers the way the named agent's file would
+	// have them, for example "gemini". Empty keeps the canonical fields.
+	Transformer string `yaml:"transformer,omitempty"`
+	// DisabledMcpServers, Tags, ExcludeTags and Overrides select and adjust
+	// the servers as they do for agent targets.
+	DisabledMcpServers []string                          `yaml:"disabledMcpServers,omitempty"`
+	Tags               []string                          `yaml:"tags,omitempty"`
+	ExcludeTags        []string                          `yaml:"excludeTags,omitempty"`
+	Overrides          map[string]map[string]interface{} `yaml:"overrides,omitempty"`
+	// Strategy decides what happens to entries already at JSONPath.
+	// "replace" (the default) overwrites the value wholesale, "merge" adds
+	// and updates our servers and keeps every other entry, and "managed"
+	// also removes the servers a previous sync wrote that are gone now.
+	Strategy string `yaml:"strategy,omitempty"`
+	// Format is "json", "yaml" or "toml", taken from the list the target is
+	// declared in. An empty Format means JSON.
+	Format string `yaml:"-"`
 }
 
 // UnmarshalYAML lets file destinations be provided as either strings or mappings.
@@ -142,7 +228,15 @@ func (a *AgentTarget) UnmarshalYAML(node *yaml.Node) error {
 		}
 		a.Name = r.Name
 		a.Path = r.Path
+		a.Scope = r.Scope
 		a.DisabledMcpServers = r.DisabledMcpServers
+		a.Tags = r.Tags
+		a.ExcludeTags = r.ExcludeTags
+		a.Overrides = r.Overrides
+		a.Transports = r.Transports
+		a.UnsupportedTransports = r.UnsupportedTransports
+		a.Bridge = r.Bridge
+		a.Projects = r.Projects
 		return nil
 	default:
 		return fmt.Errorf("agent entry must be a string or mapping")
@@ -174,7 +268,7 @@ func (t *TargetsConfig) UnmarshalYAML(node *yaml.Node) error {
 			return err
 		}
 		t.Agents = r.Agents
-		if len(r.AdditionalTargets.JSON) > 0 {
+		if !r.AdditionalTargets.IsZero() {
 			t.Additional = r.AdditionalTargets
 		} else {
 			t.Additional = r.Additional
@@ -191,7 +285,47 @@ func Load(path string) (Config, error) {
 	if err != nil {
 		return Config{}, err
 	}
+	return Parse(path, data)
+}
+
+// Parse decodes and validates configuration data. The path is only used in
+// error messages.
+func Parse(path string, data []byte) (Config, error) {
+	return ParseWithHome(path, data, "")
+}
+
+// LoadWithHome is Load with "~" in paths expanded to home.
+func LoadWithHome(path, home string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return ParseWithHome(path, data, home)
+}
+
+// ParseWithHome is Parse with "~" in paths expanded to home instead of the
+// home directory returned by fsys.HomeDir.
+func ParseWithHome(path string, data []byte, home string) (Config, error) {
+	cfg, err := parseConfig(path, data, home)
+	if err != nil {
+		return Config{}, err
+	}
+	if !hasTargets(cfg) {
+		return Config{}, fmt.Errorf("config at %q must define at least one target", path)
+	}
+	return cfg, nil
+}
 
+// hasTargets reports whether cfg defines anything to write.
+func hasTargets(cfg Config) bool {
+	return len(cfg.MCP.Targets.Agents) > 0 ||
+		!cfg.MCP.Targets.Additional.IsZero() ||
+		!cfg.ExtraTargets.IsZero()
+}
+
+// parseConfig decodes and validates a single config file, which may define
+// no targets when it is merged with others.
+func parseConfig(path string, data []byte, home string) (Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return Config{}, fmt.Errorf("failed to parse config at %q: %w", path, err)
@@ -199,26 +333,52 @@ func Load(path string) (Config, error) {
 
 	cfg.MCP.ConfigPath = strings.TrimSpace(cfg.MCP.ConfigPath)
 	if cfg.MCP.ConfigPath != "" {
-		expanded, err := expandUserPath(cfg.MCP.ConfigPath)
+		expanded, err := expandUserPath(cfg.MCP.ConfigPath, home)
 		if err != nil {
 			return Config{}, fmt.Errorf("config at %q has an invalid MCP configPath %q: %w", path, cfg.MCP.ConfigPath, err)
 		}
 		cfg.MCP.ConfigPath = expanded
 	}
 
-	cfg.MCP.Targets = normalizeTargets(cfg.MCP.Targets)
-
-	for i := range cfg.MCP.Targets.Additional.JSON {
-		cfg.MCP.Targets.Additional.JSON[i].FilePath = strings.TrimSpace(cfg.MCP.Targets.Additional.JSON[i].FilePath)
-		cfg.MCP.Targets.Additional.JSON[i].JSONPath = strings.TrimSpace(cfg.MCP.Targets.Additional.JSON[i].JSONPath)
-		if cfg.MCP.Targets.Additional.JSON[i].FilePath == "" {
-			return Config{}, fmt.Errorf("config at %q has an additional JSON target without a filePath", path)
+	cfg.MCP.Targets = normalizeTargets(cfg.MCP.Targets, home)
+	for _, target := range cfg.MCP.Targets.Agents {
+		if err := validateAgentTransports(target); err != nil {
+			return Config{}, fmt.Errorf("config at %q has an invalid %s target: %w", path, target.Name, err)
 		}
-		expanded, err := expandUserPath(cfg.MCP.Targets.Additional.JSON[i].FilePath)
-		if err != nil {
-			return Config{}, fmt.Errorf("config at %q has an additional JSON target with invalid filePath %q: %w", path, cfg.MCP.Targets.Additional.JSON[i].FilePath, err)
+		if target.Scope != "" && target.Scope != "user" && target.Scope != "project" {
+			return Config{}, fmt.Errorf("config at %q has an invalid %s target: unknown scope %q (expected user or project)", path, target.Name, target.Scope)
+		}
+		if err := validateProjects(target); err != nil {
+			return Config{}, fmt.Errorf("config at %q has an invalid %s target: %w", path, target.Name, err)
+		}
+	}
+
+	additional := &cfg.MCP.Targets.Additional
+	for _, list := range []struct {
+		format  string
+		targets []AdditionalJSONTarget
+	}{
+		{"json", additional.JSON},
+		{"yaml", additional.YAML},
+		{"toml", additional.TOML},
+	} {
+		for i := range list.targets {
+			target := &list.targets[i]
+			target.Format = list.format
+			target.FilePath = strings.TrimSpace(target.FilePath)
+			target.JSONPath = strings.TrimSpace(target.JSONPath)
+			if target.FilePath == "" {
+				return Config{}, fmt.Errorf("config at %q has an additional %s target without a filePath", path, strings.ToUpper(list.format))
+			}
+			expanded, err := expandUserPath(target.FilePath, home)
+			if err != nil {
+				return Config{}, fmt.Errorf("config at %q has an additional %s target with invalid filePath %q: %w", path, strings.ToUpper(list.format), target.FilePath, err)
+			}
+			target.FilePath = expanded
+			if err := validateAdditionalTarget(target); err != nil {
+				return Config{}, fmt.Errorf("config at %q has an invalid additional %s target %q: %w", path, strings.ToUpper(list.format), target.FilePath, err)
+			}
 		}
-		cfg.MCP.Targets.Additional.JSON[i].FilePath = expanded
 	}
 
 	for i := range cfg.ExtraTargets.Files {
@@ -226,7 +386,7 @@ func Load(path string) (Config, error) {
 		if source == "" {
 			return Config{}, fmt.Errorf("config at %q has an extra file target without a source", path)
 		}
-		expandedSource, err := expandUserPath(source)
+		expandedSource, err := expandUserPath(source, home)
 		if err != nil {
 			return Config{}, fmt.Errorf("config at %q has an extra file target with invalid source %q: %w", path, source, err)
 		}
@@ -237,7 +397,7 @@ func Load(path string) (Config, error) {
 			if trimmedPath == "" {
 				continue
 			}
-			expandedPath, err := expandUserPath(trimmedPath)
+			expandedPath, err := expandUserPath(trimmedPath, home)
 			if err != nil {
 				return Config{}, fmt.Errorf("config at %q has an extra file target destination %q: %w", path, trimmedPath, err)
 			}
@@ -246,7 +406,7 @@ func Load(path string) (Config, error) {
 			trimmedSkills := strings.TrimSpace(dest.PathToSkills)
 			var expandedSkills string
 			if trimmedSkills != "" {
-				expandedSkills, err = expandUserPath(trimmedSkills)
+				expandedSkills, err = expandUserPath(trimmedSkills, home)
 				if err != nil {
 					return Config{}, fmt.Errorf("config at %q has an extra file target pathToSkills %q: %w", path, trimmedSkills, err)
 				}
@@ -259,7 +419,7 @@ func Load(path string) (Config, error) {
 				if trimmedSkillPath == "" {
 					continue
 				}
-				expandedSkillPath, err := expandUserPath(trimmedSkillPath)
+				expandedSkillPath, err := expandUserPath(trimmedSkillPath, home)
 				if err != nil {
 					return Config{}, fmt.Errorf("config at %q has an appendSkills path %q: %w", path, trimmedSkillPath, err)
 				}
@@ -283,7 +443,7 @@ func Load(path string) (Config, error) {
 			trimmedFrontmatter := strings.TrimSpace(dest.FrontmatterPath)
 			var expandedFrontmatter string
 			if trimmedFrontmatter != "" {
-				expandedFrontmatter, err = expandUserPath(trimmedFrontmatter)
+				expandedFrontmatter, err = expandUserPath(trimmedFrontmatter, home)
 				if err != nil {
 					return Config{}, fmt.Errorf("config at %q has an extra file target frontmatterPath %q: %w", path, trimmedFrontmatter, err)
 				}
@@ -307,7 +467,7 @@ func Load(path string) (Config, error) {
 		if source == "" {
 			return Config{}, fmt.Errorf("config at %q has an extra directory target without a source", path)
 		}
-		expandedSource, err := expandUserPath(source)
+		expandedSource, err := expandUserPath(source, home)
 		if err != nil {
 			return Config{}, fmt.Errorf("config at %q has an extra directory target with invalid source %q: %w", path, source, err)
 		}
@@ -318,7 +478,7 @@ func Load(path string) (Config, error) {
 			if trimmed == "" {
 				continue
 			}
-			expandedPath, err := expandUserPath(trimmed)
+			expandedPath, err := expandUserPath(trimmed, home)
 			if err != nil {
 				return Config{}, fmt.Errorf("config at %q has an extra directory destination %q: %w", path, trimmed, err)
 			}
@@ -343,20 +503,58 @@ func Load(path string) (Config, error) {
 		cfg.ExtraTargets.Directories[i].Destinations = routes
 	}
 
-	if len(cfg.MCP.Targets.Agents) == 0 &&
-		len(cfg.MCP.Targets.Additional.JSON) == 0 &&
-		cfg.ExtraTargets.IsZero() {
-		return Config{}, fmt.Errorf("config at %q must define at least one target", path)
+	cfg.Users.Names = trimList(cfg.Users.Names)
+	cfg.Users.Groups = trimList(cfg.Users.Groups)
+	cfg.Users.HomesUnder = strings.TrimSpace(cfg.Users.HomesUnder)
+	if homes := cfg.Users.HomesUnder; homes != "" {
+		if !filepath.IsAbs(homes) {
+			return Config{}, fmt.Errorf("config at %q has a relative users homesUnder %q", path, homes)
+		}
+		cfg.Users.HomesUnder = filepath.Clean(homes)
 	}
 
 	return cfg, nil
 }
 
+// validateAgentTransports checks the transport settings of an agent target.
+func validateAgentTransports(target AgentTarget) error {
+	for _, t := range target.Transports {
+		if t != "stdio" && t != "http" && t != "sse" {
+			return fmt.Errorf("unknown transport %q (expected stdio, http or sse)", t)
+		}
+	}
+	switch target.UnsupportedTransports {
+	case "", "skip", "fail", "bridge":
+	default:
+		return fmt.Errorf("unknown unsupportedTransports value %q (expected skip, fail or bridge)", target.UnsupportedTransports)
+	}
+	if target.Bridge != nil && strings.TrimSpace(target.Bridge.Command) == "" {
+		return fmt.Errorf("bridge requires a command")
+	}
+	return nil
+}
+
+// validateProjects checks the Claude Code projects of an agent target.
+func validateProjects(target AgentTarget) error {
+	if len(target.Projects) == 0 {
+		return nil
+	}
+	if target.Name != "claudecode" || target.Scope == "project" {
+		return fmt.Errorf("projects are only supported for user-level claudecode targets")
+	}
+	for path := range target.Projects {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("project path %q must be absolute", path)
+		}
+	}
+	return nil
+}
+
 func normalizeAgent(value string) string {
 	return strings.ToLower(strings.TrimSpace(value))
 }
 
-func normalizeTargets(targets TargetsConfig) TargetsConfig {
+func normalizeTargets(targets TargetsConfig, home string) TargetsConfig {
 	seen := make(map[string]struct{}, len(targets.Agents))
 	var agents []AgentTarget
 	for _, target := range targets.Agents {
@@ -364,45 +562,104 @@ func normalizeTargets(targets TargetsConfig) TargetsConfig {
 		if name == "" {
 			continue
 		}
+		scope := strings.ToLower(strings.TrimSpace(target.Scope))
 		path := strings.TrimSpace(target.Path)
 		if path != "" {
-			expanded, err := expandUserPath(path)
+			expanded, err := expandUserPath(path, home)
 			if err == nil {
 				path = expanded
 			}
 		}
-		// Normalize disabled MCP list: trim entries and skip empty
-		var disabled []string
-		for _, d := range target.DisabledMcpServers {
-			t := strings.TrimSpace(d)
-			if t == "" {
-				continue
+		// Normalize disabled MCP list and tags: trim entries and skip empty
+		disabled := trimList(target.DisabledMcpServers)
+		tags, excludeTags := trimList(target.Tags), trimList(target.ExcludeTags)
+		var transports []string
+		for _, t := range target.Transports {
+			if trimmed := strings.ToLower(strings.TrimSpace(t)); trimmed != "" {
+				transports = append(transports, trimmed)
 			}
-			disabled = append(disabled, t)
 		}
-		key := name + "|" + path + "|" + strings.Join(disabled, ",")
-		if _, exists := seen[key]; exists {
-			continue
+		normalized := AgentTarget{
+			Name:                  name,
+			Path:                  path,
+			Scope:                 scope,
+			DisabledMcpServers:    disabled,
+			Tags:                  tags,
+			ExcludeTags:           excludeTags,
+			Overrides:             target.Overrides,
+			Transports:            transports,
+			UnsupportedTransports: strings.ToLower(strings.TrimSpace(target.UnsupportedTransports)),
+			Bridge:                target.Bridge,
+			Projects:              normalizeProjects(target.Projects, home),
+		}
+		if key := targetKey(normalized); key != "" {
+			if _, exists := seen[key]; exists {
+				continue
+			}
+			seen[key] = struct{}{}
 		}
-		seen[key] = struct{}{}
-		agents = append(agents, AgentTarget{
-			Name:               name,
-			Path:               path,
-			DisabledMcpServers: disabled,
-		})
+		agents = append(agents, normalized)
 	}
 	targets.Agents = agents
 	return targets
 }
 
-func expandUserPath(value string) (string, error) {
+// targetKey identifies a normalized target by every field, so only exact
+// duplicates are dropped: targets that differ in any selection field render
+// differently and are all kept. It returns "" when the target cannot be
+// encoded, and such a target is never treated as a duplicate.
+func targetKey(target AgentTarget) string {
+	data, err := json.Marshal(target)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// normalizeProjects expands "~" in the project paths and trims the server
+// lists. Claude Code keys projects by clean absolute path.
+func normalizeProjects(projects map[string]ClaudeProjectConfig, home string) map[string]ClaudeProjectConfig {
+	if len(projects) == 0 {
+		return nil
+	}
+	out := make(map[string]ClaudeProjectConfig, len(projects))
+	for path, project := range projects {
+		if expanded, err := expandUserPath(path, home); err == nil {
+			path = expanded
+		}
+		if path = strings.TrimSpace(path); path != "" {
+			path = filepath.Clean(path)
+		}
+		out[path] = ClaudeProjectConfig{
+			Servers:            trimList(project.Servers),
+			Tags:               trimList(project.Tags),
+			DisabledMcpServers: trimList(project.DisabledMcpServers),
+		}
+	}
+	return out
+}
+
+// trimList trims every entry and drops the empty ones.
+func trimList(values []string) []string {
+	var out []string
+	for _, value := range values {
+		if trimmed := strings.TrimSpace(value); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
+
+func expandUserPath(value, home string) (string, error) {
 	value = strings.TrimSpace(value)
 	if value == "" || value[0] != '~' {
 		return value, nil
 	}
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("resolve home directory: %w", err)
+	if home == "" {
+		var err error
+		if home, err = fsys.HomeDir(); err != nil {
+			return "", fmt.Errorf("resolve home directory: %w", err)
+		}
 	}
 	if len(value) == 1 {
 		return home, nil
@@ -424,7 +681,62 @@ func (e ExtraTargetsConfig) IsZero() bool {
 	return len(e.Files) == 0 && len(e.Directories) == 0
 }
 
-// IsZero reports whether additional JSON targets are configured.
+// validateAdditionalTarget checks the path and shape of an additional target,
+// normalizes its server selection and fills in the default name key of
+// list-shaped targets.
+func validateAdditionalTarget(target *AdditionalJSONTarget) error {
+	path, err := docpath.Parse(target.JSONPath)
+	if err != nil {
+		return fmt.Errorf("invalid jsonPath %q: %w", target.JSONPath, err)
+	}
+
+	target.Transformer = normalizeAgent(target.Transformer)
+	target.DisabledMcpServers = trimList(target.DisabledMcpServers)
+	target.Tags = trimList(target.Tags)
+	target.ExcludeTags = trimList(target.ExcludeTags)
+
+	target.Shape = strings.ToLower(strings.TrimSpace(target.Shape))
+	target.NameKey = strings.TrimSpace(target.NameKey)
+	switch target.Shape {
+	case "", "map":
+	case "list":
+		if target.NameKey == "" {
+			target.NameKey = "name"
+		}
+	default:
+		return fmt.Errorf("unsupported shape %q (expected map or list)", target.Shape)
+	}
+
+	target.Strategy = strings.ToLower(strings.TrimSpace(target.Strategy))
+	switch target.Strategy {
+	case "", "replace", "merge", "managed":
+	default:
+		return fmt.Errorf("unsupported strategy %q (expected replace, merge or managed)", target.Strategy)
+	}
+
+	if target.Format == "toml" {
+		if path.HasIndex() {
+			return fmt.Errorf("jsonPath %q: TOML targets do not support array indices", target.JSONPath)
+		}
+		if target.Shape == "list" && len(path) == 0 {
+			return errors.New("TOML targets with shape list need a jsonPath")
+		}
+		if target.Shape == "list" && target.Strategy != "" && target.Strategy != "replace" {
+			return errors.New("TOML targets with shape list only support strategy replace")
+		}
+	}
+	return nil
+}
+
+// IsZero reports whether no additional targets are configured.
 func (a AdditionalTargets) IsZero() bool {
-	return len(a.JSON) == 0
+	return len(a.JSON) == 0 && len(a.YAML) == 0 && len(a.TOML) == 0
+}
+
+// All returns the JSON, YAML and TOML targets in that order.
+func (a AdditionalTargets) All() []AdditionalJSONTarget {
+	all := make([]AdditionalJSONTarget, 0, len(a.JSON)+len(a.YAML)+len(a.TOML))
+	all = append(all, a.JSON...)
+	all = append(all, a.YAML...)
+	return append(all, a.TOML...)
 }
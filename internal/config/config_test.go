@@ -3,13 +3,13 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
 
 func TestLoadValidConfig(t *testing.T) {
 	dir := t.TempDir()
-	t.Setenv("HOME", dir)
 
 	content := `mcpServers:
   configPath: ~/agent-align-mcp.yml
@@ -30,7 +30,7 @@ extraTargets:
 `
 
 	path := writeConfigFile(t, content)
-	got, err := Load(path)
+	got, err := LoadWithHome(path, dir)
 	if err != nil {
 		t.Fatalf("Load returned error: %v", err)
 	}
@@ -87,9 +87,69 @@ func TestLoadRejectsInvalidAdditionalTarget(t *testing.T) {
 	}
 }
 
+func TestLoadAdditionalYAMLAndTOMLTargets(t *testing.T) {
+	dir := t.TempDir()
+
+	path := writeConfigFile(t, `mcpServers:
+  targets:
+    additionalTargets:
+      yaml:
+        - filePath: ~/.config/goose/config.yaml
+          jsonPath: extensions
+      toml:
+        - filePath: " /etc/runner.toml "
+          jsonPath: " tools.mcp "
+`)
+
+	got, err := LoadWithHome(path, dir)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	want := []AdditionalJSONTarget{
+		{FilePath: filepath.Join(dir, ".config/goose/config.yaml"), JSONPath: "extensions", Format: "yaml"},
+		{FilePath: "/etc/runner.toml", JSONPath: "tools.mcp", Format: "toml"},
+	}
+	if all := got.MCP.Targets.Additional.All(); !reflect.DeepEqual(all, want) {
+		t.Fatalf("unexpected additional targets: %#v", all)
+	}
+}
+
+func TestLoadValidatesAdditionalTargetPathsAndShapes(t *testing.T) {
+	path := writeConfigFile(t, `mcpServers:
+  targets:
+    additionalTargets:
+      json:
+        - filePath: /tmp/settings.json
+          jsonPath: '["mcp.servers"]'
+          shape: List
+          strategy: Managed
+`)
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if target := got.MCP.Targets.Additional.JSON[0]; target.Shape != "list" || target.NameKey != "name" || target.Strategy != "managed" {
+		t.Fatalf("expected managed list shape with default name key, got %#v", target)
+	}
+
+	cases := map[string]string{
+		"bad shape":       "json:\n        - filePath: /tmp/a.json\n          shape: tree\n",
+		"bad path":        "json:\n        - filePath: /tmp/a.json\n          jsonPath: 'a[x]'\n",
+		"toml index":      "toml:\n        - filePath: /tmp/a.toml\n          jsonPath: 'a[0]'\n",
+		"toml list":       "toml:\n        - filePath: /tmp/a.toml\n          shape: list\n",
+		"bad strategy":    "json:\n        - filePath: /tmp/a.json\n          strategy: upsert\n",
+		"toml list merge": "toml:\n        - filePath: /tmp/a.toml\n          jsonPath: mcp\n          shape: list\n          strategy: merge\n",
+	}
+	for name, targets := range cases {
+		path := writeConfigFile(t, "mcpServers:\n  targets:\n    additionalTargets:\n      "+targets)
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
 func TestLoadExtraFileTargetsBackwardCompatibility(t *testing.T) {
 	dir := t.TempDir()
-	t.Setenv("HOME", dir)
 
 	// Test that both old string format and new object format work
 	content := `mcpServers:
@@ -107,7 +167,7 @@ extraTargets:
 `
 
 	path := writeConfigFile(t, content)
-	got, err := Load(path)
+	got, err := LoadWithHome(path, dir)
 	if err != nil {
 		t.Fatalf("Load returned error: %v", err)
 	}
@@ -156,7 +216,6 @@ extraTargets:
 
 func TestLoadExtraFileTargetsWithAppendSkills(t *testing.T) {
 	dir := t.TempDir()
-	t.Setenv("HOME", dir)
 
 	// Test new appendSkills format
 	content := `mcpServers:
@@ -177,7 +236,7 @@ extraTargets:
 `
 
 	path := writeConfigFile(t, content)
-	got, err := Load(path)
+	got, err := LoadWithHome(path, dir)
 	if err != nil {
 		t.Fatalf("Load returned error: %v", err)
 	}
@@ -223,7 +282,6 @@ extraTargets:
 
 func TestLoadExtraFileTargetsWithFrontmatter(t *testing.T) {
 	dir := t.TempDir()
-	t.Setenv("HOME", dir)
 
 	content := `mcpServers:
   targets:
@@ -241,7 +299,7 @@ extraTargets:
 `
 
 	path := writeConfigFile(t, content)
-	got, err := Load(path)
+	got, err := LoadWithHome(path, dir)
 	if err != nil {
 		t.Fatalf("Load returned error: %v", err)
 	}
@@ -291,3 +349,151 @@ func writeConfigFile(t *testing.T, contents string) string {
 	}
 	return path
 }
+
+func TestLoadAgentTransportSettings(t *testing.T) {
+	content := `mcpServers:
+  targets:
+    agents:
+      - name: codex
+        transports: [STDIO, http]
+        unsupportedTransports: Bridge
+        bridge:
+          command: uvx
+          args: [mcp-proxy, "{url}"]
+          headerFlag: -H
+`
+	got, err := Load(writeConfigFile(t, content))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	agent := got.MCP.Targets.Agents[0]
+	if strings.Join(agent.Transports, ",") != "stdio,http" || agent.UnsupportedTransports != "bridge" {
+		t.Fatalf("unexpected transport settings: %#v", agent)
+	}
+	if agent.Bridge == nil || agent.Bridge.Command != "uvx" || agent.Bridge.HeaderFlag != "-H" {
+		t.Fatalf("unexpected bridge: %#v", agent.Bridge)
+	}
+}
+
+func TestLoadKeepsTargetsThatDifferInSelection(t *testing.T) {
+	content := `mcpServers:
+  targets:
+    agents:
+      - copilot
+      - name: copilot
+        overrides:
+          github: {args: [--fast]}
+      - name: copilot
+        transports: [stdio]
+      - name: copilot
+        overrides:
+          github: {args: [--fast]}
+`
+	got, err := Load(writeConfigFile(t, content))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if agents := got.MCP.Targets.Agents; len(agents) != 3 {
+		t.Fatalf("expected only the exact duplicate to be dropped, got %+v", agents)
+	}
+}
+
+func TestLoadRejectsInvalidTransportSettings(t *testing.T) {
+	cases := map[string]string{
+		"transport": "transports: [websocket]",
+		"policy":    "unsupportedTransports: ignore",
+		"bridge":    "bridge: {args: [x]}",
+	}
+	for name, setting := range cases {
+		t.Run(name, func(t *testing.T) {
+			content := "mcpServers:\n  targets:\n    agents:\n      - name: codex\n        " + setting + "\n"
+			if _, err := Load(writeConfigFile(t, content)); err == nil {
+				t.Fatalf("expected error for %s", setting)
+			}
+		})
+	}
+}
+
+func TestLoadAgentScope(t *testing.T) {
+	content := `mcpServers:
+  targets:
+    agents:
+      - vscode
+      - name: vscode
+        scope: Project
+`
+	got, err := Load(writeConfigFile(t, content))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	agents := got.MCP.Targets.Agents
+	if len(agents) != 2 || agents[0].Scope != "" || agents[1].Scope != "project" {
+		t.Fatalf("unexpected agents: %#v", agents)
+	}
+
+	content = "mcpServers:\n  targets:\n    agents:\n      - name: vscode\n        scope: workspace\n"
+	if _, err := Load(writeConfigFile(t, content)); err == nil || !strings.Contains(err.Error(), "unknown scope") {
+		t.Fatalf("expected an unknown scope error, got %v", err)
+	}
+}
+
+func TestLoadClaudeProjects(t *testing.T) {
+	content := `mcpServers:
+  targets:
+    agents:
+      - name: claudecode
+        projects:
+          ~/src/app/:
+            servers: [db, " docs "]
+          /work/site:
+            tags: [web]
+            disabledMcpServers: [slow]
+`
+	got, err := LoadWithHome(writeConfigFile(t, content), "/home/me")
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	want := map[string]ClaudeProjectConfig{
+		"/home/me/src/app": {Servers: []string{"db", "docs"}},
+		"/work/site":       {Tags: []string{"web"}, DisabledMcpServers: []string{"slow"}},
+	}
+	if projects := got.MCP.Targets.Agents[0].Projects; !reflect.DeepEqual(projects, want) {
+		t.Fatalf("projects = %#v, want %#v", projects, want)
+	}
+
+	cases := map[string]string{
+		"agent":    "- name: gemini\n        projects: {/work/site: {}}",
+		"scope":    "- name: claudecode\n        scope: project\n        projects: {/work/site: {}}",
+		"relative": "- name: claudecode\n        projects: {site: {}}",
+	}
+	for name, agent := range cases {
+		content := "mcpServers:\n  targets:\n    agents:\n      " + agent + "\n"
+		if _, err := Load(writeConfigFile(t, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadUsersSection(t *testing.T) {
+	content := `users:
+  names: [" alice ", ""]
+  groups: [developers]
+  homesUnder: /home/
+mcpServers:
+  targets:
+    agents: [copilot]
+`
+	got, err := Load(writeConfigFile(t, content))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	want := UsersConfig{Names: []string{"alice"}, Groups: []string{"developers"}, HomesUnder: "/home"}
+	if !reflect.DeepEqual(got.Users, want) {
+		t.Fatalf("users = %#v, want %#v", got.Users, want)
+	}
+
+	content = "users:\n  homesUnder: home\nmcpServers:\n  targets:\n    agents: [copilot]\n"
+	if _, err := Load(writeConfigFile(t, content)); err == nil || !strings.Contains(err.Error(), "relative users homesUnder") {
+		t.Fatalf("expected an error for a relative homesUnder, got %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"agent-align/internal/fsys"
+)
+
+// Names of the config layers, lowest precedence first.
+const (
+	LayerSystem  = "system"
+	LayerUser    = "user"
+	LayerProject = "project"
+)
+
+// ProjectConfigName is the project-local config file.
+const ProjectConfigName = ".agent-align.yml"
+
+// Layer is one file of a layered configuration.
+type Layer struct {
+	Name string
+	Path string
+}
+
+// LayerData is a Layer with the content of its file.
+type LayerData struct {
+	Layer
+	Data []byte
+}
+
+// Layers returns the files merged when no single config is given, lowest
+// precedence first: the system config at systemPath, the user config at
+// $XDG_CONFIG_HOME/agent-align/config.yml (~/.config when unset, and always
+// below home when home is set) and the nearest .agent-align.yml in
+// projectDir or one of its parents. The project layer is left out when
+// projectDir is empty or no such file exists; the other layers are returned
+// whether or not their files exist.
+func Layers(systemPath, home, projectDir string) []Layer {
+	layers := []Layer{{Name: LayerSystem, Path: systemPath}}
+	configHome := strings.TrimSpace(os.Getenv("XDG_CONFIG_HOME"))
+	if home != "" || configHome == "" {
+		if home == "" {
+			home, _ = fsys.HomeDir()
+		}
+		configHome = ""
+		if home != "" {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		layers = append(layers, Layer{Name: LayerUser, Path: filepath.Join(configHome, "agent-align", "config.yml")})
+	}
+	if path := findProjectConfig(projectDir); path != "" {
+		layers = append(layers, Layer{Name: LayerProject, Path: path})
+	}
+	return layers
+}
+
+// findProjectConfig returns the nearest ProjectConfigName in dir or its
+// parents.
+func findProjectConfig(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfigName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// MCPSource is an MCP definitions file of a layered configuration.
+type MCPSource struct {
+	Path string
+	// Origin is the config file the source belongs to.
+	Origin string
+	// Optional sources are the default agent-align-mcp.yml next to a config
+	// file without a configPath, and are skipped when they do not exist.
+	Optional bool
+	// Project sources belong to the project config. A checked-out project
+	// is not trusted beyond its own files, so their servers only reach
+	// project-scope targets.
+	Project bool
+}
+
+// Origins maps the location of a merged value, such as
+// mcpServers.targets.agents[1], to the config file it came from.
+type Origins map[string]string
+
+// Merged is a configuration merged from several layers.
+type Merged struct {
+	Config Config
+	// Layers are the files that were merged.
+	Layers []Layer
+	// MCPSources lists the MCP definitions files of every layer. Servers of
+	// later sources replace servers of the same name.
+	MCPSources []MCPSource
+	Origins    Origins
+}
+
+// MergeLayers parses every layer and merges them, later layers taking
+// precedence:
+//
+//   - agent targets with the same name, scope and path replace the earlier entry;
+//     other agents are added.
+//   - additional targets with the same filePath and jsonPath replace the
+//     earlier entry; others are added.
+//   - extra file and directory targets with the same source replace the
+//     earlier entry; others are added.
+//   - a users section replaces the earlier one.
+//   - every layer contributes its MCP definitions file: its configPath, or
+//     agent-align-mcp.yml next to it when that exists.
+//
+// The project layer comes with the project, which anyone may have written,
+// so it may only define agent targets for the files of the project; see
+// checkProjectLayer and MCPSource.Project.
+// The merged configuration must define at least one target.
+func MergeLayers(layers []LayerData, home string) (Merged, error) {
+	merged := Merged{Origins: make(Origins)}
+	if len(layers) == 0 {
+		return merged, errors.New("no config files to merge")
+	}
+	var agentOrigins, fileOrigins, dirOrigins []string
+	additionalOrigins := make(map[string][]string)
+	var usersOrigin, configPathOrigin string
+	cfg := &merged.Config
+
+	for _, layer := range layers {
+		parsed, err := parseConfig(layer.Path, layer.Data, home)
+		if err != nil {
+			return Merged{}, err
+		}
+		project := layer.Name == LayerProject
+		if project {
+			if err := checkProjectLayer(layer.Path, &parsed); err != nil {
+				return Merged{}, err
+			}
+		}
+		merged.Layers = append(merged.Layers, layer.Layer)
+
+		if parsed.MCP.ConfigPath != "" {
+			cfg.MCP.ConfigPath, configPathOrigin = parsed.MCP.ConfigPath, layer.Path
+			merged.MCPSources = append(merged.MCPSources, MCPSource{Path: parsed.MCP.ConfigPath, Origin: layer.Path, Project: project})
+		} else {
+			sibling := filepath.Join(filepath.Dir(layer.Path), "agent-align-mcp.yml")
+			merged.MCPSources = append(merged.MCPSources, MCPSource{Path: sibling, Origin: layer.Path, Optional: true, Project: project})
+		}
+
+		for _, agent := range parsed.MCP.Targets.Agents {
+			i := indexOf(len(cfg.MCP.Targets.Agents), func(i int) bool {
+				existing := cfg.MCP.Targets.Agents[i]
+				return existing.Name == agent.Name && existing.Scope == agent.Scope && existing.Path == agent.Path
+			})
+			if i < 0 {
+				cfg.MCP.Targets.Agents = append(cfg.MCP.Targets.Agents, agent)
+				agentOrigins = append(agentOrigins, layer.Path)
+				continue
+			}
+			cfg.MCP.Targets.Agents[i], agentOrigins[i] = agent, layer.Path
+		}
+
+		for _, list := range []struct {
+			format string
+			into   *[]AdditionalJSONTarget
+			from   []AdditionalJSONTarget
+		}{
+			{"json", &cfg.MCP.Targets.Additional.JSON, parsed.MCP.Targets.Additional.JSON},
+			{"yaml", &cfg.MCP.Targets.Additional.YAML, parsed.MCP.Targets.Additional.YAML},
+			{"toml", &cfg.MCP.Targets.Additional.TOML, parsed.MCP.Targets.Additional.TOML},
+		} {
+			for _, target := range list.from {
+				i := indexOf(len(*list.into), func(i int) bool {
+					existing := (*list.into)[i]
+					return existing.FilePath == target.FilePath && existing.JSONPath == target.JSONPath
+				})
+				if i < 0 {
+					*list.into = append(*list.into, target)
+					additionalOrigins[list.format] = append(additionalOrigins[list.format], layer.Path)
+					continue
+				}
+				(*list.into)[i], additionalOrigins[list.format][i] = target, layer.Path
+			}
+		}
+
+		for _, target := range parsed.ExtraTargets.Files {
+			i := indexOf(len(cfg.ExtraTargets.Files), func(i int) bool { return cfg.ExtraTargets.Files[i].Source == target.Source })
+			if i < 0 {
+				cfg.ExtraTargets.Files = append(cfg.ExtraTargets.Files, target)
+				fileOrigins = append(fileOrigins, layer.Path)
+				continue
+			}
+			cfg.ExtraTargets.Files[i], fileOrigins[i] = target, layer.Path
+		}
+		for _, target := range parsed.ExtraTargets.Directories {
+			i := indexOf(len(cfg.ExtraTargets.Directories), func(i int) bool { return cfg.ExtraTargets.Directories[i].Source == target.Source })
+			if i < 0 {
+				cfg.ExtraTargets.Directories = append(cfg.ExtraTargets.Directories, target)
+				dirOrigins = append(dirOrigins, layer.Path)
+				continue
+			}
+			cfg.ExtraTargets.Directories[i], dirOrigins[i] = target, layer.Path
+		}
+
+		if !parsed.Users.IsZero() {
+			cfg.Users, usersOrigin = parsed.Users, layer.Path
+		}
+	}
+
+	if configPathOrigin != "" {
+		merged.Origins["mcpServers.configPath"] = configPathOrigin
+	}
+	for i, origin := range agentOrigins {
+		merged.Origins[fmt.Sprintf("mcpServers.targets.agents[%d]", i)] = origin
+	}
+	for format, origins := range additionalOrigins {
+		for i, origin := range origins {
+			merged.Origins[fmt.Sprintf("mcpServers.targets.additionalTargets.%s[%d]", format, i)] = origin
+		}
+	}
+	for i, origin := range fileOrigins {
+		merged.Origins[fmt.Sprintf("extraTargets.files[%d]", i)] = origin
+	}
+	for i, origin := range dirOrigins {
+		merged.Origins[fmt.Sprintf("extraTargets.directories[%d]", i)] = origin
+	}
+	if usersOrigin != "" {
+		merged.Origins["users"] = usersOrigin
+	}
+
+	if !hasTargets(*cfg) {
+		paths := make([]string, len(layers))
+		for i, layer := range layers {
+			paths[i] = layer.Path
+		}
+		return Merged{}, fmt.Errorf("configs %s must define at least one target", strings.Join(paths, ", "))
+	}
+	return merged, nil
+}
+
+// checkProjectLayer restricts the project config at path to agent targets
+// that write the files of its project: targets default to the project
+// scope, and any other scope, a path outside the project or another section
+// is an error.
+func checkProjectLayer(path string, cfg *Config) error {
+	dir := filepath.Dir(path)
+	var found []string
+	for i := range cfg.MCP.Targets.Agents {
+		agent := &cfg.MCP.Targets.Agents[i]
+		if agent.Scope == "" {
+			agent.Scope = "project"
+		}
+		if agent.Scope != "project" {
+			found = append(found, fmt.Sprintf("a %s target with scope %s", agent.Name, agent.Scope))
+			continue
+		}
+		if agent.Path == "" {
+			continue
+		}
+		target := agent.Path
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		if rel, err := filepath.Rel(dir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			found = append(found, fmt.Sprintf("a %s target with path %s outside the project", agent.Name, agent.Path))
+		}
+	}
+	if !cfg.MCP.Targets.Additional.IsZero() {
+		found = append(found, "additionalTargets")
+	}
+	if !cfg.ExtraTargets.IsZero() {
+		found = append(found, "extraTargets")
+	}
+	if !cfg.Users.IsZero() {
+		found = append(found, "users")
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("project config %q may only define agent targets for its project, found %s", path, strings.Join(found, ", "))
+	}
+	return nil
+}
+
+// indexOf returns the first index below n for which match is true, or -1.
+func indexOf(n int, match func(i int) bool) int {
+	for i := 0; i < n; i++ {
+		if match(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// MarshalWithOrigins renders the merged configuration as YAML with a comment
+// naming the file, and its layer, of every value that has an origin.
+func (m Merged) MarshalWithOrigins() ([]byte, error) {
+	var doc yaml.Node
+	if err := doc.Encode(m.Config); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	names := make(map[string]string, len(m.Layers))
+	for _, layer := range m.Layers {
+		names[layer.Path] = layer.Name
+	}
+	labels := make(Origins, len(m.Origins))
+	for location, origin := range m.Origins {
+		labels[location] = origin
+		if name := names[origin]; name != "" {
+			labels[location] = fmt.Sprintf("%s (%s)", origin, name)
+		}
+	}
+	annotateOrigins(&doc, "", labels)
+
+	var out strings.Builder
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return []byte(out.String()), nil
+}
+
+// annotateOrigins adds a line comment to every node whose location is in
+// origins. The comment of a mapping entry goes on its key, and that of a
+// sequence item holding a mapping on the item's first key.
+func annotateOrigins(node *yaml.Node, location string, origins Origins) {
+	comment := func(target *yaml.Node, location string) {
+		if origin, ok := origins[location]; ok {
+			if target.Kind == yaml.MappingNode && len(target.Content) > 0 {
+				target = target.Content[0]
+			}
+			target.LineComment = "from " + origin
+		}
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			child := node.Content[i].Value
+			if location != "" {
+				child = location + "." + child
+			}
+			comment(node.Content[i], child)
+			annotateOrigins(node.Content[i+1], child, origins)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			child := fmt.Sprintf("%s[%d]", location, i)
+			comment(item, child)
+			annotateOrigins(item, child, origins)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeLayers(t *testing.T) {
+	system := LayerData{Layer: Layer{Name: LayerSystem, Path: "/etc/agent-align.yml"}, Data: []byte(`mcpServers:
+  targets:
+    agents: [codex, copilot]
+    additionalTargets:
+      json:
+        - filePath: /srv/tool.json
+          jsonPath: servers
+extraTargets:
+  files:
+    - source: /srv/AGENTS.md
+      destinations: [/srv/out/AGENTS.md]
+`)}
+	user := LayerData{Layer: Layer{Name: LayerUser, Path: "/home/me/.config/agent-align/config.yml"}, Data: []byte(`mcpServers:
+  configPath: ~/mcp.yml
+  targets:
+    agents:
+      - name: copilot
+        disabledMcpServers: [slow]
+      - gemini
+    additionalTargets:
+      json:
+        - filePath: /srv/tool.json
+          jsonPath: servers
+          strategy: merge
+extraTargets:
+  files:
+    - source: /srv/AGENTS.md
+      destinations: [~/AGENTS.md]
+`)}
+	project := LayerData{Layer: Layer{Name: LayerProject, Path: "/work/repo/.agent-align.yml"}, Data: []byte(`mcpServers:
+  targets:
+    agents:
+      - codex
+      - name: claudecode
+        path: config/mcp.json
+`)}
+
+	merged, err := MergeLayers([]LayerData{system, user, project}, "/home/me")
+	if err != nil {
+		t.Fatalf("MergeLayers returned error: %v", err)
+	}
+	var agents []string
+	for _, agent := range merged.Config.MCP.Targets.Agents {
+		agents = append(agents, agent.Name+strings.Join(agent.DisabledMcpServers, ","))
+	}
+	for _, agent := range merged.Config.MCP.Targets.Agents[3:] {
+		if agent.Scope != "project" {
+			t.Fatalf("the project config's %s target has scope %q, want project", agent.Name, agent.Scope)
+		}
+	}
+	if want := []string{"codex", "copilotslow", "gemini", "codex", "claudecode"}; !reflect.DeepEqual(agents, want) {
+		t.Fatalf("agents = %v, want %v", agents, want)
+	}
+	if json := merged.Config.MCP.Targets.Additional.JSON; len(json) != 1 || json[0].Strategy != "merge" {
+		t.Fatalf("additional targets were not replaced: %+v", json)
+	}
+	if files := merged.Config.ExtraTargets.Files; len(files) != 1 || files[0].Destinations[0].Path != "/home/me/AGENTS.md" {
+		t.Fatalf("extra files were not replaced: %+v", files)
+	}
+	wantSources := []MCPSource{
+		{Path: "/etc/agent-align-mcp.yml", Origin: system.Path, Optional: true},
+		{Path: filepath.Join("/home/me", "mcp.yml"), Origin: user.Path},
+		{Path: "/work/repo/agent-align-mcp.yml", Origin: project.Path, Optional: true, Project: true},
+	}
+	if !reflect.DeepEqual(merged.MCPSources, wantSources) {
+		t.Fatalf("sources = %+v, want %+v", merged.MCPSources, wantSources)
+	}
+
+	out, err := merged.MarshalWithOrigins()
+	if err != nil {
+		t.Fatalf("MarshalWithOrigins returned error: %v", err)
+	}
+	for _, line := range []string{
+		"- name: codex # from /etc/agent-align.yml (system)",
+		"- name: copilot # from /home/me/.config/agent-align/config.yml (user)",
+		"- filePath: /srv/tool.json # from /home/me/.config/agent-align/config.yml (user)",
+		"- source: /srv/AGENTS.md # from /home/me/.config/agent-align/config.yml (user)",
+		"- name: claudecode # from /work/repo/.agent-align.yml (project)",
+	} {
+		if !strings.Contains(string(out), line) {
+			t.Fatalf("output is missing %q:\n%s", line, out)
+		}
+	}
+
+	usersOnly := LayerData{Layer: system.Layer, Data: []byte("users:\n  names: [me]\n")}
+	if _, err := MergeLayers([]LayerData{usersOnly}, ""); err == nil || !strings.Contains(err.Error(), "at least one target") {
+		t.Fatalf("expected an error without targets, got %v", err)
+	}
+}
+
+func TestMergeLayersRestrictsTheProjectConfig(t *testing.T) {
+	system := LayerData{Layer: Layer{Name: LayerSystem, Path: "/etc/agent-align.yml"}, Data: []byte("mcpServers:\n  targets:\n    agents: [codex]\n")}
+	for name, data := range map[string]string{
+		"user scope":         "mcpServers:\n  targets:\n    agents:\n      - name: codex\n        scope: user\n",
+		"path outside":       "mcpServers:\n  targets:\n    agents:\n      - name: claudecode\n        path: ../../home/me/.claude.json\n",
+		"path in the home":   "mcpServers:\n  targets:\n    agents:\n      - name: claudecode\n        path: ~/.claude.json\n",
+		"additional targets": "mcpServers:\n  targets:\n    additionalTargets:\n      json:\n        - filePath: /srv/tool.json\n          jsonPath: servers\n",
+		"extra targets":      "extraTargets:\n  files:\n    - source: /etc/shadow\n      destinations: [/work/repo/shadow]\n",
+		"users":              "users:\n  names: [me]\nmcpServers:\n  targets:\n    agents: [codex]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			project := LayerData{Layer: Layer{Name: LayerProject, Path: "/work/repo/.agent-align.yml"}, Data: []byte(data)}
+			_, err := MergeLayers([]LayerData{system, project}, "/home/me")
+			if err == nil || !strings.Contains(err.Error(), "may only define agent targets for its project") {
+				t.Fatalf("expected the project config to be refused, got %v", err)
+			}
+		})
+	}
+}
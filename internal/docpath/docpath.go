@@ -0,0 +1,194 @@
+// Package docpath parses the paths that locate the MCP payload inside an
+// additional target's document.
+//
+// Two notations are accepted. A JSON Pointer starts with "/" and follows
+// RFC 6901. Anything else is a JSONPath subset: an optional leading "$",
+// dot-separated keys that may be quoted to contain dots ("mcp.servers" or
+// 'mcp.servers'), and bracketed array indices or quoted keys ([0],
+// ["mcp.servers"]). Empty segments are ignored, so ".mcpServers" and
+// "mcpServers" are the same path.
+package docpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment is one step of a path. A segment names an object key, an array
+// index, or, for JSON Pointer tokens made of digits, either one depending on
+// the node it is applied to.
+type Segment struct {
+	Key     string
+	Index   int
+	IsKey   bool
+	IsIndex bool
+}
+
+// String renders the segment in bracket notation.
+func (s Segment) String() string {
+	if !s.IsKey {
+		return fmt.Sprintf("[%d]", s.Index)
+	}
+	return "[" + strconv.Quote(s.Key) + "]"
+}
+
+// Path is a parsed path. An empty Path addresses the document root.
+type Path []Segment
+
+// String renders the path in bracket notation for error messages, for
+// example $["mcp.servers"][0].
+func (p Path) String() string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, segment := range p {
+		sb.WriteString(segment.String())
+	}
+	return sb.String()
+}
+
+// HasIndex reports whether any segment can only address an array element.
+func (p Path) HasIndex() bool {
+	for _, segment := range p {
+		if !segment.IsKey {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys of a path made only of key segments.
+func (p Path) Keys() []string {
+	keys := make([]string, 0, len(p))
+	for _, segment := range p {
+		keys = append(keys, segment.Key)
+	}
+	return keys
+}
+
+// Parse parses path in either notation.
+func Parse(path string) (Path, error) {
+	trimmed := strings.TrimSpace(path)
+	if strings.HasPrefix(trimmed, "/") {
+		return parsePointer(trimmed)
+	}
+	return parseJSONPath(trimmed)
+}
+
+func parsePointer(path string) (Path, error) {
+	var out Path
+	for _, token := range strings.Split(path[1:], "/") {
+		var sb strings.Builder
+		for i := 0; i < len(token); i++ {
+			if token[i] != '~' {
+				sb.WriteByte(token[i])
+				continue
+			}
+			if i+1 >= len(token) || (token[i+1] != '0' && token[i+1] != '1') {
+				return nil, fmt.Errorf("invalid escape in JSON Pointer token %q", token)
+			}
+			if token[i+1] == '0' {
+				sb.WriteByte('~')
+			} else {
+				sb.WriteByte('/')
+			}
+			i++
+		}
+		segment := Segment{Key: sb.String(), IsKey: true}
+		if index, ok := arrayIndex(token); ok {
+			segment.Index, segment.IsIndex = index, true
+		}
+		out = append(out, segment)
+	}
+	return out, nil
+}
+
+func parseJSONPath(path string) (Path, error) {
+	rest := strings.TrimPrefix(path, "$")
+	var out Path
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if rest[1:] != "" && (rest[1] == '"' || rest[1] == '\'') {
+				key, tail, err := quotedKey(rest[1:])
+				if err != nil {
+					return nil, err
+				}
+				if !strings.HasPrefix(tail, "]") {
+					return nil, fmt.Errorf("expected ] after %q", key)
+				}
+				out = append(out, Segment{Key: key, IsKey: true})
+				rest = tail[1:]
+				continue
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in %q", path)
+			}
+			index, ok := arrayIndex(strings.TrimSpace(rest[1:end]))
+			if !ok {
+				return nil, fmt.Errorf("invalid array index %q", rest[1:end])
+			}
+			out = append(out, Segment{Index: index, IsIndex: true})
+			rest = rest[end+1:]
+		case '"', '\'':
+			key, tail, err := quotedKey(rest)
+			if err != nil {
+				return nil, err
+			}
+			if tail != "" && tail[0] != '.' && tail[0] != '[' {
+				return nil, fmt.Errorf("unexpected %q after quoted key %q", tail, key)
+			}
+			out = append(out, Segment{Key: key, IsKey: true})
+			rest = tail
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if key := strings.TrimSpace(rest[:end]); key != "" {
+				out = append(out, Segment{Key: key, IsKey: true})
+			}
+			rest = rest[end:]
+		}
+	}
+	return out, nil
+}
+
+// quotedKey reads a double- or single-quoted key from the start of s and
+// returns it with the text that follows.
+func quotedKey(s string) (string, string, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			if quote == '\'' {
+				return s[1:i], s[i+1:], nil
+			}
+			key, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid quoted key %s: %w", s[:i+1], err)
+			}
+			return key, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted key %s", s)
+}
+
+// arrayIndex parses a non-negative decimal index without leading zeros.
+func arrayIndex(s string) (int, bool) {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	index, err := strconv.Atoi(s)
+	return index, err == nil
+}
@@ -0,0 +1,58 @@
+package docpath
+
+import (
+	"reflect"
+	"testing"
+)
+
+func key(k string) Segment { return Segment{Key: k, IsKey: true} }
+
+func index(i int) Segment { return Segment{Index: i, IsIndex: true} }
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		path string
+		want Path
+	}{
+		{"", nil},
+		{".", nil},
+		{"$", nil},
+		{".mcpServers", Path{key("mcpServers")}},
+		{"root.value", Path{key("root"), key("value")}},
+		{"nested..value", Path{key("nested"), key("value")}},
+		{`"mcp.servers"`, Path{key("mcp.servers")}},
+		{`$.settings['mcp.servers']`, Path{key("settings"), key("mcp.servers")}},
+		{`profiles[1]["mcp.servers"]`, Path{key("profiles"), index(1), key("mcp.servers")}},
+		{`a."b\"c".d`, Path{key("a"), key(`b"c`), key("d")}},
+		{"/mcp.servers/a~1b~0", Path{key("mcp.servers"), key("a/b~")}},
+		{"/profiles/0", Path{key("profiles"), {Key: "0", IsKey: true, IsIndex: true}}},
+		{"/", Path{key("")}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := Parse(tc.path)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tc.path, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Parse(%q) = %#v, want %#v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsMalformedPaths(t *testing.T) {
+	for _, path := range []string{`"open`, `a[1`, `a[-1]`, `a[01]`, `a[x]`, `"a"b`, `['a'`, "/a~2"} {
+		if _, err := Parse(path); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", path)
+		}
+	}
+}
+
+func TestPathString(t *testing.T) {
+	path := Path{key("mcp.servers"), index(2)}
+	if got, want := path.String(), `$["mcp.servers"][2]`; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+}
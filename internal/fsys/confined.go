@@ -0,0 +1,212 @@
+package fsys
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Owner is the numeric owner of a file.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// Confined serves the files below Dir, a directory that another account
+// controls, such as its home, to a process that may run as root. Every path
+// is resolved through os.Root, so a symbolic link that leads out of Dir fails
+// instead of being followed, even when it is swapped in during the call.
+// Files and directories that are symbolic links themselves are neither read
+// nor written, and files are written to a new temporary file that is renamed
+// into place. Paths outside Dir are refused.
+type Confined struct {
+	Dir string
+	// Owner, when set, receives every file written and every directory
+	// created. Files are handed over through their open descriptor, so no
+	// link can redirect the change.
+	Owner *Owner
+}
+
+// ReadFile reads the named file.
+func (c Confined) ReadFile(name string) ([]byte, error) {
+	root, rel, err := c.open(name, false)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	info, err := root.Lstat(rel)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return nil, c.linkError(name)
+	}
+	f, err := root.Open(rel)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	opened, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !os.SameFile(info, opened) {
+		return nil, c.linkError(name)
+	}
+	return io.ReadAll(f)
+}
+
+// WriteFile replaces the named file with data. An existing file keeps its
+// permissions; a new one gets perm.
+func (c Confined) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	root, rel, err := c.open(name, true)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	info, err := root.Lstat(rel)
+	switch {
+	case err == nil && info.Mode()&fs.ModeSymlink != 0:
+		return c.linkError(name)
+	case err == nil:
+		perm = info.Mode().Perm()
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(rel), "."+filepath.Base(rel)+".agent-align-"+rand.Text())
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if c.Owner != nil {
+		err = f.Chown(c.Owner.UID, c.Owner.GID)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = root.Rename(tmp, rel)
+	}
+	if err != nil {
+		_ = root.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// MkdirAll creates a directory along with any necessary parents.
+func (c Confined) MkdirAll(path string, perm fs.FileMode) error {
+	root, rel, err := c.open(path, true)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if rel == "." {
+		return nil
+	}
+
+	dir := ""
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		err := root.Mkdir(dir, perm)
+		switch {
+		case errors.Is(err, fs.ErrExist):
+			continue
+		case err != nil:
+			return err
+		}
+		if err := c.chown(root, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the FileInfo describing the named file.
+func (c Confined) Stat(name string) (fs.FileInfo, error) {
+	root, rel, err := c.open(name, false)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.Stat(rel)
+}
+
+// WalkDir walks the file tree rooted at dir.
+func (c Confined) WalkDir(dir string, fn fs.WalkDirFunc) error {
+	root, rel, err := c.open(dir, false)
+	if err != nil {
+		return fn(dir, nil, err)
+	}
+	defer root.Close()
+	return fs.WalkDir(root.FS(), filepath.ToSlash(rel), func(path string, d fs.DirEntry, err error) error {
+		return fn(filepath.Join(c.Dir, filepath.FromSlash(path)), d, err)
+	})
+}
+
+// Rename moves oldpath to newpath. A symbolic link is moved itself.
+func (c Confined) Rename(oldpath, newpath string) error {
+	newRel, err := c.rel(newpath)
+	if err != nil {
+		return err
+	}
+	root, oldRel, err := c.open(oldpath, false)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Rename(oldRel, newRel)
+}
+
+// rel returns name relative to Dir, or an error when it is outside Dir.
+func (c Confined) rel(name string) (string, error) {
+	rel, err := filepath.Rel(filepath.Clean(c.Dir), filepath.Clean(name))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to access %q: it is outside %q", name, c.Dir)
+	}
+	return rel, nil
+}
+
+// open opens Dir as a root and returns name relative to it. With create a
+// missing Dir is created and handed to Owner.
+func (c Confined) open(name string, create bool) (*os.Root, string, error) {
+	rel, err := c.rel(name)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := os.OpenRoot(c.Dir)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		if err = os.MkdirAll(c.Dir, 0o755); err == nil && c.Owner != nil {
+			err = os.Lchown(c.Dir, c.Owner.UID, c.Owner.GID)
+		}
+		if err == nil {
+			root, err = os.OpenRoot(c.Dir)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return root, rel, nil
+}
+
+func (c Confined) chown(root *os.Root, name string) error {
+	if c.Owner == nil {
+		return nil
+	}
+	return root.Lchown(name, c.Owner.UID, c.Owner.GID)
+}
+
+func (c Confined) linkError(name string) error {
+	return fmt.Errorf("refusing to follow the symbolic link %q below %q", name, c.Dir)
+}
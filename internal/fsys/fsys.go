@@ -0,0 +1,197 @@
+// Package fsys abstracts the filesystem operations agent-align performs so
+// callers can redirect or fake them.
+package fsys
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS is the set of filesystem operations used while planning and applying a sync.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(path string, perm fs.FileMode) error
+	Stat(name string) (fs.FileInfo, error)
+	WalkDir(root string, fn fs.WalkDirFunc) error
+	Rename(oldpath, newpath string) error
+}
+
+// OS implements FS on top of the host filesystem.
+type OS struct{}
+
+// ReadFile reads the named file.
+func (OS) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+
+// WriteFile writes data to the named file, creating it if necessary.
+func (OS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+// MkdirAll creates a directory along with any necessary parents.
+func (OS) MkdirAll(path string, perm fs.FileMode) error { return os.MkdirAll(path, perm) }
+
+// Stat returns the FileInfo describing the named file.
+func (OS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+// WalkDir walks the file tree rooted at root.
+func (OS) WalkDir(root string, fn fs.WalkDirFunc) error { return filepath.WalkDir(root, fn) }
+
+// Rename moves oldpath to newpath.
+func (OS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+// ReadIfExists reads the named file, returning nil data when it does not exist.
+func ReadIfExists(fsys FS, name string) ([]byte, error) {
+	data, err := fsys.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteFileAll writes data to name after ensuring its parent directory exists.
+func WriteFileAll(fsys FS, name string, data []byte, perm fs.FileMode) error {
+	if err := fsys.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return fsys.WriteFile(name, data, perm)
+}
+
+// HomeDir returns the home directory whose files agent-align configures:
+// $AGENT_ALIGN_HOME when it is set, otherwise the invoking user's.
+func HomeDir() (string, error) {
+	if home := strings.TrimSpace(os.Getenv("AGENT_ALIGN_HOME")); home != "" {
+		return home, nil
+	}
+	return os.UserHomeDir()
+}
+
+// Rooted redirects every path below Dir, mirroring its absolute location, so
+// /home/me/.codex/config.toml becomes Dir/home/me/.codex/config.toml. It
+// places the files of a container root filesystem, or a reviewable staging
+// tree, without touching the real destinations.
+type Rooted struct {
+	FS  FS
+	Dir string
+}
+
+// Path returns where name is placed below Dir. Symbolic links on the way
+// that exist below Dir are resolved as if Dir were the root directory: an
+// absolute target starts again at Dir and ".." stops there, so a link in a
+// root filesystem cannot lead to the files of the host.
+func (r Rooted) Path(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = filepath.Clean(name)
+	}
+	rest := strings.TrimPrefix(abs, filepath.VolumeName(abs))
+	resolved := string(filepath.Separator)
+	for links := 0; rest != ""; {
+		var part string
+		part, rest, _ = strings.Cut(rest, string(filepath.Separator))
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		target, err := os.Readlink(filepath.Join(r.Dir, next))
+		if err != nil {
+			// Not a link, or a path that does not exist (yet).
+			resolved = next
+			continue
+		}
+		if links++; links > maxLinks {
+			return "", fmt.Errorf("cannot place %q below %q: too many symbolic links", name, r.Dir)
+		}
+		if filepath.IsAbs(target) {
+			resolved = string(filepath.Separator)
+		}
+		rest = strings.TrimPrefix(target, filepath.VolumeName(target)) + string(filepath.Separator) + rest
+	}
+	return filepath.Join(r.Dir, resolved), nil
+}
+
+// maxLinks bounds the symbolic links Rooted.Path follows, so a loop ends.
+const maxLinks = 255
+
+// ReadFile reads name below Dir.
+func (r Rooted) ReadFile(name string) ([]byte, error) {
+	path, err := r.Path(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.FS.ReadFile(path)
+}
+
+// WriteFile writes name below Dir.
+func (r Rooted) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	path, err := r.Path(name)
+	if err != nil {
+		return err
+	}
+	return r.FS.WriteFile(path, data, perm)
+}
+
+// MkdirAll creates path below Dir along with its parents.
+func (r Rooted) MkdirAll(path string, perm fs.FileMode) error {
+	path, err := r.Path(path)
+	if err != nil {
+		return err
+	}
+	return r.FS.MkdirAll(path, perm)
+}
+
+// Stat returns the FileInfo of name below Dir.
+func (r Rooted) Stat(name string) (fs.FileInfo, error) {
+	path, err := r.Path(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.FS.Stat(path)
+}
+
+// WalkDir walks root below Dir. Paths passed to fn are below Dir.
+func (r Rooted) WalkDir(root string, fn fs.WalkDirFunc) error {
+	path, err := r.Path(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	return r.FS.WalkDir(path, fn)
+}
+
+// Rename moves oldpath below Dir to newpath below Dir. A symbolic link is
+// moved itself.
+func (r Rooted) Rename(oldpath, newpath string) error {
+	oldResolved, err := r.linkPath(oldpath)
+	if err != nil {
+		return err
+	}
+	newResolved, err := r.linkPath(newpath)
+	if err != nil {
+		return err
+	}
+	return r.FS.Rename(oldResolved, newResolved)
+}
+
+// linkPath is Path with only the parent directory of name resolved.
+func (r Rooted) linkPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = filepath.Clean(name)
+	}
+	dir, err := r.Path(filepath.Dir(abs))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
@@ -1,6 +1,7 @@
 package mcpconfig
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -9,61 +10,202 @@ import (
 )
 
 // Load reads the MCP server definitions from a YAML file.
-// It accepts either a top-level "servers" or "mcpServers" mapping.
-func Load(path string) (map[string]interface{}, error) {
+// It accepts either a top-level "servers" or "mcpServers" mapping and returns
+// the servers in the order they appear in the file.
+func Load(path string) (Servers, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return Parse(path, data)
+}
 
+// Parse decodes MCP server definitions from data. The path is only used in
+// error messages.
+func Parse(path string, data []byte) (Servers, error) {
 	var raw struct {
-		Servers    map[string]interface{} `yaml:"servers"`
-		MCPServers map[string]interface{} `yaml:"mcpServers"`
+		Servers    yaml.Node `yaml:"servers"`
+		MCPServers yaml.Node `yaml:"mcpServers"`
 	}
 	if err := yaml.Unmarshal(data, &raw); err != nil {
 		return nil, fmt.Errorf("failed to parse MCP config at %q: %w", path, err)
 	}
 
-	servers := raw.Servers
-	if len(servers) == 0 {
-		servers = raw.MCPServers
+	node := resolveAlias(&raw.Servers)
+	if len(node.Content) == 0 {
+		node = resolveAlias(&raw.MCPServers)
 	}
-	if len(servers) == 0 {
+	if len(node.Content) == 0 {
 		return nil, fmt.Errorf("no MCP servers found in %s", path)
 	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("failed to parse MCP config at %q: servers must be a mapping", path)
+	}
+	pairs, err := mappingPairs(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MCP config at %q: %w", path, err)
+	}
+
+	servers := make(Servers, 0, len(pairs)/2)
+	seen := make(map[string]struct{}, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name := pairs[i].Value
+		if _, dup := seen[name]; dup {
+			return nil, fmt.Errorf("server %q is defined more than once", name)
+		}
+		seen[name] = struct{}{}
 
-	for name, server := range servers {
-		if _, ok := server.(map[string]interface{}); !ok {
+		valueNode := resolveAlias(pairs[i+1])
+		if valueNode.Kind != yaml.MappingNode {
 			return nil, fmt.Errorf("server %q must be a mapping", name)
 		}
-	}
+		var fields map[string]interface{}
+		if err := valueNode.Decode(&fields); err != nil {
+			return nil, fmt.Errorf("failed to parse server %q in %s: %w", name, path, err)
+		}
+		if fields == nil {
+			fields = make(map[string]interface{})
+		}
 
-	// Expand environment variables in all string values
-	expandEnvInMap(servers)
+		templates := headerTemplates(fields)
+
+		// Expand environment variables in all string values
+		expanded := expandEnvInFields(fields)
+
+		spec, err := newServerSpec(name, fields)
+		if err != nil {
+			return nil, err
+		}
+		spec.HeaderTemplates = templates
+		spec.Expanded = expanded
+		servers = append(servers, spec)
+	}
 
 	return servers, nil
 }
 
+// mappingPairs returns the keys and values of a mapping node, alternating,
+// with YAML merge keys (<<) resolved: merged entries take the place of the
+// merge key, and keys set in the mapping itself take precedence over them.
+func mappingPairs(node *yaml.Node) ([]*yaml.Node, error) {
+	explicit := make(map[string]bool, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !isMergeKey(node.Content[i]) {
+			explicit[node.Content[i].Value] = true
+		}
+	}
+
+	var pairs []*yaml.Node
+	merged := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveAlias(node.Content[i+1])
+		if !isMergeKey(key) {
+			pairs = append(pairs, key, value)
+			continue
+		}
+		sources := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			sources = value.Content
+		}
+		for _, source := range sources {
+			source = resolveAlias(source)
+			if source.Kind != yaml.MappingNode {
+				return nil, errors.New("a merge key must refer to a mapping or a sequence of mappings")
+			}
+			inner, err := mappingPairs(source)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j+1 < len(inner); j += 2 {
+				name := inner[j].Value
+				if explicit[name] || merged[name] {
+					continue
+				}
+				merged[name] = true
+				pairs = append(pairs, inner[j], inner[j+1])
+			}
+		}
+	}
+	return pairs, nil
+}
+
+func isMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!merge"
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// headerTemplates returns the header values that reference environment
+// variables, before they are expanded.
+func headerTemplates(fields map[string]interface{}) map[string]string {
+	headers, ok := fields["headers"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var out map[string]string
+	for key, value := range headers {
+		s, ok := value.(string)
+		if !ok || !strings.Contains(s, "$") {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[key] = s
+	}
+	return out
+}
+
+// secretFields are the server fields whose expanded values are recorded in
+// ServerSpec.Expanded. Tokens are passed in the environment or in headers;
+// expanded URLs, arguments and flags are not secrets.
+var secretFields = map[string]bool{"env": true, "headers": true}
+
+// expandEnvInFields expands environment variables in every string value of a
+// server's fields and returns the non-empty values expansion produced under
+// secretFields.
+func expandEnvInFields(fields map[string]interface{}) []string {
+	var expanded []string
+	for key, value := range fields {
+		record := func(string) {}
+		if secretFields[key] {
+			record = func(value string) { expanded = append(expanded, value) }
+		}
+		fields[key] = expandEnvInValue(value, record)
+	}
+	return expanded
+}
+
 // expandEnvInMap recursively expands environment variables in all string
 // values within a map[string]interface{}. It supports ${VAR} and $VAR syntax.
-func expandEnvInMap(m map[string]interface{}) {
+// record receives every non-empty string that changed through expansion.
+func expandEnvInMap(m map[string]interface{}, record func(string)) {
 	for key, value := range m {
-		m[key] = expandEnvInValue(value)
+		m[key] = expandEnvInValue(value, record)
 	}
 }
 
 // expandEnvInValue recursively expands environment variables in a value.
 // It handles strings, maps, slices, and nested structures.
-func expandEnvInValue(value interface{}) interface{} {
+func expandEnvInValue(value interface{}, record func(string)) interface{} {
 	switch v := value.(type) {
 	case string:
-		return expandEnv(v)
+		expanded := expandEnv(v)
+		if expanded != v && expanded != "" {
+			record(expanded)
+		}
+		return expanded
 	case map[string]interface{}:
-		expandEnvInMap(v)
+		expandEnvInMap(v, record)
 		return v
 	case []interface{}:
 		for i, item := range v {
-			v[i] = expandEnvInValue(item)
+			v[i] = expandEnvInValue(item, record)
 		}
 		return v
 	default:
@@ -75,15 +217,33 @@ func expandEnvInValue(value interface{}) interface{} {
 // It supports both ${VAR} and $VAR syntax.
 func expandEnv(s string) string {
 	return os.Expand(s, func(key string) string {
-		// Support ${VAR:-default} syntax
-		if strings.Contains(key, ":-") {
-			parts := strings.SplitN(key, ":-", 2)
-			val := os.Getenv(parts[0])
-			if val == "" {
-				return parts[1]
-			}
-			return val
+		_, value := lookupEnv(key)
+		return value
+	})
+}
+
+// lookupEnv returns the variable a reference names and the value it expands
+// to. It supports the ${VAR:-default} syntax.
+func lookupEnv(key string) (string, string) {
+	if name, fallback, ok := strings.Cut(key, ":-"); ok {
+		if value := os.Getenv(name); value != "" {
+			return name, value
 		}
-		return os.Getenv(key)
+		return name, fallback
+	}
+	return key, os.Getenv(key)
+}
+
+// BraceEnv rewrites every environment variable reference in s, as written in
+// a header template, to the plain ${VAR} form and returns the values the
+// variables expand to, so a program that expands ${VAR} itself can be given
+// them in its environment.
+func BraceEnv(s string) (string, map[string]string) {
+	vars := make(map[string]string)
+	braced := os.Expand(s, func(key string) string {
+		name, value := lookupEnv(key)
+		vars[name] = value
+		return "${" + name + "}"
 	})
+	return braced, vars
 }
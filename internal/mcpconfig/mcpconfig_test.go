@@ -3,6 +3,8 @@ package mcpconfig
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -24,7 +26,7 @@ func TestLoad(t *testing.T) {
 	if len(got) != 1 {
 		t.Fatalf("expected 1 server, got %d", len(got))
 	}
-	if _, ok := got["test"]; !ok {
+	if _, ok := got.Get("test"); !ok {
 		t.Fatalf("expected test server present")
 	}
 }
@@ -51,7 +53,7 @@ func TestLoadWithEnvVarExpansion(t *testing.T) {
 	content := `servers:
   test:
     command: npx
-    args: ["@example/tool"]
+    args: ["@example/tool", "--endpoint=$TEST_URL/v1"]
     env:
       API_KEY: ${TEST_API_KEY}
       BASE_URL: $TEST_URL
@@ -66,13 +68,13 @@ func TestLoadWithEnvVarExpansion(t *testing.T) {
 		t.Fatalf("Load returned error: %v", err)
 	}
 
-	server, ok := got["test"].(map[string]interface{})
+	server, ok := got.Get("test")
 	if !ok {
-		t.Fatal("expected test server to be a map")
+		t.Fatal("expected test server to be present")
 	}
 
-	env, ok := server["env"].(map[string]interface{})
-	if !ok {
+	env := server.Env
+	if env == nil {
 		t.Fatal("expected env to be a map")
 	}
 
@@ -87,6 +89,12 @@ func TestLoadWithEnvVarExpansion(t *testing.T) {
 	if env["COMBINED"] != "prefix-secret-key-123-suffix" {
 		t.Errorf("expected COMBINED to be expanded, got %v", env["COMBINED"])
 	}
+
+	sort.Strings(server.Expanded)
+	want := []string{"https://api.example.com", "prefix-secret-key-123-suffix", "secret-key-123"}
+	if !reflect.DeepEqual(server.Expanded, want) {
+		t.Errorf("expected the expanded values to be recorded, got %v", server.Expanded)
+	}
 }
 
 func TestLoadWithEnvVarDefault(t *testing.T) {
@@ -109,13 +117,13 @@ func TestLoadWithEnvVarDefault(t *testing.T) {
 		t.Fatalf("Load returned error: %v", err)
 	}
 
-	server, ok := got["test"].(map[string]interface{})
+	server, ok := got.Get("test")
 	if !ok {
-		t.Fatal("expected test server to be a map")
+		t.Fatal("expected test server to be present")
 	}
 
-	env, ok := server["env"].(map[string]interface{})
-	if !ok {
+	env := server.Env
+	if env == nil {
 		t.Fatal("expected env to be a map")
 	}
 
@@ -145,13 +153,13 @@ func TestLoadWithEmptyEnvVarUsesDefault(t *testing.T) {
 		t.Fatalf("Load returned error: %v", err)
 	}
 
-	server, ok := got["test"].(map[string]interface{})
+	server, ok := got.Get("test")
 	if !ok {
-		t.Fatal("expected test server to be a map")
+		t.Fatal("expected test server to be present")
 	}
 
-	env, ok := server["env"].(map[string]interface{})
-	if !ok {
+	env := server.Env
+	if env == nil {
 		t.Fatal("expected env to be a map")
 	}
 
@@ -182,13 +190,13 @@ func TestLoadWithEnvVarInNestedStructures(t *testing.T) {
 		t.Fatalf("Load returned error: %v", err)
 	}
 
-	server, ok := got["test"].(map[string]interface{})
+	server, ok := got.Get("test")
 	if !ok {
-		t.Fatal("expected test server to be a map")
+		t.Fatal("expected test server to be present")
 	}
 
-	headers, ok := server["headers"].(map[string]interface{})
-	if !ok {
+	headers := server.Headers
+	if headers == nil {
 		t.Fatal("expected headers to be a map")
 	}
 
@@ -218,13 +226,13 @@ func TestLoadWithEnvVarInArrays(t *testing.T) {
 		t.Fatalf("Load returned error: %v", err)
 	}
 
-	server, ok := got["test"].(map[string]interface{})
+	server, ok := got.Get("test")
 	if !ok {
-		t.Fatal("expected test server to be a map")
+		t.Fatal("expected test server to be present")
 	}
 
-	args, ok := server["args"].([]interface{})
-	if !ok {
+	args := server.Args
+	if args == nil {
 		t.Fatal("expected args to be an array")
 	}
 
@@ -0,0 +1,483 @@
+package mcpconfig
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transport identifies how an agent talks to an MCP server.
+type Transport string
+
+const (
+	// TransportUnknown is used when no type was given or the type is not recognized.
+	TransportUnknown Transport = ""
+	// TransportStdio launches the server as a local process.
+	TransportStdio Transport = "stdio"
+	// TransportHTTP connects to a streamable HTTP endpoint.
+	TransportHTTP Transport = "http"
+	// TransportSSE connects to a server-sent events endpoint.
+	TransportSSE Transport = "sse"
+)
+
+// ParseTransport maps the transport spellings used by the supported agents to
+// their canonical transport.
+func ParseTransport(value string) Transport {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "stdio", "local":
+		return TransportStdio
+	case "http", "streamable-http", "streamablehttp":
+		return TransportHTTP
+	case "sse":
+		return TransportSSE
+	default:
+		return TransportUnknown
+	}
+}
+
+// ServerSpec is the canonical definition of a single MCP server.
+type ServerSpec struct {
+	Name string
+	// Type is the transport spelling used in the source file, if any.
+	Type      string
+	Transport Transport
+
+	Command string
+	Args    []string
+	Env     map[string]string
+
+	URL     string
+	Headers map[string]string
+	// HeaderTemplates holds the header values that referenced environment
+	// variables, as written before expansion. Agents that resolve variables
+	// themselves use it to keep secrets out of their config files.
+	HeaderTemplates map[string]string
+	// Expanded holds the values environment variables were expanded into
+	// within env and headers, where tokens are passed, so callers can tell
+	// whether a rendered file contains secrets.
+	Expanded []string
+
+	// Tags label the server for target tag filters. They are not written to
+	// any agent file.
+	Tags []string
+
+	// Disabled is set by "enabled: false" (or the legacy "disabled: true").
+	// Agents keep a disabled server listed but off where they can, and omit
+	// it otherwise.
+	Disabled bool
+
+	// Timeout is the per-request timeout in milliseconds.
+	Timeout           int
+	StartupTimeoutSec int
+	ToolTimeoutSec    int
+
+	// Extra holds keys without a canonical field. They are passed through to
+	// agents unchanged.
+	Extra map[string]interface{}
+}
+
+// Servers is an ordered list of server definitions.
+type Servers []ServerSpec
+
+// Get returns the server with the given name.
+func (s Servers) Get(name string) (ServerSpec, bool) {
+	for _, spec := range s {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return ServerSpec{}, false
+}
+
+// Names returns the server names in list order.
+func (s Servers) Names() []string {
+	names := make([]string, 0, len(s))
+	for _, spec := range s {
+		names = append(names, spec.Name)
+	}
+	return names
+}
+
+// Merge returns the servers of s followed by those of other. A server of
+// other replaces the server of s with the same name in place.
+func (s Servers) Merge(other Servers) Servers {
+	merged := append(Servers{}, s...)
+	for _, spec := range other {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == spec.Name {
+				merged[i], replaced = spec, true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, spec)
+		}
+	}
+	return merged
+}
+
+// Clone returns a deep copy of the list so callers can modify it freely.
+func (s Servers) Clone() Servers {
+	if s == nil {
+		return nil
+	}
+	out := make(Servers, len(s))
+	for i, spec := range s {
+		out[i] = spec.Clone()
+	}
+	return out
+}
+
+// Clone returns a deep copy of the spec.
+func (s ServerSpec) Clone() ServerSpec {
+	out := s
+	if s.Args != nil {
+		out.Args = append([]string{}, s.Args...)
+	}
+	if s.Tags != nil {
+		out.Tags = append([]string{}, s.Tags...)
+	}
+	if s.Expanded != nil {
+		out.Expanded = append([]string{}, s.Expanded...)
+	}
+	out.Env = cloneStringMap(s.Env)
+	out.Headers = cloneStringMap(s.Headers)
+	out.HeaderTemplates = cloneStringMap(s.HeaderTemplates)
+	if s.Extra != nil {
+		out.Extra = make(map[string]interface{}, len(s.Extra))
+		for k, v := range s.Extra {
+			out.Extra[k] = cloneValue(v)
+		}
+	}
+	return out
+}
+
+// Fields renders the spec as a generic mapping using the canonical key names.
+// A disabled server is rendered with "disabled": true. Extra keys are
+// included as-is.
+func (s ServerSpec) Fields() map[string]interface{} {
+	out := make(map[string]interface{}, len(s.Extra)+8)
+	for k, v := range s.Extra {
+		out[k] = cloneValue(v)
+	}
+	if s.Type != "" {
+		out["type"] = s.Type
+	}
+	if s.Command != "" {
+		out["command"] = s.Command
+	}
+	if s.Args != nil {
+		out["args"] = append([]string{}, s.Args...)
+	}
+	if s.Env != nil {
+		out["env"] = cloneStringMap(s.Env)
+	}
+	if s.URL != "" {
+		out["url"] = s.URL
+	}
+	if s.Headers != nil {
+		out["headers"] = cloneStringMap(s.Headers)
+	}
+	if s.Disabled {
+		out["disabled"] = true
+	}
+	if s.Timeout > 0 {
+		out["timeout"] = s.Timeout
+	}
+	if s.StartupTimeoutSec > 0 {
+		out["startup_timeout_sec"] = s.StartupTimeoutSec
+	}
+	if s.ToolTimeoutSec > 0 {
+		out["tool_timeout_sec"] = s.ToolTimeoutSec
+	}
+	return out
+}
+
+// Resolve returns a copy of the spec with its transport inferred and checks
+// that the fields the transport needs are present. When no type is given, a
+// command means stdio and a url means streamable HTTP. The original Type
+// spelling is kept; agents write their own spelling of the transport.
+func (s ServerSpec) Resolve() (ServerSpec, error) {
+	out := s.Clone()
+	if strings.TrimSpace(out.Type) != "" {
+		out.Transport = ParseTransport(out.Type)
+		if out.Transport == TransportUnknown {
+			return ServerSpec{}, fmt.Errorf("server %q has an unsupported type %q (expected stdio, http or sse)", s.Name, s.Type)
+		}
+	} else {
+		switch {
+		case out.Command != "" && out.URL != "":
+			return ServerSpec{}, fmt.Errorf("server %q sets both command and url; add a type to choose the transport", s.Name)
+		case out.Command != "":
+			out.Transport = TransportStdio
+		case out.URL != "":
+			out.Transport = TransportHTTP
+		default:
+			return ServerSpec{}, fmt.Errorf("server %q needs a command or a url", s.Name)
+		}
+	}
+
+	switch out.Transport {
+	case TransportStdio:
+		if out.Command == "" {
+			return ServerSpec{}, fmt.Errorf("server %q uses the stdio transport but has no command", s.Name)
+		}
+	default:
+		if out.URL == "" {
+			return ServerSpec{}, fmt.Errorf("server %q uses the %s transport but has no url", s.Name, out.Transport)
+		}
+	}
+	return out, nil
+}
+
+// HasTag reports whether the server carries one of tags, ignoring case.
+func (s ServerSpec) HasTag(tags ...string) bool {
+	for _, tag := range s.Tags {
+		for _, want := range tags {
+			if strings.EqualFold(strings.TrimSpace(tag), strings.TrimSpace(want)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Override returns a copy of the spec with fields replaced, using the same
+// keys as the MCP definitions file. A nil value removes the field. String
+// values have their environment variables expanded like the definitions
+// file.
+func (s ServerSpec) Override(fields map[string]interface{}) (ServerSpec, error) {
+	merged := s.Fields()
+	if s.Tags != nil {
+		merged["tags"] = append([]string{}, s.Tags...)
+	}
+	_, setsEnabled := fields["enabled"]
+	_, setsDisabled := fields["disabled"]
+	if setsEnabled || setsDisabled {
+		delete(merged, "disabled")
+	}
+
+	overrides := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		overrides[k] = cloneValue(v)
+	}
+	templates := cloneStringMap(s.HeaderTemplates)
+	if _, ok := overrides["headers"]; ok {
+		templates = headerTemplates(overrides)
+	}
+	expanded := append(append([]string{}, s.Expanded...), expandEnvInFields(overrides)...)
+	for k, v := range overrides {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+
+	out, err := newServerSpec(s.Name, merged)
+	if err != nil {
+		return ServerSpec{}, err
+	}
+	out.HeaderTemplates = templates
+	if len(expanded) > 0 {
+		out.Expanded = expanded
+	}
+	return out, nil
+}
+
+// SortedKeys returns the keys of a string map in lexical order.
+func SortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newServerSpec converts a decoded YAML mapping into a ServerSpec. Known keys
+// are validated and moved into typed fields; everything else lands in Extra.
+func newServerSpec(name string, raw map[string]interface{}) (ServerSpec, error) {
+	spec := ServerSpec{Name: name}
+	var err error
+	var enabled, disabled *bool
+	for key, value := range raw {
+		switch key {
+		case "enabled":
+			enabled, err = boolean(value)
+		case "disabled":
+			disabled, err = boolean(value)
+		case "type":
+			spec.Type, err = scalarString(value)
+			spec.Transport = ParseTransport(spec.Type)
+		case "command":
+			spec.Command, err = scalarString(value)
+		case "args":
+			spec.Args, err = stringList(value)
+		case "tags":
+			spec.Tags, err = stringList(value)
+		case "env":
+			spec.Env, err = stringMap(value)
+		case "url":
+			spec.URL, err = scalarString(value)
+		case "headers":
+			spec.Headers, err = stringMap(value)
+		case "timeout":
+			spec.Timeout, err = integer(value)
+		case "startup_timeout_sec", "startupTimeoutSec":
+			spec.StartupTimeoutSec, err = integer(value)
+		case "tool_timeout_sec", "toolTimeoutSec":
+			spec.ToolTimeoutSec, err = integer(value)
+		default:
+			if spec.Extra == nil {
+				spec.Extra = make(map[string]interface{})
+			}
+			spec.Extra[key] = value
+		}
+		if err != nil {
+			return ServerSpec{}, fmt.Errorf("server %q has an invalid %q field: %w", name, key, err)
+		}
+	}
+	switch {
+	case enabled != nil && disabled != nil && *enabled == *disabled:
+		return ServerSpec{}, fmt.Errorf("server %q sets conflicting enabled and disabled fields", name)
+	case enabled != nil:
+		spec.Disabled = !*enabled
+	case disabled != nil:
+		spec.Disabled = *disabled
+	}
+	return spec, nil
+}
+
+func scalarString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("expected a string, got %T", value)
+	}
+}
+
+func stringList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []string:
+		return append([]string{}, v...), nil
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalarString(item)
+			if err != nil {
+				return nil, fmt.Errorf("expected a list of strings: %w", err)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("expected a list of strings, got %T", value)
+	}
+}
+
+func stringMap(value interface{}) (map[string]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]string:
+		return cloneStringMap(v), nil
+	case map[string]interface{}:
+		out := make(map[string]string, len(v))
+		for k, item := range v {
+			s, err := scalarString(item)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k, err)
+			}
+			out[k] = s
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("expected a mapping of strings, got %T", value)
+	}
+}
+
+func boolean(value interface{}) (*bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", v)
+		}
+		return &b, nil
+	default:
+		return nil, fmt.Errorf("expected true or false, got %T", value)
+	}
+}
+
+func integer(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("expected a whole number, got %v", v)
+		}
+		return int(v), nil
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("expected a number, got %q", v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+}
+
+func cloneStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = cloneValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = cloneValue(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
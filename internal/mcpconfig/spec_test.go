@@ -0,0 +1,333 @@
+package mcpconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeMCPFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mcp.yml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	return path
+}
+
+func TestLoadBuildsTypedSpecs(t *testing.T) {
+	path := writeMCPFile(t, `mcpServers:
+  zeta:
+    type: streamable-http
+    url: https://example.test/mcp
+    headers:
+      Authorization: Bearer token
+    timeout: 1000000
+    startupTimeoutSec: 20
+    tools: ["*"]
+  alpha:
+    command: npx
+    args: ["tool", 8080]
+    env:
+      PORT: 8080
+    tool_timeout_sec: "45"
+`)
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	if !reflect.DeepEqual(got.Names(), []string{"zeta", "alpha"}) {
+		t.Fatalf("expected servers in file order, got %v", got.Names())
+	}
+
+	zeta := got[0]
+	if zeta.Transport != TransportHTTP || zeta.Type != "streamable-http" {
+		t.Fatalf("unexpected transport: %q (%q)", zeta.Transport, zeta.Type)
+	}
+	if zeta.URL != "https://example.test/mcp" || zeta.Headers["Authorization"] != "Bearer token" {
+		t.Fatalf("unexpected network fields: %#v", zeta)
+	}
+	if zeta.Timeout != 1000000 || zeta.StartupTimeoutSec != 20 {
+		t.Fatalf("unexpected timeouts: %#v", zeta)
+	}
+	if !reflect.DeepEqual(zeta.Extra, map[string]interface{}{"tools": []interface{}{"*"}}) {
+		t.Fatalf("unexpected extra fields: %#v", zeta.Extra)
+	}
+
+	alpha := got[1]
+	if !reflect.DeepEqual(alpha.Args, []string{"tool", "8080"}) {
+		t.Fatalf("unexpected args: %#v", alpha.Args)
+	}
+	if alpha.Env["PORT"] != "8080" {
+		t.Fatalf("expected env values to be strings, got %#v", alpha.Env)
+	}
+	if alpha.ToolTimeoutSec != 45 {
+		t.Fatalf("expected tool timeout from string, got %d", alpha.ToolTimeoutSec)
+	}
+}
+
+func TestLoadRejectsInvalidFieldTypes(t *testing.T) {
+	path := writeMCPFile(t, `servers:
+  broken:
+    command: npx
+    env: [one, two]
+`)
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected error for list env")
+	}
+	if !strings.Contains(err.Error(), `"broken"`) || !strings.Contains(err.Error(), `"env"`) {
+		t.Fatalf("expected error to name server and field, got %v", err)
+	}
+}
+
+func TestLoadRejectsNonMappingServer(t *testing.T) {
+	path := writeMCPFile(t, `servers:
+  broken: npx
+`)
+
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "must be a mapping") {
+		t.Fatalf("expected mapping error, got %v", err)
+	}
+}
+
+func TestLoadResolvesMergeKeys(t *testing.T) {
+	path := writeMCPFile(t, `base: &base
+  shared:
+    command: npx
+  local:
+    command: base
+servers:
+  <<: *base
+  local:
+    command: uvx
+    <<: {env: {MODE: dev}}
+`)
+
+	servers, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if want := []string{"shared", "local"}; !reflect.DeepEqual(servers.Names(), want) {
+		t.Fatalf("servers = %v, want %v", servers.Names(), want)
+	}
+	local, _ := servers.Get("local")
+	if local.Command != "uvx" || local.Env["MODE"] != "dev" {
+		t.Fatalf("unexpected local server: %+v", local)
+	}
+}
+
+func TestParseTransport(t *testing.T) {
+	cases := map[string]Transport{
+		"stdio":           TransportStdio,
+		"local":           TransportStdio,
+		"http":            TransportHTTP,
+		"streamable-http": TransportHTTP,
+		"streamableHttp":  TransportHTTP,
+		" SSE ":           TransportSSE,
+		"websocket":       TransportUnknown,
+		"":                TransportUnknown,
+	}
+	for input, want := range cases {
+		if got := ParseTransport(input); got != want {
+			t.Errorf("ParseTransport(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestServerSpecFieldsRoundTrip(t *testing.T) {
+	spec := ServerSpec{
+		Name:    "srv",
+		Type:    "stdio",
+		Command: "npx",
+		Args:    []string{},
+		Env:     map[string]string{"A": "1"},
+		Timeout: 30,
+		Extra:   map[string]interface{}{"nested": map[string]interface{}{"k": "v"}},
+	}
+
+	fields := spec.Fields()
+	want := map[string]interface{}{
+		"type":    "stdio",
+		"command": "npx",
+		"args":    []string{},
+		"env":     map[string]string{"A": "1"},
+		"timeout": 30,
+		"nested":  map[string]interface{}{"k": "v"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("Fields() = %#v, want %#v", fields, want)
+	}
+
+	fields["nested"].(map[string]interface{})["k"] = "changed"
+	fields["env"].(map[string]string)["A"] = "changed"
+	if spec.Extra["nested"].(map[string]interface{})["k"] != "v" || spec.Env["A"] != "1" {
+		t.Fatal("Fields() should not share state with the spec")
+	}
+}
+
+func TestServersClone(t *testing.T) {
+	servers := Servers{{Name: "a", Headers: map[string]string{"X": "1"}, Args: []string{"x"}}}
+	clone := servers.Clone()
+	clone[0].Headers["X"] = "2"
+	clone[0].Args[0] = "y"
+	if servers[0].Headers["X"] != "1" || servers[0].Args[0] != "x" {
+		t.Fatal("Clone should deep copy maps and slices")
+	}
+}
+
+func TestLoadKeepsHeaderTemplates(t *testing.T) {
+	t.Setenv("MCP_TEST_TOKEN", "secret")
+	path := writeMCPFile(t, `servers:
+  remote:
+    url: https://example.test
+    headers:
+      Authorization: Bearer ${MCP_TEST_TOKEN}
+      X-Team: core
+`)
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	remote := got[0]
+	if remote.Headers["Authorization"] != "Bearer secret" {
+		t.Fatalf("expected expanded header, got %q", remote.Headers["Authorization"])
+	}
+	want := map[string]string{"Authorization": "Bearer ${MCP_TEST_TOKEN}"}
+	if !reflect.DeepEqual(remote.HeaderTemplates, want) {
+		t.Fatalf("HeaderTemplates = %v, want %v", remote.HeaderTemplates, want)
+	}
+}
+
+func TestServerSpecResolve(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    ServerSpec
+		want    Transport
+		wantErr string
+	}{
+		{"command infers stdio", ServerSpec{Name: "a", Command: "npx"}, TransportStdio, ""},
+		{"url infers http", ServerSpec{Name: "a", URL: "https://example.test"}, TransportHTTP, ""},
+		{"local alias", ServerSpec{Name: "a", Type: "local", Command: "npx"}, TransportStdio, ""},
+		{"streamableHttp alias", ServerSpec{Name: "a", Type: "streamableHttp", URL: "https://example.test"}, TransportHTTP, ""},
+		{"sse", ServerSpec{Name: "a", Type: "sse", URL: "https://example.test"}, TransportSSE, ""},
+		{"unknown type", ServerSpec{Name: "a", Type: "websocket", URL: "wss://example.test"}, "", "unsupported type"},
+		{"http without url", ServerSpec{Name: "a", Type: "http"}, "", "has no url"},
+		{"stdio without command", ServerSpec{Name: "a", Type: "stdio"}, "", "has no command"},
+		{"ambiguous", ServerSpec{Name: "a", Command: "npx", URL: "https://example.test"}, "", "both command and url"},
+		{"empty", ServerSpec{Name: "a"}, "", "needs a command or a url"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.spec.Resolve()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Transport != tc.want || got.Type != tc.spec.Type {
+				t.Fatalf("Resolve() = %q (type %q), want %q (type %q)", got.Transport, got.Type, tc.want, tc.spec.Type)
+			}
+		})
+	}
+}
+
+func TestLoadParsesEnabledFlag(t *testing.T) {
+	path := writeMCPFile(t, `servers:
+  off:
+    command: npx
+    enabled: false
+  legacy:
+    command: npx
+    disabled: true
+  on:
+    command: npx
+    enabled: "true"
+  default:
+    command: npx
+`)
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	want := map[string]bool{"off": true, "legacy": true, "on": false, "default": false}
+	for _, spec := range got {
+		if spec.Disabled != want[spec.Name] {
+			t.Errorf("%s: Disabled = %v, want %v", spec.Name, spec.Disabled, want[spec.Name])
+		}
+		if _, ok := spec.Extra["enabled"]; ok {
+			t.Errorf("%s: enabled should not be kept in Extra", spec.Name)
+		}
+		if _, ok := spec.Extra["disabled"]; ok {
+			t.Errorf("%s: disabled should not be kept in Extra", spec.Name)
+		}
+	}
+	if fields := got[0].Fields(); fields["disabled"] != true {
+		t.Fatalf("disabled server should render disabled: true, got %v", fields)
+	}
+}
+
+func TestLoadRejectsConflictingEnabledFlags(t *testing.T) {
+	path := writeMCPFile(t, `servers:
+  srv:
+    command: npx
+    enabled: false
+    disabled: false
+`)
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "conflicting enabled and disabled") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	path = writeMCPFile(t, `servers:
+  srv:
+    command: npx
+    enabled: maybe
+`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), `invalid "enabled" field`) {
+		t.Fatalf("expected invalid enabled error, got %v", err)
+	}
+}
+
+func TestServerSpecOverride(t *testing.T) {
+	t.Setenv("OVERRIDE_TOKEN", "secret")
+	spec := ServerSpec{
+		Name:            "api",
+		URL:             "https://api.test",
+		Headers:         map[string]string{"Authorization": "Bearer old"},
+		HeaderTemplates: map[string]string{"Authorization": "Bearer ${OLD}"},
+		Tags:            []string{"work"},
+		Disabled:        true,
+		Timeout:         5000,
+	}
+
+	got, err := spec.Override(map[string]interface{}{
+		"enabled": true,
+		"url":     "https://override.test",
+		"headers": map[string]interface{}{"Authorization": "Bearer ${OVERRIDE_TOKEN}"},
+		"timeout": nil,
+	})
+	if err != nil {
+		t.Fatalf("Override returned error: %v", err)
+	}
+	if got.Disabled || got.URL != "https://override.test" || got.Timeout != 0 {
+		t.Fatalf("unexpected overridden spec: %#v", got)
+	}
+	if got.Headers["Authorization"] != "Bearer secret" || got.HeaderTemplates["Authorization"] != "Bearer ${OVERRIDE_TOKEN}" {
+		t.Fatalf("unexpected headers: %v / %v", got.Headers, got.HeaderTemplates)
+	}
+	if !got.HasTag("WORK") || spec.URL != "https://api.test" {
+		t.Fatalf("tags should be kept and the original left untouched: %#v", got)
+	}
+}
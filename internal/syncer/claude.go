@@ -0,0 +1,250 @@
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"agent-align/internal/fsys"
+	"agent-align/internal/mcpconfig"
+	"agent-align/internal/transforms"
+)
+
+// ClaudeProject selects the servers written to one project of Claude Code's
+// ~/.claude.json. The target's tag filters and DisabledMcpServers do not
+// apply; its overrides and transport settings do.
+type ClaudeProject struct {
+	// Servers keeps only the named servers. An empty list keeps every server.
+	Servers []string
+	// Tags keeps only the servers that carry at least one of the tags.
+	Tags []string
+	// DisabledMcpServers lists servers that should be omitted.
+	DisabledMcpServers []string
+}
+
+// claudeSettingsPath returns the Claude Code settings file that belongs to the
+// given ~/.claude.json path. For the default location this is
+// ~/.claude/settings.json.
+func claudeSettingsPath(claudeJSONPath string) string {
+	return filepath.Join(filepath.Dir(claudeJSONPath), ".claude", "settings.json")
+}
+
+// renderClaudePermissions renders the permissions.allow rules for the servers'
+// approved tools into Claude's settings file. Rules belonging to servers that
+// are managed here, or that were removed from ~/.claude.json, are replaced;
+// every other rule is kept. For a project's .mcp.json, disabled servers are
+// listed in disabledMcpjsonServers the same way. The second return value is
+// false when the file does not need to change. An unparsable settings file follows the same
+// policy as the agent files (see ParseError).
+func (s *Syncer) renderClaudePermissions(cfg AgentConfig, existingClaude []byte, servers []transforms.Server) (AgentResult, bool) {
+	settingsCfg := AgentConfig{
+		Name:     cfg.Name,
+		FilePath: claudeSettingsPath(cfg.FilePath),
+		NodeName: "permissions",
+		Format:   "json",
+		Scope:    cfg.Scope,
+	}
+	result := AgentResult{Config: settingsCfg}
+
+	existing, err := fsys.ReadIfExists(s.fs(), settingsCfg.FilePath)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read existing config for %s at %q: %w", cfg.Name, settingsCfg.FilePath, err)
+		return result, true
+	}
+	doc := emptyJSONDocument()
+	settings := make(map[string]interface{})
+	if len(strings.TrimSpace(string(existing))) > 0 {
+		parsed, parseErr := newJSONDocument(existing, false)
+		if parseErr != nil {
+			_, result.Quarantine, err = s.checkParse(settingsCfg, existing, &ParseError{Path: settingsCfg.FilePath, Err: parseErr})
+			if err != nil {
+				result.Err = err
+				return result, true
+			}
+		} else {
+			doc = parsed
+			_ = doc.Decode(&settings)
+		}
+	}
+
+	managed := make(map[string]struct{}, len(servers))
+	for _, server := range servers {
+		managed[server.Name] = struct{}{}
+		result.Servers = append(result.Servers, server.Name)
+	}
+	for _, name := range claudeServerNames(existingClaude) {
+		managed[name] = struct{}{}
+	}
+
+	permissions, _ := settings["permissions"].(map[string]interface{})
+	var current []interface{}
+	if permissions != nil {
+		current, _ = permissions["allow"].([]interface{})
+	}
+
+	var allow []interface{}
+	for _, rule := range current {
+		if name, ok := rule.(string); ok && isManagedMCPRule(name, managed) {
+			continue
+		}
+		allow = append(allow, rule)
+	}
+	for _, rule := range claudeAllowRules(servers) {
+		allow = append(allow, rule)
+	}
+
+	currentDisabled, _ := settings["disabledMcpjsonServers"].([]interface{})
+	disabled := currentDisabled
+	if cfg.Scope == ScopeProject {
+		disabled = nil
+		for _, item := range currentDisabled {
+			if name, ok := item.(string); ok {
+				if _, isManaged := managed[name]; isManaged {
+					continue
+				}
+			}
+			disabled = append(disabled, item)
+		}
+		for _, server := range servers {
+			if server.Disabled {
+				disabled = append(disabled, server.Name)
+			}
+		}
+	}
+
+	if !result.Quarantine && sameRules(current, allow) && sameRules(currentDisabled, disabled) {
+		return AgentResult{}, false
+	}
+
+	if len(allow) == 0 {
+		doc.Delete("permissions", "allow")
+	} else {
+		doc.Set(allow, "permissions", "allow")
+	}
+	if len(disabled) == 0 {
+		doc.Delete("disabledMcpjsonServers")
+	} else {
+		doc.Set(disabled, "disabledMcpjsonServers")
+	}
+	result.Content = string(doc.Bytes())
+	return result, true
+}
+
+// claudeAllowRules returns the permission rules for the approved tools of
+// every server, in server order.
+func claudeAllowRules(servers []transforms.Server) []string {
+	var rules []string
+	for _, server := range servers {
+		for _, tool := range server.AutoApprove {
+			if tool == "*" {
+				rules = append(rules, "mcp__"+server.Name)
+				continue
+			}
+			rules = append(rules, "mcp__"+server.Name+"__"+tool)
+		}
+	}
+	return rules
+}
+
+// isManagedMCPRule reports whether rule grants access to a managed server.
+func isManagedMCPRule(rule string, managed map[string]struct{}) bool {
+	if !strings.HasPrefix(rule, "mcp__") {
+		return false
+	}
+	rest := strings.TrimPrefix(rule, "mcp__")
+	if _, ok := managed[rest]; ok {
+		return true
+	}
+	for name := range managed {
+		if strings.HasPrefix(rest, name+"__") {
+			return true
+		}
+	}
+	return false
+}
+
+// claudeServerNames lists the servers currently configured in ~/.claude.json.
+func claudeServerNames(data []byte) []string {
+	var doc struct {
+		MCPServers map[string]json.RawMessage `json:"mcpServers"`
+	}
+	if len(data) == 0 || json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+	names := make([]string, 0, len(doc.MCPServers))
+	for name := range doc.MCPServers {
+		names = append(names, name)
+	}
+	return names
+}
+
+func sameRules(a []interface{}, b []interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// renderClaudeProjects writes the servers of every project of agent into
+// projects["<path>"].mcpServers of content, the rendered ~/.claude.json.
+// Everything else Claude keeps per project, such as allowed tools and trust
+// settings, is left untouched.
+func (s *Syncer) renderClaudeProjects(cfg AgentConfig, agent AgentTarget, servers mcpconfig.Servers, content string) (string, error) {
+	if cfg.Name != "claudecode" || cfg.Scope == ScopeProject {
+		return "", errors.New("projects are only supported for user-level claudecode targets")
+	}
+	doc, err := newJSONDocument([]byte(content), cfg.JSONC)
+	if err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(agent.Projects))
+	for path := range agent.Projects {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		project := agent.Projects[path]
+		selected := servers
+		if len(project.Servers) > 0 {
+			wanted := make(map[string]bool, len(project.Servers))
+			for _, name := range project.Servers {
+				if _, ok := servers.Get(name); !ok {
+					s.logger().Printf("warning: ignoring unknown server %q for project %s in %s", name, path, targetLabel(cfg))
+				}
+				wanted[name] = true
+			}
+			selected = make(mcpconfig.Servers, 0, len(project.Servers))
+			for _, spec := range servers {
+				if wanted[spec.Name] {
+					selected = append(selected, spec)
+				}
+			}
+		}
+
+		target := agent
+		target.Tags, target.ExcludeTags, target.DisabledMcpServers = project.Tags, nil, project.DisabledMcpServers
+		// The user-level render already warned about overrides of unknown
+		// servers; keep only those of the project's servers.
+		target.Overrides = nil
+		for _, spec := range selected {
+			if override, ok := agent.Overrides[spec.Name]; ok {
+				if target.Overrides == nil {
+					target.Overrides = make(map[string]map[string]interface{})
+				}
+				target.Overrides[spec.Name] = override
+			}
+		}
+		rendered, err := s.render(cfg, target, selected)
+		if err != nil {
+			return "", fmt.Errorf("project %s: %w", path, err)
+		}
+		current, _ := doc.Get("projects", path, "mcpServers")
+		doc.Set(serversObject(rendered.Servers, current), "projects", path, "mcpServers")
+	}
+	return string(doc.Bytes()), nil
+}
@@ -0,0 +1,251 @@
+package syncer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"agent-align/internal/mcpconfig"
+)
+
+func TestSyncClaudePermissions(t *testing.T) {
+	dir := t.TempDir()
+	claudePath := filepath.Join(dir, ".claude.json")
+	settingsPath := filepath.Join(dir, ".claude", "settings.json")
+
+	if err := os.WriteFile(claudePath, []byte(`{"mcpServers": {"search": {}, "retired": {}}}`), 0o644); err != nil {
+		t.Fatalf("failed to write claude config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
+		t.Fatalf("failed to create settings dir: %v", err)
+	}
+	existingSettings := `{
+  "model": "opus",
+  "permissions": {
+    "allow": ["Bash(ls:*)", "mcp__search__old", "mcp__retired", "mcp__manual__tool"],
+    "deny": ["Read(.env)"]
+  }
+}`
+	if err := os.WriteFile(settingsPath, []byte(existingSettings), 0o644); err != nil {
+		t.Fatalf("failed to write settings: %v", err)
+	}
+
+	servers := mcpconfig.Servers{
+		{Name: "search", Command: "npx", Extra: map[string]interface{}{"alwaysAllow": []interface{}{"query", "fetch"}}},
+		{Name: "docs", Command: "uvx", Extra: map[string]interface{}{"autoApprove": true}},
+	}
+	result, err := New([]AgentTarget{{Name: "claudecode", PathOverride: claudePath}}).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+
+	outputs := result.Agents["claudecode"]
+	if len(outputs) != 2 {
+		t.Fatalf("expected claude config and settings outputs, got %d", len(outputs))
+	}
+	if outputs[0].Config.FilePath != claudePath || outputs[1].Config.FilePath != settingsPath {
+		t.Fatalf("unexpected output paths: %s, %s", outputs[0].Config.FilePath, outputs[1].Config.FilePath)
+	}
+
+	var claude map[string]interface{}
+	if err := json.Unmarshal([]byte(outputs[0].Content), &claude); err != nil {
+		t.Fatalf("claude output not valid JSON: %v", err)
+	}
+	search := claude["mcpServers"].(map[string]interface{})["search"].(map[string]interface{})
+	if _, ok := search["alwaysAllow"]; ok {
+		t.Fatalf("approvals should not be written to ~/.claude.json: %v", search)
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal([]byte(outputs[1].Content), &settings); err != nil {
+		t.Fatalf("settings output not valid JSON: %v", err)
+	}
+	if settings["model"] != "opus" {
+		t.Fatalf("unrelated settings should be kept: %v", settings)
+	}
+	permissions := settings["permissions"].(map[string]interface{})
+	want := []interface{}{"Bash(ls:*)", "mcp__manual__tool", "mcp__search__query", "mcp__search__fetch", "mcp__docs"}
+	if !reflect.DeepEqual(permissions["allow"], want) {
+		t.Fatalf("allow = %v, want %v", permissions["allow"], want)
+	}
+	if !reflect.DeepEqual(permissions["deny"], []interface{}{"Read(.env)"}) {
+		t.Fatalf("deny rules should be untouched: %v", permissions["deny"])
+	}
+}
+
+func TestSyncClaudePermissionsSkipsUnchangedSettings(t *testing.T) {
+	dir := t.TempDir()
+	claudePath := filepath.Join(dir, ".claude.json")
+
+	servers := mcpconfig.Servers{{Name: "plain", Command: "npx"}}
+	result, err := New([]AgentTarget{{Name: "claudecode", PathOverride: claudePath}}).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	if got := len(result.Agents["claudecode"]); got != 1 {
+		t.Fatalf("settings should not be written without approvals, got %d outputs", got)
+	}
+}
+
+func TestSyncClaudePermissionsRejectsInvalidSettings(t *testing.T) {
+	dir := t.TempDir()
+	claudePath := filepath.Join(dir, ".claude.json")
+	settingsPath := filepath.Join(dir, ".claude", "settings.json")
+	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
+		t.Fatalf("failed to create settings dir: %v", err)
+	}
+	if err := os.WriteFile(settingsPath, []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("failed to write settings: %v", err)
+	}
+
+	servers := mcpconfig.Servers{{Name: "docs", Command: "uvx", Extra: map[string]interface{}{"autoApprove": true}}}
+	result, err := New([]AgentTarget{{Name: "claudecode", PathOverride: claudePath}}).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	outputs := result.Agents["claudecode"]
+	if len(outputs) != 2 || outputs[0].Err != nil || outputs[1].Err == nil {
+		t.Fatalf("an unreadable settings file should only fail the settings output: %#v", outputs)
+	}
+}
+
+func TestSyncClaudeProjects(t *testing.T) {
+	dir := t.TempDir()
+	claudePath := filepath.Join(dir, ".claude.json")
+	existing := `{
+  "numStartups": 3,
+  "projects": {
+    "/work/app": {
+      "allowedTools": ["Bash(ls:*)"],
+      "hasTrustDialogAccepted": true,
+      "mcpServers": {"stale": {"command": "old"}}
+    },
+    "/work/other": {"allowedTools": []}
+  }
+}
+`
+	if err := os.WriteFile(claudePath, []byte(existing), 0o644); err != nil {
+		t.Fatalf("failed to write claude config: %v", err)
+	}
+
+	servers := mcpconfig.Servers{
+		{Name: "search", Command: "npx", Tags: []string{"web"}},
+		{Name: "db", Command: "uvx", Tags: []string{"data"}},
+		{Name: "docs", Command: "docs"},
+	}
+	target := AgentTarget{
+		Name:               "claudecode",
+		PathOverride:       claudePath,
+		DisabledMcpServers: []string{"docs"},
+		Overrides:          map[string]map[string]interface{}{"search": {"args": []interface{}{"--fast"}}},
+		Projects: map[string]ClaudeProject{
+			"/work/app": {Servers: []string{"db", "docs"}},
+			"/work/new": {Tags: []string{"web"}},
+		},
+	}
+	logger := &recordingLogger{}
+	s := New([]AgentTarget{target})
+	s.Logger = logger
+	result, err := s.Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	output := result.Agents["claudecode"][0]
+	if output.Err != nil {
+		t.Fatalf("unexpected error: %v", output.Err)
+	}
+	if len(logger.lines) != 0 {
+		t.Errorf("expected no warnings, got %v", logger.lines)
+	}
+
+	var claude struct {
+		NumStartups int                               `json:"numStartups"`
+		MCPServers  map[string]interface{}            `json:"mcpServers"`
+		Projects    map[string]map[string]interface{} `json:"projects"`
+	}
+	if err := json.Unmarshal([]byte(output.Content), &claude); err != nil {
+		t.Fatalf("claude output not valid JSON: %v", err)
+	}
+	if claude.NumStartups != 3 || len(claude.MCPServers) != 2 {
+		t.Fatalf("unexpected top level: %s", output.Content)
+	}
+	names := func(project string) []string {
+		var out []string
+		for name := range claude.Projects[project]["mcpServers"].(map[string]interface{}) {
+			out = append(out, name)
+		}
+		sort.Strings(out)
+		return out
+	}
+	if got := names("/work/app"); !reflect.DeepEqual(got, []string{"db", "docs"}) {
+		t.Errorf("/work/app servers = %v", got)
+	}
+	if got := names("/work/new"); !reflect.DeepEqual(got, []string{"search"}) {
+		t.Errorf("/work/new servers = %v", got)
+	}
+	search := claude.Projects["/work/new"]["mcpServers"].(map[string]interface{})["search"].(map[string]interface{})
+	if !reflect.DeepEqual(search["args"], []interface{}{"--fast"}) {
+		t.Errorf("expected the override to apply in /work/new: %v", search)
+	}
+	app := claude.Projects["/work/app"]
+	if app["hasTrustDialogAccepted"] != true || len(app["allowedTools"].([]interface{})) != 1 {
+		t.Errorf("per-project state was not kept: %v", app)
+	}
+	if _, ok := claude.Projects["/work/other"]["mcpServers"]; ok {
+		t.Errorf("unlisted project was changed: %v", claude.Projects["/work/other"])
+	}
+
+	gemini := AgentTarget{Name: "gemini", PathOverride: filepath.Join(dir, "settings.json"), Projects: target.Projects}
+	result, _ = New([]AgentTarget{gemini}).Sync(servers)
+	if result.Agents["gemini"][0].Err == nil {
+		t.Fatal("expected projects to be rejected for gemini")
+	}
+}
+
+func TestSyncDisablesClaudeProjectServers(t *testing.T) {
+	project := t.TempDir()
+	settingsPath := filepath.Join(project, ".claude", "settings.json")
+	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
+		t.Fatalf("failed to create settings dir: %v", err)
+	}
+	if err := os.WriteFile(settingsPath, []byte(`{"disabledMcpjsonServers": ["manual", "on"]}`), 0o644); err != nil {
+		t.Fatalf("failed to write settings: %v", err)
+	}
+
+	servers := mcpconfig.Servers{
+		{Name: "on", Command: "npx"},
+		{Name: "off", Command: "npx", Disabled: true},
+	}
+	s := New([]AgentTarget{{Name: "claudecode", Scope: ScopeProject}})
+	s.ProjectDir = project
+	result, err := s.Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+
+	outputs := result.Agents["claudecode"]
+	if len(outputs) != 2 {
+		t.Fatalf("expected .mcp.json and settings outputs, got %d", len(outputs))
+	}
+	var mcp struct {
+		MCPServers map[string]interface{} `json:"mcpServers"`
+	}
+	if err := json.Unmarshal([]byte(outputs[0].Content), &mcp); err != nil {
+		t.Fatalf(".mcp.json output not valid JSON: %v", err)
+	}
+	if _, ok := mcp.MCPServers["off"]; !ok || len(mcp.MCPServers) != 2 {
+		t.Fatalf("the disabled server should stay listed: %s", outputs[0].Content)
+	}
+	var settings struct {
+		Disabled []string `json:"disabledMcpjsonServers"`
+	}
+	if err := json.Unmarshal([]byte(outputs[1].Content), &settings); err != nil {
+		t.Fatalf("settings output not valid JSON: %v", err)
+	}
+	if want := []string{"manual", "off"}; !reflect.DeepEqual(settings.Disabled, want) {
+		t.Fatalf("disabledMcpjsonServers = %v, want %v", settings.Disabled, want)
+	}
+}
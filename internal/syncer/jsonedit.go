@@ -0,0 +1,731 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"agent-align/internal/transforms"
+)
+
+// jsonDocument edits an existing JSON object in place. Only the members that
+// are set or deleted are rewritten; every other byte of the file, including
+// key order, indentation, comments and the trailing newline, is kept.
+type jsonDocument struct {
+	data []byte
+	// clean is data with JSONC comments and trailing commas blanked out.
+	// It has the same length and offsets as data and is what gets scanned.
+	clean []byte
+	jsonc bool
+	// indent is one level of indentation as used by the file.
+	indent string
+}
+
+// jsonMember is a key/value pair of an object with its byte offsets.
+type jsonMember struct {
+	key        string
+	keyStart   int
+	valueStart int
+	valueEnd   int
+}
+
+// jsonObject is an object with the offsets of its braces and members.
+type jsonObject struct {
+	start   int
+	end     int
+	members []jsonMember
+	// inline is set for objects written on a single line; spaced tells
+	// whether their members use ": " and ", " rather than ":" and ",".
+	inline bool
+	spaced bool
+}
+
+// verbatimJSON is written to the document exactly as it is.
+type verbatimJSON []byte
+
+// orderedObject is a JSON object whose keys are written in the given order.
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// set adds or replaces key, keeping its position when it already exists.
+func (o *orderedObject) set(key string, value interface{}) {
+	if o.values == nil {
+		o.values = make(map[string]interface{})
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// newJSONDocument wraps an existing file. With jsonc set, comments and
+// trailing commas are accepted as in VS Code settings files. It fails when
+// data cannot be parsed or its root is not an object.
+func newJSONDocument(data []byte, jsonc bool) (*jsonDocument, error) {
+	doc := &jsonDocument{data: data, jsonc: jsonc}
+	doc.refresh()
+	if !json.Valid(doc.clean) {
+		var v interface{}
+		err := json.Unmarshal(doc.clean, &v)
+		if err == nil {
+			err = fmt.Errorf("invalid JSON")
+		}
+		return nil, err
+	}
+	root, ok := doc.root()
+	if !ok {
+		return nil, fmt.Errorf("top-level value is not an object")
+	}
+	doc.indent = detectIndent(data, root)
+	return doc, nil
+}
+
+// emptyJSONDocument returns a document holding an empty object.
+func emptyJSONDocument() *jsonDocument {
+	doc := &jsonDocument{data: []byte("{}\n"), indent: "  "}
+	doc.refresh()
+	return doc
+}
+
+func (d *jsonDocument) refresh() {
+	if d.jsonc {
+		d.clean = stripJSONC(d.data)
+		return
+	}
+	d.clean = d.data
+}
+
+// Decode unmarshals the document into v.
+func (d *jsonDocument) Decode(v interface{}) error {
+	return json.Unmarshal(d.clean, v)
+}
+
+// Bytes returns the document contents.
+func (d *jsonDocument) Bytes() []byte {
+	return d.data
+}
+
+// Get returns the raw value at path.
+func (d *jsonDocument) Get(path ...string) ([]byte, bool) {
+	obj, ok := d.root()
+	if !ok {
+		return nil, false
+	}
+	for i, key := range path {
+		member, found := obj.member(key)
+		if !found {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return d.data[member.valueStart:member.valueEnd], true
+		}
+		if obj, found = d.objectAt(member.valueStart); !found {
+			return nil, false
+		}
+	}
+	return d.data[obj.start : obj.end+1], true
+}
+
+// Set writes value at path. Missing objects along the path are created and
+// non-object values in the way are replaced.
+func (d *jsonDocument) Set(value interface{}, path ...string) {
+	obj, _ := d.root()
+	for i, key := range path {
+		member, found := obj.member(key)
+		if !found {
+			d.insert(obj, key, nestValue(path[i+1:], value))
+			return
+		}
+		if i == len(path)-1 {
+			d.replace(obj, member, value)
+			return
+		}
+		next, isObject := d.objectAt(member.valueStart)
+		if !isObject {
+			d.replace(obj, member, nestValue(path[i+1:], value))
+			return
+		}
+		if len(next.members) == 0 {
+			next.inline, next.spaced = obj.inline, obj.spaced
+		}
+		obj = next
+	}
+}
+
+// Delete removes the member at path if it exists.
+func (d *jsonDocument) Delete(path ...string) {
+	obj, _ := d.root()
+	for i, key := range path {
+		index := obj.index(key)
+		if index < 0 {
+			return
+		}
+		if i < len(path)-1 {
+			next, isObject := d.objectAt(obj.members[index].valueStart)
+			if !isObject {
+				return
+			}
+			obj = next
+			continue
+		}
+		var start, end int
+		switch {
+		case index > 0:
+			start, end = obj.members[index-1].valueEnd, obj.members[index].valueEnd
+		case len(obj.members) > 1:
+			start, end = obj.members[0].keyStart, obj.members[1].keyStart
+		default:
+			start, end = obj.start+1, obj.end
+		}
+		d.splice(start, end, nil)
+	}
+}
+
+// replace overwrites the value of member in obj. A value equal to the current
+// one is left untouched so its formatting is kept.
+func (d *jsonDocument) replace(obj jsonObject, member jsonMember, value interface{}) {
+	current := d.data[member.valueStart:member.valueEnd]
+	if _, ordered := value.(*orderedObject); !ordered && jsonEqual(current, value) {
+		return
+	}
+	encoded := d.encode(value, d.layout(obj, lineIndent(d.data, member.keyStart)))
+	if bytes.Equal(encoded, current) {
+		return
+	}
+	d.splice(member.valueStart, member.valueEnd, encoded)
+}
+
+// insert appends key to obj, following the layout of its existing members.
+func (d *jsonDocument) insert(obj jsonObject, key string, value interface{}) {
+	if len(obj.members) > 0 {
+		last := obj.members[len(obj.members)-1]
+		layout := d.layout(obj, lineIndent(d.data, last.keyStart))
+		if obj.inline {
+			d.splice(last.valueEnd, last.valueEnd, append([]byte(layout.separator(",")), d.member(key, value, layout)...))
+			return
+		}
+		// Insert on a new line after the last member, keeping a comment that
+		// follows it on the same line and the file's trailing comma style.
+		tail := stripJSONComments(d.data[last.valueEnd:obj.end])
+		trailingComma := bytes.IndexByte(tail, ',') >= 0
+		lineEnd := -1
+		for i, c := range tail {
+			if c == '\n' {
+				lineEnd = last.valueEnd + i
+				break
+			}
+			if c != ' ' && c != '\t' && c != '\r' && c != ',' {
+				break
+			}
+		}
+		member := "\n" + layout.prefix + string(d.member(key, value, layout))
+		switch {
+		case lineEnd < 0:
+			d.splice(last.valueEnd, last.valueEnd, []byte(","+member))
+		case trailingComma:
+			if d.data[lineEnd-1] == '\r' {
+				lineEnd--
+			}
+			d.splice(lineEnd, lineEnd, []byte(member+","))
+		default:
+			if d.data[lineEnd-1] == '\r' {
+				lineEnd--
+			}
+			d.splice(last.valueEnd, last.valueEnd, []byte(","))
+			d.splice(lineEnd+1, lineEnd+1, []byte(member))
+		}
+		return
+	}
+	// An empty object keeps what it holds, such as comments: the member goes
+	// on its own line just before the closing brace.
+	parent := lineIndent(d.data, obj.start)
+	layout := d.layout(obj, parent+d.indent)
+	member := layout.prefix + string(d.member(key, value, layout))
+	inner := d.data[obj.start+1 : obj.end]
+	if len(bytes.TrimSpace(inner)) == 0 {
+		d.splice(obj.start+1, obj.end, []byte("\n"+member+"\n"+parent))
+		return
+	}
+	if nl := bytes.LastIndexByte(inner, '\n'); nl >= 0 && len(bytes.TrimSpace(inner[nl+1:])) == 0 {
+		lineStart := obj.start + 1 + nl + 1
+		d.splice(lineStart, lineStart, []byte(member+"\n"))
+		return
+	}
+	end := obj.end
+	for end > obj.start+1 && (d.data[end-1] == ' ' || d.data[end-1] == '\t') {
+		end--
+	}
+	d.splice(end, obj.end, []byte("\n"+member+"\n"+parent))
+}
+
+// member renders a "key": value pair.
+func (d *jsonDocument) member(key string, value interface{}, layout jsonLayout) []byte {
+	keyJSON, _ := json.Marshal(key)
+	return append(append(keyJSON, layout.separator(":")...), d.encode(value, layout)...)
+}
+
+// layout returns how values inside obj are written. prefix is the
+// indentation of the line a value starts on.
+func (d *jsonDocument) layout(obj jsonObject, prefix string) jsonLayout {
+	return jsonLayout{prefix: prefix, indent: d.indent, inline: obj.inline, spaced: !obj.inline || obj.spaced}
+}
+
+func (d *jsonDocument) splice(start, end int, replacement []byte) {
+	out := make([]byte, 0, len(d.data)-(end-start)+len(replacement))
+	out = append(out, d.data[:start]...)
+	out = append(out, replacement...)
+	out = append(out, d.data[end:]...)
+	d.data = out
+	d.refresh()
+}
+
+// encode renders value with the given layout.
+func (d *jsonDocument) encode(value interface{}, layout jsonLayout) []byte {
+	var buf bytes.Buffer
+	writeJSONValue(&buf, value, layout)
+	return buf.Bytes()
+}
+
+func (d *jsonDocument) root() (jsonObject, bool) {
+	return d.objectAt(skipJSONSpace(d.clean, 0))
+}
+
+// objectAt scans the object starting at pos.
+func (d *jsonDocument) objectAt(pos int) (jsonObject, bool) {
+	if pos >= len(d.clean) || d.clean[pos] != '{' {
+		return jsonObject{}, false
+	}
+	obj := jsonObject{start: pos}
+	pos = skipJSONSpace(d.clean, pos+1)
+	for d.clean[pos] != '}' {
+		keyStart := pos
+		pos = skipJSONValue(d.clean, pos)
+		var key string
+		_ = json.Unmarshal(d.clean[keyStart:pos], &key)
+		pos = skipJSONSpace(d.clean, pos)
+		pos = skipJSONSpace(d.clean, pos+1) // ':'
+		valueStart := pos
+		pos = skipJSONValue(d.clean, pos)
+		obj.members = append(obj.members, jsonMember{key: key, keyStart: keyStart, valueStart: valueStart, valueEnd: pos})
+		pos = skipJSONSpace(d.clean, pos)
+		if d.clean[pos] == ',' {
+			pos = skipJSONSpace(d.clean, pos+1)
+		}
+	}
+	obj.end = pos
+	if len(obj.members) > 0 {
+		first := obj.members[0]
+		obj.inline = bytes.IndexByte(d.clean[obj.start:first.keyStart], '\n') < 0
+		obj.spaced = d.clean[first.valueStart-1] == ' '
+	}
+	return obj, true
+}
+
+func (o jsonObject) index(key string) int {
+	// JSON allows duplicate keys; like encoding/json, the last one wins.
+	for i := len(o.members) - 1; i >= 0; i-- {
+		if o.members[i].key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o jsonObject) member(key string) (jsonMember, bool) {
+	if i := o.index(key); i >= 0 {
+		return o.members[i], true
+	}
+	return jsonMember{}, false
+}
+
+// nestValue wraps value in one object per remaining path segment.
+func nestValue(path []string, value interface{}) interface{} {
+	for i := len(path) - 1; i >= 0; i-- {
+		obj := &orderedObject{}
+		obj.set(path[i], value)
+		value = obj
+	}
+	return value
+}
+
+// detectIndent returns one level of indentation as used by the root object's
+// first member, defaulting to two spaces.
+func detectIndent(data []byte, root jsonObject) string {
+	if len(root.members) == 0 || root.inline {
+		return "  "
+	}
+	first := root.members[0].keyStart
+	indent := strings.TrimPrefix(lineIndent(data, first), lineIndent(data, root.start))
+	if indent == "" {
+		return "  "
+	}
+	return indent
+}
+
+// lineIndent returns the leading whitespace of the line containing pos.
+func lineIndent(data []byte, pos int) string {
+	start := bytes.LastIndexByte(data[:pos], '\n') + 1
+	end := start
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// stripJSONC returns a copy of data with comments and trailing commas replaced
+// by spaces. Newlines are kept so offsets and line numbers do not change.
+func stripJSONC(data []byte) []byte {
+	out := stripJSONComments(data)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ',':
+			next := skipJSONSpace(out, i+1)
+			if next < len(out) && (out[next] == '}' || out[next] == ']') {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
+
+// stripJSONComments returns a copy of data with // and /* */ comments
+// replaced by spaces, keeping newlines.
+func stripJSONComments(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end < 0 {
+				// Leave an unterminated comment for the parser to reject.
+				return out
+			}
+			for end += i + 4; i < end; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+	return out
+}
+
+func skipJSONSpace(data []byte, pos int) int {
+	for pos < len(data) {
+		switch data[pos] {
+		case ' ', '\t', '\n', '\r':
+			pos++
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+// skipJSONValue returns the offset just past the value starting at pos. The
+// data must be valid JSON.
+func skipJSONValue(data []byte, pos int) int {
+	switch data[pos] {
+	case '"':
+		for pos++; data[pos] != '"'; pos++ {
+			if data[pos] == '\\' {
+				pos++
+			}
+		}
+		return pos + 1
+	case '{', '[':
+		depth := 0
+		for ; pos < len(data); pos++ {
+			switch data[pos] {
+			case '"':
+				pos = skipJSONValue(data, pos) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return pos + 1
+				}
+			}
+		}
+		return pos
+	default:
+		for pos < len(data) && !strings.ContainsRune(",}] \t\r\n", rune(data[pos])) {
+			pos++
+		}
+		return pos
+	}
+}
+
+// jsonLayout describes how a value is written.
+type jsonLayout struct {
+	// prefix is the indentation of the line the value starts on and indent
+	// is one level of indentation.
+	prefix string
+	indent string
+	// inline writes the value on a single line, with spaces after ':' and
+	// ',' when spaced is set.
+	inline bool
+	spaced bool
+}
+
+// separator returns sep followed by a space when the layout is spaced.
+func (l jsonLayout) separator(sep string) string {
+	if l.spaced {
+		return sep + " "
+	}
+	return sep
+}
+
+// writeJSONValue renders value with the given layout. orderedObject keys keep
+// their order; other maps are sorted as encoding/json does.
+func writeJSONValue(buf *bytes.Buffer, value interface{}, layout jsonLayout) {
+	if raw, ok := value.(verbatimJSON); ok {
+		buf.Write(raw)
+		return
+	}
+	obj, ok := value.(*orderedObject)
+	if !ok {
+		var data []byte
+		var err error
+		if layout.inline {
+			data, err = json.Marshal(value)
+			if err == nil && layout.spaced {
+				data = spaceJSON(data)
+			}
+		} else {
+			data, err = json.MarshalIndent(value, layout.prefix, layout.indent)
+		}
+		if err != nil {
+			data = []byte("null")
+		}
+		buf.Write(data)
+		return
+	}
+	if len(obj.keys) == 0 {
+		buf.WriteString("{}")
+		return
+	}
+	inner := layout
+	inner.prefix += layout.indent
+	buf.WriteByte('{')
+	for i, key := range obj.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+			if layout.inline && layout.spaced {
+				buf.WriteByte(' ')
+			}
+		}
+		if !layout.inline {
+			buf.WriteString("\n" + inner.prefix)
+		}
+		keyJSON, _ := json.Marshal(key)
+		buf.Write(keyJSON)
+		buf.WriteString(layout.separator(":"))
+		writeJSONValue(buf, obj.values[key], inner)
+	}
+	if !layout.inline {
+		buf.WriteString("\n" + layout.prefix)
+	}
+	buf.WriteByte('}')
+}
+
+// spaceJSON adds a space after every ':' and ',' of compact JSON that is not
+// inside a string.
+func spaceJSON(data []byte) []byte {
+	out := make([]byte, 0, len(data)+len(data)/4)
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		out = append(out, c)
+		switch {
+		case inString && c == '\\':
+			i++
+			out = append(out, data[i])
+		case c == '"':
+			inString = !inString
+		case !inString && (c == ':' || c == ','):
+			out = append(out, ' ')
+		}
+	}
+	return out
+}
+
+// jsonEqual reports whether raw, which may contain JSONC comments, holds the
+// same JSON value as value.
+func jsonEqual(raw []byte, value interface{}) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	var a, b interface{}
+	if json.Unmarshal(stripJSONC(raw), &a) != nil || json.Unmarshal(encoded, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// serversObject renders servers in source order as the value of an MCP node
+// whose current raw value is existing. Servers that did not change keep their
+// bytes, and changed servers keep the key order they already had.
+func serversObject(servers []transforms.Server, existing []byte) *orderedObject {
+	current, err := newJSONDocument(existing, true)
+	out := &orderedObject{}
+	for _, server := range servers {
+		var raw []byte
+		if err == nil {
+			raw, _ = current.Get(server.Name)
+		}
+		if raw != nil && jsonEqual(raw, server.Fields) {
+			out.set(server.Name, verbatimJSON(raw))
+			continue
+		}
+		out.set(server.Name, orderedFields(server.Fields, raw))
+	}
+	return out
+}
+
+// orderedFields returns fields as an object that lists the keys of existing,
+// a raw JSON object, first and in their current order, followed by the
+// remaining keys sorted. Rewriting a server therefore keeps its layout.
+func orderedFields(fields map[string]interface{}, existing []byte) *orderedObject {
+	out := &orderedObject{}
+	for _, key := range jsonObjectKeys(existing) {
+		if value, ok := fields[key]; ok {
+			out.set(key, value)
+		}
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		out.set(key, fields[key])
+	}
+	return out
+}
+
+// jsonObjectKeys lists the keys of a raw JSON object in file order.
+func jsonObjectKeys(raw []byte) []string {
+	doc, err := newJSONDocument(raw, true)
+	if err != nil {
+		return nil
+	}
+	obj, _ := doc.root()
+	keys := make([]string, 0, len(obj.members))
+	for _, member := range obj.members {
+		keys = append(keys, member.key)
+	}
+	return keys
+}
+
+// DecodeJSONC unmarshals data into v. Comments and trailing commas are
+// accepted as in JSONC files; a failure is reported as a *ParseError for
+// path.
+func DecodeJSONC(path string, data []byte, v interface{}) error {
+	if err := json.Unmarshal(stripJSONC(data), v); err != nil {
+		return &ParseError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// MergeJSONServers renders servers, in order, as the object at the key path
+// of the JSON or JSONC document existing. The document is edited in place,
+// so comments, key order and every other member are kept. With keep nil the
+// object is replaced and unchanged servers keep their bytes; otherwise
+// servers already present are updated where they are, new ones are
+// appended, and other entries are deleted unless keep reports true for
+// them. An existing document that cannot be parsed, or whose root is not an
+// object, is reported as a *ParseError.
+func MergeJSONServers(path string, existing []byte, key []string, servers []transforms.Server, keep func(name string) bool) (string, error) {
+	doc, err := loadJSONDocument(AgentConfig{FilePath: path, JSONC: true}, existing)
+	if err != nil {
+		return "", err
+	}
+	current, found := doc.Get(key...)
+	if keep == nil {
+		value := serversObject(servers, current)
+		if len(key) == 0 {
+			var buf bytes.Buffer
+			writeJSONValue(&buf, value, jsonLayout{indent: doc.indent, spaced: true})
+			buf.WriteByte('\n')
+			return buf.String(), nil
+		}
+		doc.Set(value, key...)
+		return string(doc.Bytes()), nil
+	}
+
+	if !found {
+		doc.Set(&orderedObject{}, key...)
+	}
+	ours := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		ours[server.Name] = true
+	}
+	for _, name := range jsonObjectKeys(current) {
+		if !ours[name] && !keep(name) {
+			doc.Delete(memberPath(key, name)...)
+		}
+	}
+	for _, server := range servers {
+		member := memberPath(key, server.Name)
+		raw, _ := doc.Get(member...)
+		if raw != nil && jsonEqual(raw, server.Fields) {
+			continue
+		}
+		doc.Set(orderedFields(server.Fields, raw), member...)
+	}
+	return string(doc.Bytes()), nil
+}
+
+// SetJSONValue writes value at the key path of the JSON or JSONC document
+// existing, keeping the rest of the document as it is. Errors are reported
+// as for MergeJSONServers.
+func SetJSONValue(path string, existing []byte, key []string, value interface{}) (string, error) {
+	doc, err := loadJSONDocument(AgentConfig{FilePath: path, JSONC: true}, existing)
+	if err != nil {
+		return "", err
+	}
+	if len(key) == 0 {
+		var buf bytes.Buffer
+		writeJSONValue(&buf, value, jsonLayout{indent: doc.indent, spaced: true})
+		buf.WriteByte('\n')
+		return buf.String(), nil
+	}
+	doc.Set(value, key...)
+	return string(doc.Bytes()), nil
+}
+
+// memberPath returns the path of the member name of the object at key.
+func memberPath(key []string, name string) []string {
+	return append(append(make([]string, 0, len(key)+1), key...), name)
+}
@@ -0,0 +1,210 @@
+package syncer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"agent-align/internal/transforms"
+)
+
+func TestFormatJSONConfigRewritesOnlyTheMCPNode(t *testing.T) {
+	existing := `{
+    "zoo": 1,
+    "mcpServers": {
+        "alpha": {"type": "http", "url": "https://a.test"},
+        "old": {}
+    },
+    "abc": [1, 2]
+}
+`
+	servers := []transforms.Server{
+		{Name: "zeta", Fields: map[string]interface{}{"command": "npx", "args": []interface{}{"-y", "z"}}},
+		{Name: "alpha", Fields: map[string]interface{}{"type": "http", "url": "https://a.test"}},
+	}
+	cfg := AgentConfig{Name: "copilot", NodeName: "mcpServers"}
+
+	got := mustFormat(t, formatJSONConfig, cfg, []byte(existing), servers)
+	want := `{
+    "zoo": 1,
+    "mcpServers": {
+        "zeta": {
+            "args": [
+                "-y",
+                "z"
+            ],
+            "command": "npx"
+        },
+        "alpha": {"type": "http", "url": "https://a.test"}
+    },
+    "abc": [1, 2]
+}
+`
+	if got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+	if again := mustFormat(t, formatJSONConfig, cfg, []byte(got), servers); again != got {
+		t.Fatalf("second sync changed the file:\n%s", again)
+	}
+}
+
+func TestFormatJSONConfigKeepsServerKeyOrder(t *testing.T) {
+	existing := "{\n\t\"servers\": {\n\t\t\"api\": {\n\t\t\t\"url\": \"https://old.test\",\n\t\t\t\"type\": \"http\"\n\t\t}\n\t}\n}"
+	servers := []transforms.Server{
+		{Name: "api", Fields: map[string]interface{}{"type": "http", "url": "https://new.test", "headers": map[string]interface{}{"X": "1"}}},
+	}
+	cfg := AgentConfig{Name: "vscode", NodeName: "servers"}
+
+	got := mustFormat(t, formatJSONConfig, cfg, []byte(existing), servers)
+	want := "{\n\t\"servers\": {\n\t\t\"api\": {\n\t\t\t\"url\": \"https://new.test\",\n\t\t\t\"type\": \"http\",\n\t\t\t\"headers\": {\n\t\t\t\t\"X\": \"1\"\n\t\t\t}\n\t\t}\n\t}\n}"
+	if got != want {
+		t.Fatalf("unexpected output:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestFormatJSONConfigInsertsNode(t *testing.T) {
+	servers := []transforms.Server{{Name: "a", Fields: map[string]interface{}{"command": "npx"}}}
+	cfg := AgentConfig{Name: "copilot", NodeName: "mcpServers"}
+	cases := map[string]struct {
+		existing string
+		want     string
+	}{
+		"missing file": {"", "{\n  \"mcpServers\": {\n    \"a\": {\n      \"command\": \"npx\"\n    }\n  }\n}\n"},
+		"empty object": {"{}\n", "{\n  \"mcpServers\": {\n    \"a\": {\n      \"command\": \"npx\"\n    }\n  }\n}\n"},
+		"tabs":         {"{\n\t\"theme\": \"dark\"\n}\n", "{\n\t\"theme\": \"dark\",\n\t\"mcpServers\": {\n\t\t\"a\": {\n\t\t\t\"command\": \"npx\"\n\t\t}\n\t}\n}\n"},
+		"compact":      {`{"theme":"dark"}`, `{"theme":"dark","mcpServers":{"a":{"command":"npx"}}}`},
+		"single line":  {`{"theme": "a,b:c"}`, `{"theme": "a,b:c", "mcpServers": {"a": {"command": "npx"}}}`},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := mustFormat(t, formatJSONConfig, cfg, []byte(tc.existing), servers); got != tc.want {
+				t.Fatalf("got:\n%q\nwant:\n%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJSONDocumentDelete(t *testing.T) {
+	cases := []struct {
+		existing string
+		path     []string
+		want     string
+	}{
+		{"{\n  \"a\": 1,\n  \"b\": 2\n}\n", []string{"b"}, "{\n  \"a\": 1\n}\n"},
+		{"{\n  \"a\": 1,\n  \"b\": 2\n}\n", []string{"a"}, "{\n  \"b\": 2\n}\n"},
+		{"{\n  \"mcp\": {\"excluded\": [\"x\"]}\n}\n", []string{"mcp", "excluded"}, "{\n  \"mcp\": {}\n}\n"},
+		{"{\"a\": 1}", []string{"missing", "key"}, "{\"a\": 1}"},
+	}
+	for _, tc := range cases {
+		doc, err := newJSONDocument([]byte(tc.existing), false)
+		if err != nil {
+			t.Fatalf("newJSONDocument(%q) returned error: %v", tc.existing, err)
+		}
+		doc.Delete(tc.path...)
+		if got := string(doc.Bytes()); got != tc.want {
+			t.Errorf("Delete(%v) on %q = %q, want %q", tc.path, tc.existing, got, tc.want)
+		}
+	}
+}
+
+func TestNewJSONDocumentRejectsInvalidInput(t *testing.T) {
+	for _, input := range []string{`{"a":`, `[1, 2]`, `"text"`} {
+		if _, err := newJSONDocument([]byte(input), false); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestFormatGeminiConfigEditsExcludedInPlace(t *testing.T) {
+	existing := "{\n  \"theme\": \"dark\",\n  \"mcp\": {\"excluded\": [\"foreign\"]},\n  \"mcpServers\": {}\n}\n"
+	servers := []transforms.Server{{Name: "off", Fields: map[string]interface{}{"command": "npx"}, Disabled: true}}
+	cfg := AgentConfig{Name: "gemini", NodeName: "mcpServers"}
+
+	got := mustFormat(t, formatGeminiConfig, cfg, []byte(existing), servers)
+	if !strings.Contains(got, `"mcp": {"excluded": ["foreign", "off"]},`) {
+		t.Fatalf("expected excluded list to be edited in place:\n%s", got)
+	}
+	if !strings.HasPrefix(got, "{\n  \"theme\": \"dark\",\n") || !strings.HasSuffix(got, "}\n") {
+		t.Fatalf("unrelated content should be kept:\n%s", got)
+	}
+}
+
+func TestFormatJSONConfigKeepsJSONCComments(t *testing.T) {
+	existing := `// VS Code MCP servers
+{
+  /* managed by hand */
+  "inputs": [
+    {"id": "token", "type": "promptString"}, // keep me
+  ],
+  "theme": "dark", // trailing
+}
+`
+	servers := []transforms.Server{{Name: "a", Fields: map[string]interface{}{"command": "npx"}}}
+	cfg := AgentConfig{Name: "vscode", NodeName: "servers", JSONC: true}
+
+	got := mustFormat(t, formatJSONConfig, cfg, []byte(existing), servers)
+	want := `// VS Code MCP servers
+{
+  /* managed by hand */
+  "inputs": [
+    {"id": "token", "type": "promptString"}, // keep me
+  ],
+  "theme": "dark", // trailing
+  "servers": {
+    "a": {
+      "command": "npx"
+    }
+  },
+}
+`
+	if got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+	if again := mustFormat(t, formatJSONConfig, cfg, []byte(got), servers); again != got {
+		t.Fatalf("second sync changed the file:\n%s", again)
+	}
+}
+
+func TestFormatJSONConfigAddsCommaBeforeSameLineComment(t *testing.T) {
+	existing := "{\n  \"theme\": \"dark\" // note\n}\n"
+	servers := []transforms.Server{{Name: "a", Fields: map[string]interface{}{"command": "npx"}}}
+	cfg := AgentConfig{Name: "kilocode", NodeName: "mcpServers", JSONC: true}
+
+	got := mustFormat(t, formatJSONConfig, cfg, []byte(existing), servers)
+	want := "{\n  \"theme\": \"dark\", // note\n  \"mcpServers\": {\n    \"a\": {\n      \"command\": \"npx\"\n    }\n  }\n}\n"
+	if got != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestFormatJSONConfigKeepsCommentsInEmptyObject(t *testing.T) {
+	servers := []transforms.Server{{Name: "a", Fields: map[string]interface{}{"command": "npx"}}}
+	cfg := AgentConfig{Name: "vscode", NodeName: "servers", JSONC: true}
+	cases := map[string]string{
+		"{\n  // only comment\n}\n": "{\n  // only comment\n  \"servers\": {\n    \"a\": {\n      \"command\": \"npx\"\n    }\n  }\n}\n",
+		"{ /* only comment */ }\n":  "{ /* only comment */\n  \"servers\": {\n    \"a\": {\n      \"command\": \"npx\"\n    }\n  }\n}\n",
+	}
+	for existing, want := range cases {
+		if got := mustFormat(t, formatJSONConfig, cfg, []byte(existing), servers); got != want {
+			t.Errorf("%q: got:\n%q\nwant:\n%q", existing, got, want)
+		}
+	}
+}
+
+func TestFormatJSONConfigReportsParseErrors(t *testing.T) {
+	servers := []transforms.Server{{Name: "a", Fields: map[string]interface{}{"command": "npx"}}}
+	cases := []struct {
+		cfg      AgentConfig
+		existing string
+	}{
+		{AgentConfig{Name: "vscode", NodeName: "servers", JSONC: true}, "{\n  \"theme\": \"dark\"\n  \"x\": 1\n}\n"},
+		{AgentConfig{Name: "copilot", NodeName: "mcpServers"}, "{\n  // comments are not JSON\n  \"theme\": \"dark\"\n}\n"},
+	}
+	for _, tc := range cases {
+		_, err := formatJSONConfig(tc.cfg, []byte(tc.existing), servers)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: expected a ParseError, got %v", tc.cfg.Name, err)
+		}
+	}
+}
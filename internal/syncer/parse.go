@@ -0,0 +1,209 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ParseError reports an existing agent file that could not be parsed. Such a
+// file is never overwritten silently: the target fails unless Syncer.Force is
+// set, in which case the result is marked Quarantine.
+type ParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse existing config at %q: %v", e.Path, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ResolveParseError applies the unparsable-file policy to err, the error
+// from preparing the target called name. Errors other than *ParseError are
+// returned unchanged. Without force a *ParseError fails the target with a
+// hint; with force it is logged and the first return value asks the caller
+// to quarantine the file and render as if it did not exist.
+func ResolveParseError(name string, err error, force bool, logger Logger) (bool, error) {
+	var parseErr *ParseError
+	if err == nil || !errors.As(err, &parseErr) {
+		return false, err
+	}
+	if !force {
+		return false, fmt.Errorf("skipping %s: %w; fix the file or use -force to move it aside and replace it", name, err)
+	}
+	logger.Printf("warning: %v; moving it aside and writing a new file", err)
+	return true, nil
+}
+
+// checkParse applies ResolveParseError to an agent's formatting error and
+// drops the existing content when the file is to be quarantined.
+func (s *Syncer) checkParse(cfg AgentConfig, existing []byte, err error) ([]byte, bool, error) {
+	quarantine, err := ResolveParseError(cfg.Name, err, s.Force, s.logger())
+	if quarantine {
+		existing = nil
+	}
+	return existing, quarantine, err
+}
+
+var (
+	tomlKeyPart   = `(?:[A-Za-z0-9_-]+|"(?:[^"\\]|\\.)*"|'[^']*')`
+	tomlKeyRe     = regexp.MustCompile(`^` + tomlKeyPart + `(?:\s*\.\s*` + tomlKeyPart + `)*$`)
+	tomlHeaderRe  = regexp.MustCompile(`^\[\[?\s*(` + tomlKeyPart + `(?:\s*\.\s*` + tomlKeyPart + `)*)\s*\]\]?\s*(?:#.*)?$`)
+	tomlScalarRe  = regexp.MustCompile(`^(?:true|false|[+-]?(?:inf|nan)|[+-]?[0-9][0-9A-Za-z_:.+-]*|[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9A-Za-z_:.+-]*)$`)
+	errTOMLString = errors.New("unterminated string")
+)
+
+// checkTOML reports the first structural error in a TOML document: a line
+// that is neither a table header nor a key/value pair, a malformed key, an
+// unterminated string, unbalanced brackets, or text after a value. It does not validate every
+// value, but it catches the damage a stray edit usually does.
+func checkTOML(content string) error {
+	var (
+		depth     int
+		multiline string
+		startLine int
+	)
+	for i, line := range strings.Split(content, "\n") {
+		lineNo := i + 1
+		rest := line
+		if multiline == "" && depth == 0 {
+			trimmed := strings.TrimSpace(line)
+			switch {
+			case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+				continue
+			case strings.HasPrefix(trimmed, "["):
+				if !tomlHeaderRe.MatchString(trimmed) {
+					return fmt.Errorf("line %d: invalid table header %q", lineNo, trimmed)
+				}
+				continue
+			}
+			key, value, found := splitTOMLKeyValue(trimmed)
+			if !found {
+				return fmt.Errorf("line %d: expected key = value, got %q", lineNo, trimmed)
+			}
+			if !tomlKeyRe.MatchString(key) {
+				return fmt.Errorf("line %d: invalid key %q", lineNo, key)
+			}
+			if value == "" {
+				return fmt.Errorf("line %d: missing value for %q", lineNo, key)
+			}
+			if !strings.ContainsAny(value[:1], "\"'[{") && !tomlScalarRe.MatchString(strings.TrimSpace(stripTOMLComment(value))) {
+				return fmt.Errorf("line %d: invalid value %q", lineNo, value)
+			}
+			rest = value
+			startLine = lineNo
+		}
+
+		var err error
+		depth, multiline, err = scanTOMLValue(rest, depth, multiline)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if multiline != "" {
+		return fmt.Errorf("line %d: %w", startLine, errTOMLString)
+	}
+	if depth != 0 {
+		return fmt.Errorf("line %d: unclosed array or inline table", startLine)
+	}
+	return nil
+}
+
+// splitTOMLKeyValue splits a line at the first '=' outside a quoted key.
+func splitTOMLKeyValue(line string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func stripTOMLComment(value string) string {
+	if i := strings.IndexByte(value, '#'); i >= 0 {
+		return value[:i]
+	}
+	return value
+}
+
+// scanTOMLValue tracks strings and bracket depth across the value part of a
+// line. multiline holds the delimiter of an open multi-line string. Once the
+// top-level value is complete, only a comment may follow it.
+func scanTOMLValue(s string, depth int, multiline string) (int, string, error) {
+	for i := 0; i < len(s); i++ {
+		if multiline != "" {
+			end := strings.Index(s[i:], multiline)
+			if end < 0 {
+				return depth, multiline, nil
+			}
+			i += end + len(multiline) - 1
+			// A multi-line string may end with up to two quotes of its own.
+			for n := 0; n < 2 && i+1 < len(s) && s[i+1] == multiline[0]; n++ {
+				i++
+			}
+			multiline = ""
+			if depth == 0 {
+				return depth, "", checkTOMLTrailing(s[i+1:])
+			}
+			continue
+		}
+		switch c := s[i]; c {
+		case '#':
+			return depth, "", nil
+		case '"', '\'':
+			delim := strings.Repeat(string(c), 3)
+			if strings.HasPrefix(s[i:], delim) {
+				multiline = delim
+				i += 2
+				continue
+			}
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if c == '"' && s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return depth, "", errTOMLString
+			}
+			i = j
+			if depth == 0 {
+				return depth, "", checkTOMLTrailing(s[i+1:])
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth < 0 {
+				return depth, "", fmt.Errorf("unexpected %q", c)
+			}
+			if depth == 0 {
+				return depth, "", checkTOMLTrailing(s[i+1:])
+			}
+		}
+	}
+	return depth, multiline, nil
+}
+
+// checkTOMLTrailing reports anything but a comment after a complete value.
+func checkTOMLTrailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest == "" || strings.HasPrefix(rest, "#") {
+		return nil
+	}
+	return fmt.Errorf("unexpected %q after value", rest)
+}
@@ -0,0 +1,112 @@
+package syncer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent-align/internal/mcpconfig"
+)
+
+func TestCheckTOML(t *testing.T) {
+	valid := `# Codex configuration
+model = "o3"
+approval_policy = 'never'
+max_tokens = 1_000
+enabled = true
+started = 1979-05-27T07:32:00Z
+odt = 1979-05-27 07:32:00Z
+quoted = """say "hi"""" # trailing quotes
+
+[mcp_servers."my server"]
+command = "npx" # inline comment
+args = [
+  "-y",
+  "tool",
+]
+env = { KEY = "value", "X-Y" = "z" }
+description = """
+multi-line [ text "
+"""
+
+[[profiles]]
+name = "a"
+`
+	if err := checkTOML(valid); err != nil {
+		t.Fatalf("checkTOML rejected valid TOML: %v", err)
+	}
+
+	cases := map[string]string{
+		"stray text":          "[general]\ntheme = \"dark\"\noops\n",
+		"unterminated string": "model = \"o3\n",
+		"unclosed array":      "args = [\"a\",\n\"b\"\n",
+		"bad header":          "[mcp_servers.x\ncommand = \"npx\"\n",
+		"bare value":          "model = o3\n",
+		"missing value":       "model =\n",
+		"unbalanced bracket":  "args = [\"a\"]]\n",
+		"text after string":   "a = \"x\" b\n",
+		"text after array":    "args = [\"a\"] b\n",
+		"text after table":    "env = { A = \"1\" } b\n",
+	}
+	for name, content := range cases {
+		if err := checkTOML(content); err == nil {
+			t.Errorf("%s: expected an error for %q", name, content)
+		}
+	}
+}
+
+func TestSyncRefusesUnparsableFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"codex":   "[general\ntheme = \"dark\"\n",
+		"gemini":  "{\n  \"theme\": \"dark\",\n",
+		"copilot": "{ not json",
+	}
+	var targets []AgentTarget
+	for agent, content := range files {
+		path := filepath.Join(dir, agent)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+		targets = append(targets, AgentTarget{Name: agent, PathOverride: path})
+	}
+	servers := mcpconfig.Servers{{Name: "local", Command: "npx"}}
+
+	result, err := New(targets).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	for agent := range files {
+		output := result.Agents[agent][0]
+		var parseErr *ParseError
+		if !errors.As(output.Err, &parseErr) || !strings.Contains(output.Err.Error(), "-force") {
+			t.Errorf("%s: expected a parse error mentioning -force, got %v", agent, output.Err)
+		}
+		if output.Content != "" || output.Quarantine {
+			t.Errorf("%s: unparsable file should not be replaced without force", agent)
+		}
+	}
+
+	logger := &recordingLogger{}
+	s := New(targets)
+	s.Force = true
+	s.Logger = logger
+	result, err = s.Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	for agent := range files {
+		output := result.Agents[agent][0]
+		if output.Err != nil || !output.Quarantine {
+			t.Errorf("%s: expected quarantine with force, got err=%v quarantine=%v", agent, output.Err, output.Quarantine)
+		}
+		if strings.Contains(output.Content, "theme") || !strings.Contains(output.Content, "local") {
+			t.Errorf("%s: expected fresh content, got:\n%s", agent, output.Content)
+		}
+	}
+	if len(logger.lines) != len(files) {
+		t.Errorf("expected one warning per file, got %v", logger.lines)
+	}
+}
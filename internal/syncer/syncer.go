@@ -1,24 +1,62 @@
 package syncer
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 
+	"agent-align/internal/fsys"
+	"agent-align/internal/mcpconfig"
 	"agent-align/internal/transforms"
 )
 
+// Scopes of an agent target.
+const (
+	// ScopeUser writes the agent's user-level file below the home directory.
+	ScopeUser = "user"
+	// ScopeProject writes the agent's project-level file below
+	// Syncer.ProjectDir.
+	ScopeProject = "project"
+)
+
 // AgentTarget allows overrides for an agent destination.
 type AgentTarget struct {
 	Name         string
 	PathOverride string
+	// Scope selects the agent's user-level (the default) or project-level
+	// file. A relative PathOverride of a project target is resolved against
+	// the project directory.
+	Scope string
 	// DisabledMcpServers lists MCP IDs that should be omitted for this agent.
 	DisabledMcpServers []string
+	// Tags keeps only the servers that carry at least one of the tags. An
+	// empty list keeps every server.
+	Tags []string
+	// ExcludeTags omits the servers that carry any of the tags.
+	ExcludeTags []string
+	// Overrides replaces fields of individual servers for this target, keyed
+	// by server name, before any other step. See mcpconfig.ServerSpec.Override.
+	Overrides map[string]map[string]interface{}
+	// Transports overrides the transports the agent supports, for example to
+	// exclude SSE on a client version that rejects it.
+	Transports []mcpconfig.Transport
+	// UnsupportedTransports selects what happens to servers whose transport
+	// the agent does not support. It defaults to TransportSkip.
+	UnsupportedTransports TransportPolicy
+	// Bridge is the stdio command used with TransportBridge. It defaults to
+	// DefaultBridge.
+	Bridge *Bridge
+	// Projects renders a server set into Claude Code's per-project
+	// mcpServers in ~/.claude.json, keyed by absolute project path. Only
+	// user-level claudecode targets accept it.
+	Projects map[string]ClaudeProject
 }
 
 // AgentConfig holds information about an agent's configuration file.
@@ -27,14 +65,37 @@ type AgentConfig struct {
 	FilePath string // Path to the config file
 	NodeName string // Name of the node where servers are stored
 	Format   string // "json" or "toml"
+	Scope    string // ScopeProject for project-level files, else empty
+	// JSONC reports whether the JSON file may contain comments and trailing
+	// commas, as VS Code settings files do.
+	JSONC bool
+	// Transports lists the server transports the agent can connect to.
+	Transports []mcpconfig.Transport
 }
 
 // AgentResult is the rendered output for a single agent.
 type AgentResult struct {
 	Config  AgentConfig
 	Content string
+	// Servers lists the names of the servers written for the agent.
+	Servers []string
+	// Filtered lists the servers omitted through tag filters or
+	// DisabledMcpServers.
+	Filtered []string
+	// Skipped lists the servers omitted because the agent does not support
+	// their transport.
+	Skipped []string
+	// Err is set when the agent could not be rendered. Content is empty in
+	// that case and the other agents are unaffected.
+	Err error
+	// Quarantine is set when Force replaced an existing file that could not
+	// be parsed. The file must be moved aside before Content is written.
+	Quarantine bool
 }
 
+// allTransports is the transport list of agents that accept every transport.
+var allTransports = []mcpconfig.Transport{mcpconfig.TransportStdio, mcpconfig.TransportHTTP, mcpconfig.TransportSSE}
+
 var supportedAgentList = []string{"copilot", "vscode", "codex", "claudecode", "gemini", "kilocode"}
 
 // SupportedAgents returns a list of supported agent names.
@@ -42,49 +103,64 @@ func SupportedAgents() []string {
 	return append([]string(nil), supportedAgentList...)
 }
 
-// GetAgentConfig returns the configuration information for a given agent.
+// GetAgentConfig returns the configuration information for a given agent,
+// with its default file below the home directory returned by fsys.HomeDir.
 func GetAgentConfig(agent, overridePath string) (AgentConfig, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return AgentConfig{}, fmt.Errorf("failed to get home directory: %w", err)
+	return GetAgentConfigWithHome(agent, overridePath, "")
+}
+
+// GetAgentConfigWithHome is GetAgentConfig with the default file below
+// homeDir. An empty homeDir falls back to fsys.HomeDir.
+func GetAgentConfigWithHome(agent, overridePath, homeDir string) (AgentConfig, error) {
+	if homeDir == "" {
+		var err error
+		if homeDir, err = fsys.HomeDir(); err != nil {
+			return AgentConfig{}, fmt.Errorf("failed to get home directory: %w", err)
+		}
 	}
 
 	name := normalizeAgent(agent)
 	switch name {
 	case "copilot":
 		return AgentConfig{
-			Name:     name,
-			FilePath: applyOverride(overridePath, filepath.Join(homeDir, ".copilot", "mcp-config.json")),
-			NodeName: "mcpServers",
-			Format:   "json",
+			Name:       name,
+			FilePath:   applyOverride(overridePath, filepath.Join(homeDir, ".copilot", "mcp-config.json")),
+			NodeName:   "mcpServers",
+			Format:     "json",
+			Transports: allTransports,
 		}, nil
 	case "vscode":
 		return AgentConfig{
-			Name:     name,
-			FilePath: applyOverride(overridePath, filepath.Join(homeDir, ".config", "Code", "User", "mcp.json")),
-			NodeName: "servers",
-			Format:   "json",
+			Name:       name,
+			FilePath:   applyOverride(overridePath, filepath.Join(homeDir, ".config", "Code", "User", "mcp.json")),
+			NodeName:   "servers",
+			Format:     "json",
+			JSONC:      true,
+			Transports: allTransports,
 		}, nil
 	case "codex":
 		return AgentConfig{
-			Name:     name,
-			FilePath: applyOverride(overridePath, filepath.Join(homeDir, ".codex", "config.toml")),
-			NodeName: "",
-			Format:   "toml",
+			Name:       name,
+			FilePath:   applyOverride(overridePath, filepath.Join(homeDir, ".codex", "config.toml")),
+			NodeName:   "",
+			Format:     "toml",
+			Transports: []mcpconfig.Transport{mcpconfig.TransportStdio, mcpconfig.TransportHTTP},
 		}, nil
 	case "claudecode":
 		return AgentConfig{
-			Name:     name,
-			FilePath: applyOverride(overridePath, filepath.Join(homeDir, ".claude.json")),
-			NodeName: "mcpServers",
-			Format:   "json",
+			Name:       name,
+			FilePath:   applyOverride(overridePath, filepath.Join(homeDir, ".claude.json")),
+			NodeName:   "mcpServers",
+			Format:     "json",
+			Transports: allTransports,
 		}, nil
 	case "gemini":
 		return AgentConfig{
-			Name:     name,
-			FilePath: applyOverride(overridePath, filepath.Join(homeDir, ".gemini", "settings.json")),
-			NodeName: "mcpServers",
-			Format:   "json",
+			Name:       name,
+			FilePath:   applyOverride(overridePath, filepath.Join(homeDir, ".gemini", "settings.json")),
+			NodeName:   "mcpServers",
+			Format:     "json",
+			Transports: allTransports,
 		}, nil
 	case "kilocode":
 		var defaultPath string
@@ -94,19 +170,82 @@ func GetAgentConfig(agent, overridePath string) (AgentConfig, error) {
 			defaultPath = filepath.Join(homeDir, ".config", "Code", "User", "globalStorage", "kilocode.kilo-code", "settings", "mcp_settings.json")
 		}
 		return AgentConfig{
-			Name:     name,
-			FilePath: applyOverride(overridePath, defaultPath),
-			NodeName: "mcpServers",
-			Format:   "json",
+			Name:       name,
+			FilePath:   applyOverride(overridePath, defaultPath),
+			NodeName:   "mcpServers",
+			Format:     "json",
+			JSONC:      true,
+			Transports: allTransports,
 		}, nil
 	default:
 		return AgentConfig{}, fmt.Errorf("unsupported agent: %s", agent)
 	}
 }
 
+// GetProjectAgentConfig returns the configuration information of an agent's
+// project-level file below projectDir, such as .vscode/mcp.json. A relative
+// overridePath is resolved against projectDir. Agents that only read a
+// user-level file return an error.
+func GetProjectAgentConfig(agent, overridePath, projectDir string) (AgentConfig, error) {
+	if overridePath = strings.TrimSpace(overridePath); overridePath != "" && !filepath.IsAbs(overridePath) {
+		overridePath = filepath.Join(projectDir, overridePath)
+	}
+
+	name := normalizeAgent(agent)
+	cfg := AgentConfig{Name: name, NodeName: "mcpServers", Format: "json", Scope: ScopeProject, Transports: allTransports}
+	switch name {
+	case "vscode":
+		cfg.FilePath = applyOverride(overridePath, filepath.Join(projectDir, ".vscode", "mcp.json"))
+		cfg.NodeName = "servers"
+		cfg.JSONC = true
+	case "claudecode":
+		cfg.FilePath = applyOverride(overridePath, filepath.Join(projectDir, ".mcp.json"))
+	case "gemini":
+		cfg.FilePath = applyOverride(overridePath, filepath.Join(projectDir, ".gemini", "settings.json"))
+	case "kilocode":
+		cfg.FilePath = applyOverride(overridePath, filepath.Join(projectDir, ".kilocode", "mcp.json"))
+		cfg.JSONC = true
+	default:
+		if !slices.Contains(supportedAgentList, name) {
+			return AgentConfig{}, fmt.Errorf("unsupported agent: %s", agent)
+		}
+		return AgentConfig{}, fmt.Errorf("agent %s has no project-level config", name)
+	}
+	return cfg, nil
+}
+
+// HasProjectConfig reports whether the agent has a project-level file.
+func HasProjectConfig(agent string) bool {
+	_, err := GetProjectAgentConfig(agent, "", ".")
+	return err == nil
+}
+
+// Logger receives warnings emitted while rendering.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
 // Syncer renders MCP server definitions into the supported agent formats.
 type Syncer struct {
 	Agents []AgentTarget
+	// FS is used to read the existing agent files that rendered output is
+	// merged into. It defaults to the host filesystem.
+	FS fsys.FS
+	// Logger receives warnings. It defaults to the standard logger.
+	Logger Logger
+	// Force replaces existing agent files that cannot be parsed. The result
+	// is marked Quarantine so the caller moves the file aside first. Without
+	// Force such a target fails instead of losing the file's settings.
+	Force bool
+	// Home is the home directory the default agent files are placed in. It
+	// defaults to fsys.HomeDir.
+	Home string
+	// ProjectDir is the directory the files of ScopeProject targets are
+	// placed in. Project targets fail when it is empty.
+	ProjectDir string
+	// ProjectServers, when set, replace the servers passed to Sync for
+	// ScopeProject targets.
+	ProjectServers mcpconfig.Servers
 }
 
 func New(agents []AgentTarget) *Syncer {
@@ -116,182 +255,459 @@ func New(agents []AgentTarget) *Syncer {
 // SyncResult contains the output per agent plus the parsed server data.
 type SyncResult struct {
 	Agents  map[string][]AgentResult
-	Servers map[string]interface{}
+	Servers mcpconfig.Servers
 }
 
-func (s *Syncer) Sync(servers map[string]interface{}) (SyncResult, error) {
-	if len(servers) == 0 {
+// Err joins the errors of every agent that failed to render, or returns nil
+// when all agents succeeded.
+func (r SyncResult) Err() error {
+	var errs []error
+	for _, name := range sortedResultNames(r.Agents) {
+		for _, output := range r.Agents[name] {
+			if output.Err != nil {
+				errs = append(errs, output.Err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sync renders the servers for every configured agent. Each agent is rendered
+// independently: a failure is recorded on that agent's AgentResult and the
+// remaining agents are still rendered. An error is returned only when nothing
+// can be rendered at all.
+func (s *Syncer) Sync(servers mcpconfig.Servers) (SyncResult, error) {
+	if len(servers) == 0 && len(s.ProjectServers) == 0 {
 		return SyncResult{}, fmt.Errorf("server list cannot be empty")
 	}
 
 	outputs := make(map[string][]AgentResult, len(s.Agents))
 	for _, agent := range s.Agents {
-		cfg, err := GetAgentConfig(agent.Name, agent.PathOverride)
-		if err != nil {
-			return SyncResult{}, fmt.Errorf("target agent %q not supported: %w", agent.Name, err)
+		agentServers := servers
+		if agent.Scope == ScopeProject && s.ProjectServers != nil {
+			agentServers = s.ProjectServers
+		}
+		output, rendered, existing := s.renderAgent(agent, agentServers)
+		outputs[output.Config.Name] = append(outputs[output.Config.Name], output)
+		if output.Err == nil && output.Config.Name == "claudecode" {
+			if settings, ok := s.renderClaudePermissions(output.Config, existing, rendered); ok {
+				outputs[output.Config.Name] = append(outputs[output.Config.Name], settings)
+			}
+		}
+	}
+
+	return SyncResult{Agents: outputs, Servers: servers}, nil
+}
+
+// renderAgent renders a single agent target. Errors are returned on the
+// result rather than aborting the sync. The rendered servers and the existing
+// file contents are returned for agents that write companion files.
+func (s *Syncer) renderAgent(agent AgentTarget, servers mcpconfig.Servers) (AgentResult, []transforms.Server, []byte) {
+	cfg, err := s.agentConfig(agent)
+	if err != nil {
+		cfg = AgentConfig{Name: normalizeAgent(agent.Name), FilePath: agent.PathOverride, Scope: agent.Scope}
+		return AgentResult{Config: cfg, Err: err}, nil, nil
+	}
+	if len(servers) == 0 {
+		return AgentResult{Config: cfg, Err: errors.New("server list cannot be empty")}, nil, nil
+	}
+
+	rendered, err := s.render(cfg, agent, servers)
+	result := AgentResult{
+		Config:   cfg,
+		Servers:  rendered.Names,
+		Filtered: rendered.Filtered,
+		Skipped:  rendered.Skipped,
+	}
+	if err != nil {
+		result.Err = err
+		return result, nil, nil
+	}
+
+	existing, err := fsys.ReadIfExists(s.fs(), cfg.FilePath)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read existing config for %s at %q: %w", cfg.Name, cfg.FilePath, err)
+		return result, nil, nil
+	}
+
+	result.Content, err = formatConfig(cfg, existing, rendered.Servers)
+	if existing, result.Quarantine, err = s.checkParse(cfg, existing, err); err != nil {
+		result.Err = err
+		return result, nil, nil
+	}
+	if result.Quarantine {
+		result.Content, _ = formatConfig(cfg, nil, rendered.Servers)
+	}
+	if len(agent.Projects) > 0 {
+		if result.Content, err = s.renderClaudeProjects(cfg, agent, servers, result.Content); err != nil {
+			result.Err = err
+			return result, nil, nil
 		}
+	}
+	return result, rendered.Servers, existing
+}
 
-		agentServers, err := deepCopyServers(servers)
-		if err != nil {
-			return SyncResult{}, err
+// agentConfig returns the file of agent in its scope. Only an agent without
+// a file in the scope is reported as not supported.
+func (s *Syncer) agentConfig(agent AgentTarget) (AgentConfig, error) {
+	var (
+		cfg AgentConfig
+		err error
+	)
+	switch agent.Scope {
+	case "", ScopeUser:
+		cfg, err = GetAgentConfigWithHome(agent.Name, agent.PathOverride, s.Home)
+	case ScopeProject:
+		if s.ProjectDir == "" {
+			return AgentConfig{}, fmt.Errorf("target agent %q: project scope requires a project directory", agent.Name)
 		}
+		cfg, err = GetProjectAgentConfig(agent.Name, agent.PathOverride, s.ProjectDir)
+	default:
+		return AgentConfig{}, fmt.Errorf("target agent %q: unknown scope %q", agent.Name, agent.Scope)
+	}
+	if err != nil {
+		return AgentConfig{}, fmt.Errorf("target agent %q not supported: %w", agent.Name, err)
+	}
+	return cfg, nil
+}
 
-		// Remove any servers disabled for this agent before applying transforms.
-		for _, id := range agent.DisabledMcpServers {
-			trimmed := strings.TrimSpace(id)
-			if trimmed == "" {
-				continue
+// Rendered is the output of the server pipeline for one target.
+type Rendered struct {
+	// Servers are the transformed servers to write.
+	Servers []transforms.Server
+	// Names lists the servers that made it through the pipeline. It is set
+	// even when a later step fails.
+	Names []string
+	// Filtered lists the servers omitted through tag filters or
+	// DisabledMcpServers.
+	Filtered []string
+	// Skipped lists the servers omitted because of their transport.
+	Skipped []string
+}
+
+// Render runs servers through the pipeline agent files use, for targets that
+// are not agent files: target's overrides and server filters, then, when
+// target.Name names a supported agent, that agent's transport support and
+// transformer. Without a Name the servers keep their canonical fields.
+func (s *Syncer) Render(target AgentTarget, servers mcpconfig.Servers) (Rendered, error) {
+	cfg := AgentConfig{Name: normalizeAgent(target.Name)}
+	if cfg.Name != "" {
+		var err error
+		if cfg, err = GetAgentConfigWithHome(cfg.Name, "", s.Home); err != nil {
+			return Rendered{}, fmt.Errorf("transformer %q not supported: %w", target.Name, err)
+		}
+	}
+	return s.render(cfg, target, servers)
+}
+
+// render applies overrides, tag filters and DisabledMcpServers, adapts
+// transports and runs the agent's transformer.
+func (s *Syncer) render(cfg AgentConfig, agent AgentTarget, servers mcpconfig.Servers) (Rendered, error) {
+	selected, err := s.selectServers(cfg, agent, servers)
+	if err != nil {
+		return Rendered{}, err
+	}
+	out := Rendered{Filtered: filteredNames(servers, selected)}
+
+	selected, out.Skipped, err = s.adaptTransports(cfg, agent, selected)
+	out.Names = selected.Names()
+	if err != nil {
+		return out, err
+	}
+
+	out.Servers, err = transforms.GetTransformer(cfg.Name).Transform(selected)
+	if err != nil {
+		return out, err
+	}
+	// Claude Code can only switch off the servers of a project's .mcp.json.
+	if cfg.Name == "claudecode" && cfg.Scope != ScopeProject {
+		out.Servers = enabledServers(out.Servers)
+	}
+	// Agents without a per-server switch omit disabled servers entirely.
+	out.Names = renderedNames(out.Servers)
+	return out, nil
+}
+
+// selectServers applies the target's overrides, then its tag filters and
+// DisabledMcpServers.
+func (s *Syncer) selectServers(cfg AgentConfig, agent AgentTarget, servers mcpconfig.Servers) (mcpconfig.Servers, error) {
+	if len(agent.Overrides) > 0 {
+		servers = servers.Clone()
+		for name := range agent.Overrides {
+			if _, ok := servers.Get(name); !ok {
+				s.logger().Printf("warning: ignoring override for unknown server %q in %s", name, targetLabel(cfg))
 			}
-			// Try exact match first
-			if _, ok := agentServers[trimmed]; ok {
-				delete(agentServers, trimmed)
+		}
+		for i, spec := range servers {
+			fields, ok := agent.Overrides[spec.Name]
+			if !ok {
 				continue
 			}
-			// Fallback to case-insensitive match
-			for k := range agentServers {
-				if strings.EqualFold(k, trimmed) {
-					delete(agentServers, k)
-					break
-				}
+			overridden, err := spec.Override(fields)
+			if err != nil {
+				return nil, fmt.Errorf("invalid override: %w", err)
 			}
+			servers[i] = overridden
 		}
+	}
 
-		transformer := transforms.GetTransformer(cfg.Name)
-		if err := transformer.Transform(agentServers); err != nil {
-			return SyncResult{}, err
+	kept := make(mcpconfig.Servers, 0, len(servers))
+	for _, spec := range servers {
+		if len(agent.Tags) > 0 && !spec.HasTag(agent.Tags...) {
+			continue
 		}
+		if spec.HasTag(agent.ExcludeTags...) {
+			continue
+		}
+		kept = append(kept, spec)
+	}
+	return removeDisabled(kept, agent.DisabledMcpServers), nil
+}
 
-		outputs[cfg.Name] = append(outputs[cfg.Name], AgentResult{
-			Config:  cfg,
-			Content: formatConfig(cfg, agentServers),
-		})
+// targetLabel names a target in log messages.
+func targetLabel(cfg AgentConfig) string {
+	if cfg.Name == "" {
+		return "additional target"
 	}
+	return cfg.Name
+}
 
-	return SyncResult{Agents: outputs, Servers: servers}, nil
+// enabledServers drops the servers marked Disabled.
+func enabledServers(servers []transforms.Server) []transforms.Server {
+	out := make([]transforms.Server, 0, len(servers))
+	for _, server := range servers {
+		if !server.Disabled {
+			out = append(out, server)
+		}
+	}
+	return out
 }
 
-// deepCopyServers creates a deep copy of the servers map to avoid
-// transformations from one agent affecting another.
-func deepCopyServers(servers map[string]interface{}) (map[string]interface{}, error) {
-	// Use JSON marshal/unmarshal for deep copy
-	data, err := json.Marshal(servers)
-	if err != nil {
-		return nil, fmt.Errorf("failed to copy server configuration: %w", err)
+func renderedNames(servers []transforms.Server) []string {
+	names := make([]string, 0, len(servers))
+	for _, server := range servers {
+		names = append(names, server.Name)
+	}
+	return names
+}
+
+func sortedResultNames(agents map[string][]AgentResult) []string {
+	names := make([]string, 0, len(agents))
+	for name := range agents {
+		names = append(names, name)
 	}
-	var copy map[string]interface{}
-	if err := json.Unmarshal(data, &copy); err != nil {
-		return nil, fmt.Errorf("failed to copy server configuration: %w", err)
+	sort.Strings(names)
+	return names
+}
+
+func (s *Syncer) fs() fsys.FS {
+	if s.FS != nil {
+		return s.FS
+	}
+	return fsys.OS{}
+}
+
+func (s *Syncer) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return log.Default()
+}
+
+// removeDisabled returns the servers that are not listed in disabled. Each
+// entry matches a server name exactly, falling back to a case-insensitive
+// match.
+func removeDisabled(servers mcpconfig.Servers, disabled []string) mcpconfig.Servers {
+	skip := make(map[string]struct{}, len(disabled))
+	for _, id := range disabled {
+		trimmed := strings.TrimSpace(id)
+		if trimmed == "" {
+			continue
+		}
+		// Try exact match first
+		if _, ok := servers.Get(trimmed); ok {
+			skip[trimmed] = struct{}{}
+			continue
+		}
+		// Fallback to case-insensitive match
+		for _, spec := range servers {
+			if strings.EqualFold(spec.Name, trimmed) {
+				skip[spec.Name] = struct{}{}
+				break
+			}
+		}
+	}
+
+	out := make(mcpconfig.Servers, 0, len(servers))
+	for _, spec := range servers {
+		if _, ok := skip[spec.Name]; ok {
+			continue
+		}
+		out = append(out, spec)
+	}
+	return out
+}
+
+// filteredNames returns the names in all that are missing from kept.
+func filteredNames(all, kept mcpconfig.Servers) []string {
+	var out []string
+	for _, spec := range all {
+		if _, ok := kept.Get(spec.Name); !ok {
+			out = append(out, spec.Name)
+		}
 	}
-	return copy, nil
+	return out
 }
 
-func formatConfig(config AgentConfig, servers map[string]interface{}) string {
+// formatConfig renders servers for the agent, merging them into the existing
+// file contents when present. An existing file that cannot be parsed is
+// reported as a *ParseError.
+func formatConfig(config AgentConfig, existing []byte, servers []transforms.Server) (string, error) {
 	if config.Format == "toml" {
-		return formatCodexConfig(config, servers)
+		return formatCodexConfig(config, existing, servers)
 	}
 
 	switch config.Name {
 	case "gemini":
-		return formatGeminiConfig(config, servers)
+		return formatGeminiConfig(config, existing, servers)
 	default:
-		return formatJSONConfig(config, servers)
+		return formatJSONConfig(config, existing, servers)
 	}
 }
 
-// formatToJSON converts servers to JSON format with the specified node name
-func formatGeminiConfig(cfg AgentConfig, servers map[string]interface{}) string {
-	var existing map[string]interface{}
-	if data, err := os.ReadFile(cfg.FilePath); err == nil {
-		if err := json.Unmarshal(data, &existing); err != nil {
-			existing = make(map[string]interface{})
-		}
-	}
-	if existing == nil {
-		existing = make(map[string]interface{})
+// formatGeminiConfig writes the servers into Gemini's settings.json and lists
+// disabled servers in mcp.excluded, leaving the rest of the file untouched.
+func formatGeminiConfig(cfg AgentConfig, existingData []byte, servers []transforms.Server) (string, error) {
+	doc, err := loadJSONDocument(cfg, existingData)
+	if err != nil {
+		return "", err
 	}
+	settings := make(map[string]interface{})
+	_ = doc.Decode(&settings)
 
-	existing[cfg.NodeName] = servers
-	data, err := json.MarshalIndent(existing, "", "  ")
-	if err != nil {
-		return ""
+	current, _ := doc.Get(cfg.NodeName)
+	doc.Set(serversObject(servers, current), cfg.NodeName)
+
+	updateGeminiExcluded(settings, servers)
+	if mcp, ok := settings["mcp"].(map[string]interface{}); ok {
+		if excluded, ok := mcp["excluded"]; ok {
+			doc.Set(excluded, "mcp", "excluded")
+		} else {
+			doc.Delete("mcp", "excluded")
+		}
 	}
-	return string(data)
+	return string(doc.Bytes()), nil
 }
 
-func formatToJSON(nodeName string, servers map[string]interface{}) string {
-	var output map[string]interface{}
-	if nodeName != "" {
-		output = map[string]interface{}{
-			nodeName: servers,
+// updateGeminiExcluded lists disabled servers in Gemini's mcp.excluded setting.
+// Names of servers that are not managed here are kept as they are.
+func updateGeminiExcluded(settings map[string]interface{}, servers []transforms.Server) {
+	managed := make(map[string]struct{}, len(servers))
+	var disabled []string
+	for _, server := range servers {
+		managed[server.Name] = struct{}{}
+		if server.Disabled {
+			disabled = append(disabled, server.Name)
+		}
+	}
+	sort.Strings(disabled)
+
+	mcp, _ := settings["mcp"].(map[string]interface{})
+	var excluded []interface{}
+	if mcp != nil {
+		if current, ok := mcp["excluded"].([]interface{}); ok {
+			for _, item := range current {
+				if name, ok := item.(string); ok {
+					if _, isManaged := managed[name]; isManaged {
+						continue
+					}
+				}
+				excluded = append(excluded, item)
+			}
+		}
+	}
+	for _, name := range disabled {
+		excluded = append(excluded, name)
+	}
+
+	if len(excluded) == 0 {
+		if mcp != nil {
+			delete(mcp, "excluded")
 		}
-	} else {
-		output = servers
+		return
+	}
+	if mcp == nil {
+		mcp = make(map[string]interface{})
+		settings["mcp"] = mcp
 	}
+	mcp["excluded"] = excluded
+}
+
+// formatToJSON renders servers, in order, as a whole JSON file.
+func formatToJSON(servers []transforms.Server) string {
+	var buf bytes.Buffer
+	writeJSONValue(&buf, serversObject(servers, nil), jsonLayout{indent: "  ", spaced: true})
+	buf.WriteByte('\n')
+	return buf.String()
+}
 
-	data, err := json.MarshalIndent(output, "", "  ")
+// loadJSONDocument parses the existing file for in-place editing. A missing or
+// empty file yields an empty object.
+func loadJSONDocument(cfg AgentConfig, existingData []byte) (*jsonDocument, error) {
+	if len(bytes.TrimSpace(existingData)) == 0 {
+		return emptyJSONDocument(), nil
+	}
+	doc, err := newJSONDocument(existingData, cfg.JSONC)
 	if err != nil {
-		return ""
+		return nil, &ParseError{Path: cfg.FilePath, Err: err}
 	}
-	return string(data)
+	return doc, nil
 }
 
-// formatJSONConfig merges the provided servers into the existing JSON file when
-// a NodeName is specified. This preserves unrelated top-level settings (like
-// editor prefs) while replacing only the MCP servers node. If the existing
-// file is missing or invalid JSON, a new object is created containing the
-// nodeName or servers as appropriate.
-func formatJSONConfig(cfg AgentConfig, servers map[string]interface{}) string {
+// formatJSONConfig writes the servers into the NodeName member of the existing
+// JSON file. Only that member is rewritten: the file's other settings, key
+// order, indentation, comments (for JSONC files) and trailing newline are
+// kept, and servers appear in the order they were defined.
+func formatJSONConfig(cfg AgentConfig, existingData []byte, servers []transforms.Server) (string, error) {
 	// If no node name is provided, just render servers as the full file.
 	if cfg.NodeName == "" {
-		return formatToJSON("", servers)
-	}
-
-	var existing map[string]interface{}
-	if data, err := os.ReadFile(cfg.FilePath); err == nil {
-		if err := json.Unmarshal(data, &existing); err != nil {
-			// If existing file can't be parsed, log a warning and fall back
-			// to an empty object so we can write a sane JSON file.
-			log.Printf("warning: failed to parse existing JSON %q: %v; overwriting mcp node", cfg.FilePath, err)
-			existing = make(map[string]interface{})
-		}
-	}
-	if existing == nil {
-		existing = make(map[string]interface{})
+		return formatToJSON(servers), nil
 	}
 
-	existing[cfg.NodeName] = servers
-	data, err := json.MarshalIndent(existing, "", "  ")
+	doc, err := loadJSONDocument(cfg, existingData)
 	if err != nil {
-		return ""
+		return "", err
 	}
-	return string(data)
+	current, _ := doc.Get(cfg.NodeName)
+	doc.Set(serversObject(servers, current), cfg.NodeName)
+	return string(doc.Bytes()), nil
 }
 
 // formatToTOML converts servers to Codex TOML format
-func formatToTOML(servers map[string]interface{}) string {
+func formatToTOML(servers []transforms.Server) string {
 	var sb strings.Builder
 
 	// Sort server names for consistent output
-	names := make([]string, 0, len(servers))
-	for name := range servers {
-		names = append(names, name)
-	}
-	sort.Strings(names)
-
-	for _, name := range names {
-		serverData, ok := servers[name].(map[string]interface{})
-		if !ok {
-			continue
-		}
+	sorted := append([]transforms.Server(nil), servers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
 
-		formatServerToTOML(&sb, "mcp_servers."+name, serverData)
+	for _, server := range sorted {
+		formatServerToTOML(&sb, "mcp_servers."+tomlKey(server.Name), server.Fields)
 	}
 
 	return strings.TrimRight(sb.String(), "\n")
 }
 
-// formatServerToTOML recursively formats a server and its nested sections to TOML
+// formatServerToTOML recursively formats a server and its nested sections to TOML.
+// String maps such as env, http_headers and env_http_headers are written as
+// inline tables; other nested maps become sub-sections.
 func formatServerToTOML(sb *strings.Builder, sectionPath string, data map[string]interface{}) {
+	formatTOMLTable(sb, "["+sectionPath+"]", "", sectionPath, data)
+}
+
+// formatTOMLTable writes header, then the lead lines, then the simple values
+// of data and its nested maps as sub-sections of sectionPath.
+func formatTOMLTable(sb *strings.Builder, header, lead, sectionPath string, data map[string]interface{}) {
 	// Separate nested maps from simple values
 	simpleValues := make(map[string]interface{})
 	nestedMaps := make(map[string]map[string]interface{})
@@ -299,13 +715,13 @@ func formatServerToTOML(sb *strings.Builder, sectionPath string, data map[string
 	for k, v := range data {
 		if nested, ok := v.(map[string]interface{}); ok {
 			nestedMaps[k] = nested
-		} else {
-			simpleValues[k] = v
+			continue
 		}
+		simpleValues[k] = v
 	}
 
 	// Write the section header and simple values
-	sb.WriteString(fmt.Sprintf("[%s]\n", sectionPath))
+	sb.WriteString(header + "\n" + lead)
 
 	// Sort keys for consistent output
 	keys := make([]string, 0, len(simpleValues))
@@ -315,27 +731,7 @@ func formatServerToTOML(sb *strings.Builder, sectionPath string, data map[string
 	sort.Strings(keys)
 
 	for _, k := range keys {
-		v := simpleValues[k]
-		switch val := v.(type) {
-		case string:
-			sb.WriteString(fmt.Sprintf("%s = \"%s\"\n", k, val))
-		case []interface{}:
-			arr := make([]string, 0, len(val))
-			for _, item := range val {
-				if s, ok := item.(string); ok {
-					arr = append(arr, fmt.Sprintf("\"%s\"", s))
-				}
-			}
-			sb.WriteString(fmt.Sprintf("%s = [%s]\n", k, strings.Join(arr, ", ")))
-		case []string:
-			arr := make([]string, 0, len(val))
-			for _, s := range val {
-				arr = append(arr, fmt.Sprintf("\"%s\"", s))
-			}
-			sb.WriteString(fmt.Sprintf("%s = [%s]\n", k, strings.Join(arr, ", ")))
-		default:
-			sb.WriteString(fmt.Sprintf("%s = %v\n", k, val))
-		}
+		sb.WriteString(fmt.Sprintf("%s = %s\n", tomlKey(k), tomlValue(simpleValues[k])))
 	}
 	sb.WriteString("\n")
 
@@ -348,14 +744,102 @@ func formatServerToTOML(sb *strings.Builder, sectionPath string, data map[string
 
 	// Recursively format nested maps as separate sections
 	for _, k := range nestedKeys {
-		formatServerToTOML(sb, sectionPath+"."+k, nestedMaps[k])
+		formatServerToTOML(sb, sectionPath+"."+tomlKey(k), nestedMaps[k])
+	}
+}
+
+// tomlValue renders a scalar, array or string map as a TOML value.
+func tomlValue(value interface{}) string {
+	switch val := value.(type) {
+	case string:
+		return tomlString(val)
+	case []string:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			items = append(items, tomlString(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case []interface{}:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			items = append(items, tomlValue(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]string:
+		items := make([]string, 0, len(val))
+		for _, k := range mcpconfig.SortedKeys(val) {
+			items = append(items, fmt.Sprintf("%s = %s", tomlKey(k), tomlString(val[k])))
+		}
+		if len(items) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, k := range keys {
+			items = append(items, fmt.Sprintf("%s = %s", tomlKey(k), tomlValue(val[k])))
+		}
+		if len(items) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// tomlKey returns key as a bare key when possible and quoted otherwise.
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, r := range key {
+		if !(r == '_' || r == '-' || (r >= '0' && r <= '9') || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')) {
+			return tomlString(key)
+		}
 	}
+	return key
 }
 
-func formatCodexConfig(cfg AgentConfig, servers map[string]interface{}) string {
-	var existing string
-	if data, err := os.ReadFile(cfg.FilePath); err == nil {
-		existing = string(data)
+// tomlString quotes s as a TOML basic string.
+func tomlString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				sb.WriteString(fmt.Sprintf(`\u%04X`, r))
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// formatCodexConfig replaces the [mcp_servers.*] sections of the existing
+// Codex config and keeps everything else.
+func formatCodexConfig(cfg AgentConfig, existingData []byte, servers []transforms.Server) (string, error) {
+	existing := string(existingData)
+	if err := checkTOML(existing); err != nil {
+		return "", &ParseError{Path: cfg.FilePath, Err: err}
 	}
 
 	preserved := strings.TrimRight(stripMCPServersSections(existing), "\r\n")
@@ -370,10 +854,10 @@ func formatCodexConfig(cfg AgentConfig, servers map[string]interface{}) string {
 	}
 
 	if len(parts) == 0 {
-		return ""
+		return "", nil
 	}
 
-	return strings.Join(parts, "\n\n") + "\n"
+	return strings.Join(parts, "\n\n") + "\n", nil
 }
 
 func stripMCPServersSections(content string) string {
@@ -418,7 +902,6 @@ func dedupeTargets(targets []AgentTarget) []AgentTarget {
 		if name == "" {
 			continue
 		}
-		// Include a deterministic representation of disabled MCPs in the key
 		disabled := make([]string, 0, len(target.DisabledMcpServers))
 		for _, d := range target.DisabledMcpServers {
 			t := strings.TrimSpace(d)
@@ -431,16 +914,20 @@ func dedupeTargets(targets []AgentTarget) []AgentTarget {
 		if len(disabled) > 1 {
 			sort.Strings(disabled)
 		}
-		key := name + "|" + strings.TrimSpace(target.PathOverride) + "|" + strings.Join(disabled, ",")
-		if _, exists := seen[key]; exists {
-			continue
+		target.Name = name
+		target.PathOverride = strings.TrimSpace(target.PathOverride)
+		target.DisabledMcpServers = disabled
+		// Like config.normalizeTargets, only exact duplicates are dropped:
+		// targets that differ in any selection field render differently. A
+		// target that cannot be encoded, such as one whose overrides hold a
+		// value JSON has no form for, is kept without being compared.
+		if key, err := json.Marshal(target); err == nil {
+			if _, exists := seen[string(key)]; exists {
+				continue
+			}
+			seen[string(key)] = struct{}{}
 		}
-		seen[key] = struct{}{}
-		out = append(out, AgentTarget{
-			Name:         name,
-			PathOverride: strings.TrimSpace(target.PathOverride),
-			DisabledMcpServers: disabled,
-		})
+		out = append(out, target)
 	}
 	return out
 }
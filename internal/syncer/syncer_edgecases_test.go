@@ -3,6 +3,8 @@ package syncer
 import (
 	"strings"
 	"testing"
+
+	"agent-align/internal/transforms"
 )
 
 func TestStripMCPServersSections_RemovesBlocksAndKeepsOthers(t *testing.T) {
@@ -30,11 +32,11 @@ command = "npx"
 }
 
 func TestFormatToTOML_MixedArrayAndTypes(t *testing.T) {
-	servers := map[string]interface{}{
-		"alpha": map[string]interface{}{
+	servers := []transforms.Server{
+		{Name: "alpha", Fields: map[string]interface{}{
 			"command": "node",
 			"args":    []interface{}{"a", 123, "b"},
-		},
+		}},
 	}
 
 	toml := formatToTOML(servers)
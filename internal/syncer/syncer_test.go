@@ -2,26 +2,56 @@ package syncer
 
 import (
 	"encoding/json"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"agent-align/internal/fsys"
+	"agent-align/internal/mcpconfig"
+	"agent-align/internal/transforms"
 )
 
+type formatFunc func(AgentConfig, []byte, []transforms.Server) (string, error)
+
+// mustFormat runs a JSON or TOML formatter and fails the test on error.
+func mustFormat(t *testing.T, format formatFunc, cfg AgentConfig, existing []byte, servers []transforms.Server) string {
+	t.Helper()
+	out, err := format(cfg, existing, servers)
+	if err != nil {
+		t.Fatalf("failed to format %s config: %v", cfg.Name, err)
+	}
+	return out
+}
+
+func readExisting(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := fsys.ReadIfExists(fsys.OS{}, path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return data
+}
+
 func TestSyncerSync(t *testing.T) {
 	targets := []AgentTarget{
 		{Name: "copilot"},
 		{Name: "vscode"},
 		{Name: "codex", PathOverride: "/custom/codex.toml"},
 	}
-	servers := map[string]interface{}{
-		"command-server": map[string]interface{}{
-			"command": "npx",
-			"args":    []interface{}{"tool"},
+	servers := mcpconfig.Servers{
+		{
+			Name:    "command-server",
+			Command: "npx",
+			Args:    []string{"tool"},
 		},
-		"http-server": map[string]interface{}{
-			"type": "streamable-http",
-			"url":  "https://example.test",
+		{
+			Name:      "http-server",
+			Type:      "streamable-http",
+			Transport: mcpconfig.TransportHTTP,
+			URL:       "https://example.test",
 		},
 	}
 
@@ -91,6 +121,52 @@ func TestSupportedAgents(t *testing.T) {
 	}
 }
 
+func TestSyncProjectScope(t *testing.T) {
+	project := t.TempDir()
+	targets := []AgentTarget{
+		{Name: "vscode", Scope: ScopeProject},
+		{Name: "claudecode", Scope: ScopeProject},
+		{Name: "kilocode", Scope: ScopeProject},
+		{Name: "gemini", Scope: ScopeProject, PathOverride: "config/gemini.json"},
+		{Name: "codex", Scope: ScopeProject},
+		{Name: "vscode", Scope: ScopeUser, PathOverride: filepath.Join(project, "user.json")},
+	}
+	s := New(targets)
+	s.ProjectDir = project
+	result, err := s.Sync(mcpconfig.Servers{{Name: "local", Command: "npx"}})
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+
+	want := map[string]string{
+		"vscode":     filepath.Join(project, ".vscode", "mcp.json"),
+		"claudecode": filepath.Join(project, ".mcp.json"),
+		"kilocode":   filepath.Join(project, ".kilocode", "mcp.json"),
+		"gemini":     filepath.Join(project, "config", "gemini.json"),
+	}
+	for agent, path := range want {
+		output := result.Agents[agent][0]
+		if output.Err != nil {
+			t.Fatalf("%s: unexpected error: %v", agent, output.Err)
+		}
+		if output.Config.FilePath != path || output.Config.Scope != ScopeProject {
+			t.Errorf("%s: got %s (%q), want %s", agent, output.Config.FilePath, output.Config.Scope, path)
+		}
+	}
+	if user := result.Agents["vscode"][1]; user.Config.FilePath != filepath.Join(project, "user.json") || user.Config.Scope != "" {
+		t.Errorf("unexpected user-scope vscode target: %+v", user.Config)
+	}
+	if err := result.Agents["codex"][0].Err; err == nil || !strings.Contains(err.Error(), "no project-level config") {
+		t.Errorf("expected codex to have no project file, got %v", err)
+	}
+
+	s.ProjectDir = ""
+	result, _ = s.Sync(mcpconfig.Servers{{Name: "local", Command: "npx"}})
+	if err := result.Agents["claudecode"][0].Err; err == nil || !strings.Contains(err.Error(), "requires a project directory") {
+		t.Errorf("expected an error without a project directory, got %v", err)
+	}
+}
+
 func TestFormatCodexConfigPreservesExistingSections(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "config.toml")
@@ -109,14 +185,13 @@ font_size = 12
 		t.Fatalf("failed to write existing config: %v", err)
 	}
 
-	servers := map[string]interface{}{
-		"new": map[string]interface{}{
+	servers := []transforms.Server{
+		{Name: "new", Fields: map[string]interface{}{
 			"command": "npx",
-			"args":    []interface{}{"tool"},
-		},
+			"args":    []string{"tool"},
+		}},
 	}
-	cfg := AgentConfig{Name: "codex", FilePath: path, Format: "toml"}
-	result := formatCodexConfig(cfg, servers)
+	result := mustFormat(t, formatCodexConfig, AgentConfig{Name: "codex", FilePath: path}, readExisting(t, path), servers)
 
 	if !strings.Contains(result, "[general]") {
 		t.Fatal("general section should remain in output")
@@ -150,13 +225,13 @@ func TestFormatGeminiConfigPreservesExistingSettings(t *testing.T) {
 		t.Fatalf("failed to write existing config: %v", err)
 	}
 
-	servers := map[string]interface{}{
-		"new": map[string]interface{}{
+	servers := []transforms.Server{
+		{Name: "new", Fields: map[string]interface{}{
 			"command": "npx",
-		},
+		}},
 	}
 	cfg := AgentConfig{Name: "gemini", FilePath: path, NodeName: "mcpServers", Format: "json"}
-	result := formatConfig(cfg, servers)
+	result := mustFormat(t, formatConfig, cfg, readExisting(t, path), servers)
 
 	var parsed map[string]interface{}
 	if err := json.Unmarshal([]byte(result), &parsed); err != nil {
@@ -197,13 +272,13 @@ func TestFormatClaudeConfigPreservesExistingSettings(t *testing.T) {
 		t.Fatalf("failed to write existing config: %v", err)
 	}
 
-	servers := map[string]interface{}{
-		"new": map[string]interface{}{
+	servers := []transforms.Server{
+		{Name: "new", Fields: map[string]interface{}{
 			"command": "npx",
-		},
+		}},
 	}
 	cfg := AgentConfig{Name: "claudecode", FilePath: path, NodeName: "mcpServers", Format: "json"}
-	result := formatConfig(cfg, servers)
+	result := mustFormat(t, formatConfig, cfg, readExisting(t, path), servers)
 
 	var parsed map[string]interface{}
 	if err := json.Unmarshal([]byte(result), &parsed); err != nil {
@@ -229,13 +304,13 @@ func TestFormatClaudeConfigPreservesExistingSettings(t *testing.T) {
 
 func TestFormatGeminiConfigWithoutExistingFile(t *testing.T) {
 	path := filepath.Join(t.TempDir(), "settings.json")
-	servers := map[string]interface{}{
-		"server": map[string]interface{}{
+	servers := []transforms.Server{
+		{Name: "server", Fields: map[string]interface{}{
 			"command": "npx",
-		},
+		}},
 	}
 	cfg := AgentConfig{Name: "gemini", FilePath: path, NodeName: "mcpServers", Format: "json"}
-	result := formatConfig(cfg, servers)
+	result := mustFormat(t, formatConfig, cfg, readExisting(t, path), servers)
 
 	var parsed map[string]interface{}
 	if err := json.Unmarshal([]byte(result), &parsed); err != nil {
@@ -253,20 +328,26 @@ func TestSyncGeminiRemovesUnsupportedFields(t *testing.T) {
 	targets := []AgentTarget{
 		{Name: "gemini"},
 	}
-	servers := map[string]interface{}{
-		"server1": map[string]interface{}{
-			"command":     "npx",
-			"args":        []interface{}{"-y", "some-mcp-server"},
-			"autoApprove": []interface{}{},
-			"disabled":    false,
+	servers := mcpconfig.Servers{
+		{
+			Name:    "server1",
+			Command: "npx",
+			Args:    []string{"-y", "some-mcp-server"},
+			Extra: map[string]interface{}{
+				"autoApprove": []interface{}{},
+			},
 		},
-		"server2": map[string]interface{}{
-			"type":    "stdio",
-			"command": "uvx",
-			"gallery": true,
-			"env": map[string]interface{}{
+		{
+			Name:      "server2",
+			Type:      "stdio",
+			Transport: mcpconfig.TransportStdio,
+			Command:   "uvx",
+			Env: map[string]string{
 				"API_KEY": "test",
 			},
+			Extra: map[string]interface{}{
+				"gallery": true,
+			},
 		},
 	}
 
@@ -320,3 +401,358 @@ func TestSyncGeminiRemovesUnsupportedFields(t *testing.T) {
 		t.Error("env should be preserved in server2")
 	}
 }
+
+func TestSyncDisabledServersAndIntegerValues(t *testing.T) {
+	servers := mcpconfig.Servers{
+		{Name: "Keep", Command: "npx", Extra: map[string]interface{}{"retries": 10, "budget": 1000000}},
+		{Name: "Drop", Command: "npx"},
+	}
+
+	s := New([]AgentTarget{{Name: "codex", PathOverride: filepath.Join(t.TempDir(), "config.toml"), DisabledMcpServers: []string{"drop"}}})
+	result, err := s.Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+
+	content := result.Agents["codex"][0].Content
+	if strings.Contains(content, "[mcp_servers.Drop]") {
+		t.Fatalf("disabled server should be removed case-insensitively: %s", content)
+	}
+	if !strings.Contains(content, "retries = 10\n") || !strings.Contains(content, "budget = 1000000\n") {
+		t.Fatalf("integers should render without exponent notation: %s", content)
+	}
+	if got := result.Agents["codex"][0].Filtered; len(got) != 1 || got[0] != "Drop" {
+		t.Fatalf("expected Drop to be reported as filtered, got %v", got)
+	}
+	if got := result.Agents["codex"][0].Servers; len(got) != 1 || got[0] != "Keep" {
+		t.Fatalf("expected only Keep to be written, got %v", got)
+	}
+	if len(result.Servers) != 2 {
+		t.Fatalf("sync result should keep the full server list, got %v", result.Servers.Names())
+	}
+}
+
+func TestSyncCollectsPerAgentErrors(t *testing.T) {
+	dir := t.TempDir()
+	servers := mcpconfig.Servers{
+		{Name: "remote", URL: "https://example.test/mcp"},
+		{Name: "local", Command: "npx"},
+	}
+
+	// A directory where the copilot config should be makes reading it fail.
+	s := New([]AgentTarget{
+		{Name: "copilot", PathOverride: dir},
+		{Name: "claudecode", PathOverride: filepath.Join(dir, "claude.json")},
+		{Name: "unknown", PathOverride: filepath.Join(dir, "unknown.json")},
+	})
+	result, err := s.Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync should not abort on per-agent failures: %v", err)
+	}
+
+	copilot := result.Agents["copilot"][0]
+	if copilot.Err == nil || !strings.Contains(copilot.Err.Error(), "failed to read existing config for copilot") {
+		t.Fatalf("expected copilot read error, got %v", copilot.Err)
+	}
+	if copilot.Content != "" {
+		t.Fatalf("failed agent should not have content: %q", copilot.Content)
+	}
+
+	claude := result.Agents["claudecode"][0]
+	if claude.Err != nil || !strings.Contains(claude.Content, `"local"`) {
+		t.Fatalf("claudecode should render despite the copilot failure: %v %q", claude.Err, claude.Content)
+	}
+
+	unknown := result.Agents["unknown"][0]
+	if unknown.Err == nil || !strings.Contains(unknown.Err.Error(), "not supported") {
+		t.Fatalf("expected unsupported agent error, got %v", unknown.Err)
+	}
+
+	joined := result.Err()
+	if joined == nil || !strings.Contains(joined.Error(), "copilot") || !strings.Contains(joined.Error(), "not supported") {
+		t.Fatalf("expected aggregated errors, got %v", joined)
+	}
+}
+
+func TestSyncValidatesTransportForEveryAgent(t *testing.T) {
+	dir := t.TempDir()
+	servers := mcpconfig.Servers{{Name: "remote", Type: "http", Transport: mcpconfig.TransportHTTP}}
+
+	var targets []AgentTarget
+	for _, agent := range SupportedAgents() {
+		targets = append(targets, AgentTarget{Name: agent, PathOverride: filepath.Join(dir, agent+".cfg")})
+	}
+	result, err := New(targets).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	for _, agent := range SupportedAgents() {
+		output := result.Agents[agent][0]
+		if output.Err == nil || !strings.Contains(output.Err.Error(), "has no url") {
+			t.Errorf("%s: expected missing url error, got %v", agent, output.Err)
+		}
+	}
+}
+
+func TestSyncWritesAgentTransportSpelling(t *testing.T) {
+	dir := t.TempDir()
+	servers := mcpconfig.Servers{
+		{Name: "local", Command: "npx"},
+		{Name: "remote", Type: "streamableHttp", Transport: mcpconfig.TransportHTTP, URL: "https://example.test/mcp"},
+	}
+	want := map[string][2]string{
+		"copilot":    {"local", "http"},
+		"vscode":     {"stdio", "http"},
+		"claudecode": {"stdio", "http"},
+		"kilocode":   {"stdio", "streamable-http"},
+	}
+
+	var targets []AgentTarget
+	for agent := range want {
+		targets = append(targets, AgentTarget{Name: agent, PathOverride: filepath.Join(dir, agent+".json")})
+	}
+	result, err := New(targets).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	for agent, types := range want {
+		output := result.Agents[agent][0]
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(output.Content), &data); err != nil {
+			t.Fatalf("%s output not valid JSON: %v", agent, err)
+		}
+		entries := data[output.Config.NodeName].(map[string]interface{})
+		for i, name := range []string{"local", "remote"} {
+			if got := entries[name].(map[string]interface{})["type"]; got != types[i] {
+				t.Errorf("%s %s: type = %v, want %s", agent, name, got, types[i])
+			}
+		}
+	}
+}
+
+func TestCodexStreamableHTTPRendering(t *testing.T) {
+	servers := mcpconfig.Servers{
+		{
+			Name:              "linear",
+			Type:              "streamable-http",
+			Transport:         mcpconfig.TransportHTTP,
+			URL:               "https://mcp.linear.test/mcp",
+			Headers:           map[string]string{"Authorization": "Bearer secret", "X-Api-Key": "key", "X-Team": "core"},
+			HeaderTemplates:   map[string]string{"Authorization": "Bearer ${LINEAR_TOKEN}", "X-Api-Key": "${LINEAR_KEY}"},
+			Timeout:           30500,
+			StartupTimeoutSec: 20,
+			Disabled:          true,
+		},
+		{
+			Name:    "local",
+			Command: "npx",
+			Args:    []string{"-y", `say "hi"`},
+			Env:     map[string]string{"PORT": "8080"},
+			Extra:   map[string]interface{}{"env_vars": []interface{}{"HOME"}},
+		},
+	}
+
+	s := New([]AgentTarget{{Name: "codex", PathOverride: filepath.Join(t.TempDir(), "config.toml")}})
+	result, err := s.Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	content := result.Agents["codex"][0].Content
+
+	want := `[mcp_servers.linear]
+bearer_token_env_var = "LINEAR_TOKEN"
+enabled = false
+env_http_headers = { X-Api-Key = "LINEAR_KEY" }
+http_headers = { X-Team = "core" }
+startup_timeout_sec = 20
+tool_timeout_sec = 31
+url = "https://mcp.linear.test/mcp"
+`
+	if !strings.Contains(content, want) {
+		t.Fatalf("unexpected linear section:\n%s", content)
+	}
+	if strings.Contains(content, "type = ") || strings.Contains(content, "streamable-http") {
+		t.Fatalf("codex output should not contain a transport type:\n%s", content)
+	}
+
+	wantLocal := `[mcp_servers.local]
+args = ["-y", "say \"hi\""]
+command = "npx"
+env = { PORT = "8080" }
+env_vars = ["HOME"]
+`
+	if !strings.Contains(content, wantLocal) {
+		t.Fatalf("unexpected local section:\n%s", content)
+	}
+	if strings.Contains(content, "[mcp_servers.local.env]") {
+		t.Fatalf("env should be an inline table:\n%s", content)
+	}
+}
+
+func TestTOMLKeysAndStrings(t *testing.T) {
+	if got := tomlKey("my.server"); got != `"my.server"` {
+		t.Fatalf("dotted keys should be quoted, got %s", got)
+	}
+	if got := tomlKey("X-Api_Key1"); got != "X-Api_Key1" {
+		t.Fatalf("bare keys should stay bare, got %s", got)
+	}
+	if got := tomlString("a\\b\n\"c\""); got != `"a\\b\n\"c\""` {
+		t.Fatalf("unexpected escaping: %s", got)
+	}
+}
+
+func TestFormatGeminiConfigExcludesDisabledServers(t *testing.T) {
+	cfg := AgentConfig{Name: "gemini", NodeName: "mcpServers", Format: "json"}
+	existing := []byte(`{"mcp": {"excluded": ["manual", "alpha"], "allowed": ["x"]}}`)
+	servers := []transforms.Server{
+		{Name: "alpha", Fields: map[string]interface{}{"command": "npx"}},
+		{Name: "beta", Fields: map[string]interface{}{"command": "uvx"}, Disabled: true},
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(mustFormat(t, formatGeminiConfig, cfg, existing, servers)), &data); err != nil {
+		t.Fatalf("output not valid JSON: %v", err)
+	}
+	mcp := data["mcp"].(map[string]interface{})
+	excluded := mcp["excluded"].([]interface{})
+	if len(excluded) != 2 || excluded[0] != "manual" || excluded[1] != "beta" {
+		t.Fatalf("unexpected excluded list: %v", excluded)
+	}
+	if mcp["allowed"] == nil {
+		t.Fatal("other mcp settings should be preserved")
+	}
+	if _, ok := data["mcpServers"].(map[string]interface{})["beta"]; !ok {
+		t.Fatal("disabled servers should keep their definition")
+	}
+
+	out := mustFormat(t, formatGeminiConfig, cfg, nil, servers[:1])
+	if strings.Contains(out, `"mcp"`) {
+		t.Fatalf("mcp settings should not be created without disabled servers: %s", out)
+	}
+}
+
+func TestSyncRendersDisabledServersPerAgent(t *testing.T) {
+	dir := t.TempDir()
+	servers := mcpconfig.Servers{
+		{Name: "on", Command: "npx"},
+		{Name: "off", Command: "npx", Disabled: true},
+	}
+	agents := []string{"copilot", "vscode", "claudecode", "kilocode", "gemini", "codex"}
+	var targets []AgentTarget
+	for _, agent := range agents {
+		targets = append(targets, AgentTarget{Name: agent, PathOverride: filepath.Join(dir, agent, "config")})
+	}
+	result, err := New(targets).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+
+	for _, agent := range []string{"copilot", "vscode", "claudecode"} {
+		output := result.Agents[agent][0]
+		if strings.Contains(output.Content, `"off"`) {
+			t.Errorf("%s should omit the disabled server: %s", agent, output.Content)
+		}
+		if !reflect.DeepEqual(output.Servers, []string{"on"}) {
+			t.Errorf("%s Servers = %v, want [on]", agent, output.Servers)
+		}
+	}
+
+	kilo := result.Agents["kilocode"][0].Content
+	if !strings.Contains(kilo, `"disabled": true`) || strings.Count(kilo, `"disabled"`) != 1 {
+		t.Errorf("kilocode should keep the server with disabled: true: %s", kilo)
+	}
+
+	gemini := result.Agents["gemini"][0].Content
+	if !strings.Contains(gemini, `"excluded": [`) || !strings.Contains(gemini, `"off": {`) {
+		t.Errorf("gemini should list the server and exclude it: %s", gemini)
+	}
+
+	codex := result.Agents["codex"][0].Content
+	if !strings.Contains(codex, "[mcp_servers.off]\ncommand = \"npx\"\nenabled = false") {
+		t.Errorf("codex should render enabled = false: %s", codex)
+	}
+	if strings.Contains(codex, "disabled") {
+		t.Errorf("codex should not render disabled: %s", codex)
+	}
+}
+
+func TestSyncSelectsServersByTagsAndOverrides(t *testing.T) {
+	dir := t.TempDir()
+	servers := mcpconfig.Servers{
+		{Name: "work", Command: "npx", Tags: []string{"work"}},
+		{Name: "beta", Command: "npx", Tags: []string{"work", "beta"}},
+		{Name: "home", Command: "npx", Tags: []string{"personal"}},
+	}
+	target := AgentTarget{
+		Name:         "copilot",
+		PathOverride: filepath.Join(dir, "mcp-config.json"),
+		Tags:         []string{"Work"},
+		ExcludeTags:  []string{"beta"},
+		Overrides:    map[string]map[string]interface{}{"work": {"args": []interface{}{"--fast"}}},
+	}
+
+	result, err := New([]AgentTarget{target}).Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	output := result.Agents["copilot"][0]
+	if output.Err != nil {
+		t.Fatalf("unexpected error: %v", output.Err)
+	}
+	if !reflect.DeepEqual(output.Servers, []string{"work"}) || !reflect.DeepEqual(output.Filtered, []string{"beta", "home"}) {
+		t.Fatalf("unexpected selection: servers %v, filtered %v", output.Servers, output.Filtered)
+	}
+	if !strings.Contains(output.Content, `"--fast"`) {
+		t.Fatalf("expected the override to be applied: %s", output.Content)
+	}
+	if servers[0].Args != nil {
+		t.Fatal("overrides must not modify the shared server list")
+	}
+}
+
+func TestNewDropsOnlyExactDuplicateTargets(t *testing.T) {
+	fast := AgentTarget{Name: "copilot", Overrides: map[string]map[string]interface{}{"work": {"args": []interface{}{"--fast"}}}}
+	targets := []AgentTarget{
+		{Name: "copilot"},
+		fast,
+		{Name: "copilot", Transports: []mcpconfig.Transport{mcpconfig.TransportStdio}},
+		{Name: " Copilot "},
+		fast,
+	}
+	if got := New(targets).Agents; len(got) != 3 {
+		t.Fatalf("expected the targets that differ in their selection to be kept, got %+v", got)
+	}
+
+	// A target that cannot be encoded is kept rather than compared.
+	odd := AgentTarget{Name: "copilot", Overrides: map[string]map[string]interface{}{"work": {"timeout": math.NaN()}}}
+	if got := New([]AgentTarget{odd, odd}).Agents; len(got) != 2 {
+		t.Fatalf("expected both unencodable targets to be kept, got %+v", got)
+	}
+}
+
+func TestRenderUsesTransformerWithoutAnAgentFile(t *testing.T) {
+	servers := mcpconfig.Servers{
+		{Name: "api", Type: "streamable-http", URL: "https://api.test"},
+		{Name: "skip", Command: "npx"},
+	}
+
+	rendered, err := New(nil).Render(AgentTarget{Name: "gemini", DisabledMcpServers: []string{"skip"}}, servers)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if len(rendered.Servers) != 1 || rendered.Servers[0].Fields["httpUrl"] != "https://api.test" {
+		t.Fatalf("expected Gemini fields, got %#v", rendered.Servers)
+	}
+	if !reflect.DeepEqual(rendered.Filtered, []string{"skip"}) {
+		t.Fatalf("Filtered = %v, want [skip]", rendered.Filtered)
+	}
+
+	canonical, err := New(nil).Render(AgentTarget{}, servers)
+	if err != nil || canonical.Servers[0].Fields["url"] != "https://api.test" {
+		t.Fatalf("expected canonical fields, got %#v (err %v)", canonical.Servers, err)
+	}
+
+	if _, err := New(nil).Render(AgentTarget{Name: "unknown"}, servers); err == nil {
+		t.Fatal("expected an error for an unsupported transformer")
+	}
+}
@@ -0,0 +1,192 @@
+package syncer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"agent-align/internal/transforms"
+)
+
+// MergeTOMLTables renders servers under the dotted key path table of the TOML
+// document existing: one table per server or, when nameKey is set, an array
+// of tables with the server name stored under nameKey. Tables at or below
+// that path are replaced and the rest of the document is kept as written: the
+// new tables take the place of the first table removed, or are appended when
+// there was none. When keep is set, the tables of the existing servers it
+// returns true for are left in place as well. An existing document that
+// cannot be parsed is reported as a *ParseError for path.
+func MergeTOMLTables(path string, existing []byte, table []string, servers []transforms.Server, nameKey string, keep func(name string) bool) (string, error) {
+	content := string(existing)
+	if err := checkTOML(content); err != nil {
+		return "", &ParseError{Path: path, Err: err}
+	}
+
+	prefix := make([]string, 0, len(table))
+	for _, key := range table {
+		prefix = append(prefix, tomlKey(key))
+	}
+	sorted := append([]transforms.Server(nil), servers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var sb strings.Builder
+	for _, server := range sorted {
+		if nameKey == "" {
+			sb.WriteString(FormatTOMLServer(table, server))
+			continue
+		}
+		fields := make(map[string]interface{}, len(server.Fields))
+		for k, v := range server.Fields {
+			if k != nameKey {
+				fields[k] = v
+			}
+		}
+		section := strings.Join(prefix, ".")
+		lead := fmt.Sprintf("%s = %s\n", tomlKey(nameKey), tomlString(server.Name))
+		formatTOMLTable(&sb, "[["+section+"]]", lead, section, fields)
+	}
+
+	before, after := splitTOMLTables(content, func(key []string) bool {
+		if !hasKeyPrefix(key, table) {
+			return false
+		}
+		if keep == nil {
+			return true
+		}
+		return len(key) > len(table) && !keep(key[len(table)])
+	})
+	var parts []string
+	for _, part := range []string{before, sb.String(), after} {
+		if part = strings.Trim(part, "\r\n"); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
+
+// FormatTOMLServer renders server as the table MergeTOMLTables writes for it
+// below the key path table.
+func FormatTOMLServer(table []string, server transforms.Server) string {
+	keys := make([]string, 0, len(table)+1)
+	for _, key := range append(append([]string(nil), table...), server.Name) {
+		keys = append(keys, tomlKey(key))
+	}
+	var sb strings.Builder
+	formatServerToTOML(&sb, strings.Join(keys, "."), server.Fields)
+	return sb.String()
+}
+
+// TOMLTables returns the keys that directly follow the key path table in the
+// table headers of content, in document order, and for each of them the text
+// of the tables at or below it.
+func TOMLTables(content string, table []string) ([]string, map[string]string) {
+	var names []string
+	text := make(map[string]string)
+	eachTOMLLine(content, func(key []string, line string) {
+		if key == nil || len(key) <= len(table) || !hasKeyPrefix(key, table) {
+			return
+		}
+		name := key[len(table)]
+		if _, seen := text[name]; !seen {
+			names = append(names, name)
+		}
+		text[name] += line + "\n"
+	})
+	return names, text
+}
+
+// splitTOMLTables removes the tables whose key remove returns true for from
+// content. It returns the content before the first removed table and the
+// remaining content after it; after is empty when nothing was removed.
+func splitTOMLTables(content string, remove func(key []string) bool) (string, string) {
+	var before, after strings.Builder
+	out := &before
+	eachTOMLLine(content, func(key []string, line string) {
+		if key != nil && remove(key) {
+			out = &after
+			return
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	})
+	return before.String(), after.String()
+}
+
+// eachTOMLLine calls fn for every line of content with the key of the table
+// the line belongs to, nil for the lines before the first header. Lines that
+// look like headers inside multiline values do not start a table.
+func eachTOMLLine(content string, fn func(key []string, line string)) {
+	var (
+		key       []string
+		depth     int
+		multiline string
+	)
+	for _, line := range strings.Split(content, "\n") {
+		if multiline == "" && depth == 0 {
+			trimmed := strings.TrimSpace(line)
+			if match := tomlHeaderRe.FindStringSubmatch(trimmed); match != nil {
+				key = splitTOMLKey(match[1])
+			} else if _, value, found := splitTOMLKeyValue(trimmed); found && !strings.HasPrefix(trimmed, "#") {
+				depth, multiline, _ = scanTOMLValue(value, depth, multiline)
+			}
+		} else {
+			depth, multiline, _ = scanTOMLValue(line, depth, multiline)
+		}
+		fn(key, line)
+	}
+}
+
+// splitTOMLKey splits a dotted TOML key into its unquoted parts.
+func splitTOMLKey(key string) []string {
+	var parts []string
+	for rest := strings.TrimSpace(key); rest != ""; {
+		var part string
+		switch rest[0] {
+		case '"':
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(rest))
+			part = rest[:end]
+			if unquoted, err := strconv.Unquote(part); err == nil {
+				part = unquoted
+			}
+			rest = rest[end:]
+		case '\'':
+			end := strings.IndexByte(rest[1:], '\'') + 2
+			if end < 2 {
+				end = len(rest)
+			}
+			part, rest = strings.Trim(rest[:end], "'"), rest[end:]
+		default:
+			end := strings.IndexByte(rest, '.')
+			if end < 0 {
+				end = len(rest)
+			}
+			part, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		parts = append(parts, part)
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ".")
+		rest = strings.TrimSpace(rest)
+	}
+	return parts
+}
+
+func hasKeyPrefix(key, prefix []string) bool {
+	if len(key) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package syncer
+
+import (
+	"errors"
+	"testing"
+
+	"agent-align/internal/transforms"
+)
+
+func TestMergeTOMLTablesMatchesQuotedKeys(t *testing.T) {
+	existing := `[tools."my.mcp".old]
+command = "gone"
+
+[tools.other]
+keep = true
+`
+	servers := []transforms.Server{{Name: "new server", Fields: map[string]interface{}{"command": "npx"}}}
+
+	got, err := MergeTOMLTables("runner.toml", []byte(existing), []string{"tools", "my.mcp"}, servers, "", nil)
+	if err != nil {
+		t.Fatalf("MergeTOMLTables returned error: %v", err)
+	}
+	want := `[tools."my.mcp"."new server"]
+command = "npx"
+
+[tools.other]
+keep = true
+`
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMergeTOMLTablesAppendsAndReportsParseErrors(t *testing.T) {
+	servers := []transforms.Server{{Name: "a", Fields: map[string]interface{}{"command": "npx"}}}
+
+	got, err := MergeTOMLTables("runner.toml", []byte("name = \"runner\"\n"), []string{"mcp"}, servers, "", nil)
+	if err != nil {
+		t.Fatalf("MergeTOMLTables returned error: %v", err)
+	}
+	if want := "name = \"runner\"\n\n[mcp.a]\ncommand = \"npx\"\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	_, err = MergeTOMLTables("runner.toml", []byte("[mcp\n"), []string{"mcp"}, servers, "", nil)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a ParseError, got %v", err)
+	}
+}
+
+func TestMergeTOMLTablesWritesArrayOfTables(t *testing.T) {
+	servers := []transforms.Server{
+		{Name: "b", Fields: map[string]interface{}{"command": "npx", "env": map[string]interface{}{"A": "1"}}},
+		{Name: "a", Fields: map[string]interface{}{"url": "https://a.test"}},
+	}
+
+	got, err := MergeTOMLTables("runner.toml", []byte("[[mcp]]\nname = \"old\"\n"), []string{"mcp"}, servers, "name", nil)
+	if err != nil {
+		t.Fatalf("MergeTOMLTables returned error: %v", err)
+	}
+	want := `[[mcp]]
+name = "a"
+url = "https://a.test"
+
+[[mcp]]
+name = "b"
+command = "npx"
+
+[mcp.env]
+A = "1"
+`
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
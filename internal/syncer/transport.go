@@ -0,0 +1,163 @@
+package syncer
+
+import (
+	"fmt"
+	"strings"
+
+	"agent-align/internal/mcpconfig"
+)
+
+// TransportPolicy selects what happens to a server whose transport an agent
+// does not support.
+type TransportPolicy string
+
+const (
+	// TransportSkip omits the server and logs a warning.
+	TransportSkip TransportPolicy = "skip"
+	// TransportFail fails the agent's target.
+	TransportFail TransportPolicy = "fail"
+	// TransportBridge wraps a remote server in a local stdio bridge command.
+	TransportBridge TransportPolicy = "bridge"
+)
+
+// Bridge describes a stdio command that proxies a remote MCP server.
+type Bridge struct {
+	Command string
+	// Args are passed to Command. Every "{url}" is replaced with the server
+	// URL; the URL is appended when no argument contains the placeholder.
+	Args []string
+	// HeaderFlag precedes each "Name: value" header argument.
+	HeaderFlag string
+	// SSEArgs are appended for servers that use the SSE transport.
+	SSEArgs []string
+}
+
+// DefaultBridge runs mcp-remote through npx.
+var DefaultBridge = Bridge{
+	Command:    "npx",
+	Args:       []string{"-y", "mcp-remote", "{url}"},
+	HeaderFlag: "--header",
+	SSEArgs:    []string{"--transport", "sse-only"},
+}
+
+// adaptTransports applies the target's transport policy to servers the agent
+// cannot connect to. It returns the servers to render and the names of the
+// servers that were skipped. Servers whose transport cannot be resolved are
+// passed through so the transformer reports the problem.
+func (s *Syncer) adaptTransports(cfg AgentConfig, agent AgentTarget, servers mcpconfig.Servers) (mcpconfig.Servers, []string, error) {
+	supported := cfg.Transports
+	if len(agent.Transports) > 0 {
+		supported = agent.Transports
+	}
+	if len(supported) == 0 {
+		return servers, nil, nil
+	}
+
+	policy := agent.UnsupportedTransports
+	if policy == "" {
+		policy = TransportSkip
+	}
+
+	out := make(mcpconfig.Servers, 0, len(servers))
+	var skipped []string
+	for _, spec := range servers {
+		resolved, err := spec.Resolve()
+		if err != nil || supportsTransport(supported, resolved.Transport) {
+			out = append(out, spec)
+			continue
+		}
+
+		switch policy {
+		case TransportFail:
+			return nil, nil, fmt.Errorf("server %q uses the %s transport, which %s does not support", spec.Name, resolved.Transport, cfg.Name)
+		case TransportBridge:
+			if resolved.Transport == mcpconfig.TransportStdio || !supportsTransport(supported, mcpconfig.TransportStdio) {
+				return nil, nil, fmt.Errorf("server %q uses the %s transport, which %s does not support, and cannot be bridged", spec.Name, resolved.Transport, cfg.Name)
+			}
+			bridge := DefaultBridge
+			if agent.Bridge != nil {
+				bridge = *agent.Bridge
+			}
+			for _, name := range mcpconfig.SortedKeys(resolved.Headers) {
+				if _, ok := resolved.HeaderTemplates[name]; !ok && containsSecret(resolved.Headers[name], resolved.Expanded) {
+					s.logger().Printf("warning: the bridge for server %q in %s gets the %s header with a value expanded from the environment in its arguments", spec.Name, cfg.Name, name)
+				}
+			}
+			out = append(out, bridgeServer(resolved, bridge))
+		default:
+			s.logger().Printf("warning: skipping server %q for %s: the %s transport is not supported", spec.Name, cfg.Name, resolved.Transport)
+			skipped = append(skipped, spec.Name)
+		}
+	}
+	return out, skipped, nil
+}
+
+func supportsTransport(supported []mcpconfig.Transport, transport mcpconfig.Transport) bool {
+	for _, t := range supported {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
+
+// containsSecret reports whether value holds any of the expanded secrets.
+func containsSecret(value string, secrets []string) bool {
+	for _, secret := range secrets {
+		if strings.Contains(value, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// bridgeServer turns a remote server into a stdio server that runs the bridge
+// command. Headers become bridge arguments; a header that referenced
+// environment variables keeps them as ${VAR}, for the bridge to expand, and
+// the variables are added to env so no secret ends up in the arguments.
+// Env, timeouts and extra keys are carried over otherwise unchanged.
+func bridgeServer(spec mcpconfig.ServerSpec, bridge Bridge) mcpconfig.ServerSpec {
+	out := spec.Clone()
+	out.Type = ""
+	out.Transport = mcpconfig.TransportStdio
+	out.Command = bridge.Command
+	out.URL = ""
+	out.Headers = nil
+	out.HeaderTemplates = nil
+
+	args := make([]string, 0, len(bridge.Args)+2*len(spec.Headers)+len(bridge.SSEArgs)+1)
+	hasURL := false
+	for _, arg := range bridge.Args {
+		if strings.Contains(arg, "{url}") {
+			hasURL = true
+			arg = strings.ReplaceAll(arg, "{url}", spec.URL)
+		}
+		args = append(args, arg)
+	}
+	if !hasURL {
+		args = append(args, spec.URL)
+	}
+	for _, name := range mcpconfig.SortedKeys(spec.Headers) {
+		value := spec.Headers[name]
+		if template, ok := spec.HeaderTemplates[name]; ok {
+			var vars map[string]string
+			value, vars = mcpconfig.BraceEnv(template)
+			if out.Env == nil && len(vars) > 0 {
+				out.Env = make(map[string]string, len(vars))
+			}
+			for variable, resolved := range vars {
+				out.Env[variable] = resolved
+			}
+		}
+		header := fmt.Sprintf("%s: %s", name, value)
+		if bridge.HeaderFlag != "" {
+			args = append(args, bridge.HeaderFlag)
+		}
+		args = append(args, header)
+	}
+	if spec.Transport == mcpconfig.TransportSSE {
+		args = append(args, bridge.SSEArgs...)
+	}
+	out.Args = args
+	return out
+}
@@ -0,0 +1,137 @@
+package syncer
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"agent-align/internal/mcpconfig"
+)
+
+type recordingLogger struct{ lines []string }
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func transportServers() mcpconfig.Servers {
+	return mcpconfig.Servers{
+		{Name: "local", Command: "npx"},
+		{
+			Name:      "events",
+			Type:      "sse",
+			Transport: mcpconfig.TransportSSE,
+			URL:       "https://example.test/sse",
+			Headers:   map[string]string{"Authorization": "Bearer token"},
+			Env:       map[string]string{"DEBUG": "1"},
+		},
+	}
+}
+
+func TestSyncSkipsUnsupportedTransports(t *testing.T) {
+	logger := &recordingLogger{}
+	s := New([]AgentTarget{{Name: "codex", PathOverride: filepath.Join(t.TempDir(), "config.toml")}})
+	s.Logger = logger
+
+	result, err := s.Sync(transportServers())
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	codex := result.Agents["codex"][0]
+	if codex.Err != nil {
+		t.Fatalf("skipping should not fail the agent: %v", codex.Err)
+	}
+	if !reflect.DeepEqual(codex.Skipped, []string{"events"}) || !reflect.DeepEqual(codex.Servers, []string{"local"}) {
+		t.Fatalf("unexpected servers %v / skipped %v", codex.Servers, codex.Skipped)
+	}
+	if strings.Contains(codex.Content, "events") {
+		t.Fatalf("skipped server should not be rendered:\n%s", codex.Content)
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], `skipping server "events" for codex`) {
+		t.Fatalf("expected a warning, got %v", logger.lines)
+	}
+}
+
+func TestSyncFailsOnUnsupportedTransports(t *testing.T) {
+	s := New([]AgentTarget{{
+		Name:                  "vscode",
+		PathOverride:          filepath.Join(t.TempDir(), "mcp.json"),
+		Transports:            []mcpconfig.Transport{mcpconfig.TransportStdio, mcpconfig.TransportHTTP},
+		UnsupportedTransports: TransportFail,
+	}})
+	result, err := s.Sync(transportServers())
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	if got := result.Agents["vscode"][0].Err; got == nil || !strings.Contains(got.Error(), "sse transport") {
+		t.Fatalf("expected transport error, got %v", got)
+	}
+}
+
+func TestSyncBridgesUnsupportedTransports(t *testing.T) {
+	t.Setenv("API_TOKEN", "s3cret")
+	servers, err := mcpconfig.Parse("servers.yml", []byte("servers:\n  api:\n    type: sse\n    url: https://example.test/api\n    headers:\n      Authorization: Bearer ${API_TOKEN:-none}\n"))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	servers = append(transportServers(), append(servers, mcpconfig.ServerSpec{
+		Name:     "literal",
+		Type:     "sse",
+		URL:      "https://example.test/literal",
+		Headers:  map[string]string{"X-Key": "s3cret"},
+		Expanded: []string{"s3cret"},
+	})...)
+	logger := &recordingLogger{}
+	s := New([]AgentTarget{{
+		Name:                  "codex",
+		PathOverride:          filepath.Join(t.TempDir(), "config.toml"),
+		UnsupportedTransports: TransportBridge,
+	}})
+	s.Logger = logger
+	result, err := s.Sync(servers)
+	if err != nil {
+		t.Fatalf("Sync returned error: %v", err)
+	}
+	content := result.Agents["codex"][0].Content
+	for _, want := range []string{`[mcp_servers.events]
+args = ["-y", "mcp-remote", "https://example.test/sse", "--header", "Authorization: Bearer token", "--transport", "sse-only"]
+command = "npx"
+env = { DEBUG = "1" }
+`, `[mcp_servers.api]
+args = ["-y", "mcp-remote", "https://example.test/api", "--header", "Authorization: Bearer ${API_TOKEN}", "--transport", "sse-only"]
+command = "npx"
+env = { API_TOKEN = "s3cret" }
+`} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("expected bridged server %q, got:\n%s", want, content)
+		}
+	}
+	// A header expanded without a template cannot be referenced; its
+	// secret is written with a warning.
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], `server "literal"`) {
+		t.Fatalf("expected a warning about the literal header only, got %v", logger.lines)
+	}
+}
+
+func TestBridgeServerAppendsURLWithoutPlaceholder(t *testing.T) {
+	spec := mcpconfig.ServerSpec{
+		Name:      "remote",
+		Transport: mcpconfig.TransportHTTP,
+		URL:       "https://example.test/mcp",
+		Headers:   map[string]string{"X-B": "2", "X-A": "1"},
+	}
+	got := bridgeServer(spec, Bridge{Command: "mcp-proxy", HeaderFlag: "-H", SSEArgs: []string{"--sse"}})
+
+	wantArgs := []string{"https://example.test/mcp", "-H", "X-A: 1", "-H", "X-B: 2"}
+	if got.Command != "mcp-proxy" || !reflect.DeepEqual(got.Args, wantArgs) {
+		t.Fatalf("unexpected bridge command: %s %v", got.Command, got.Args)
+	}
+	if got.Transport != mcpconfig.TransportStdio || got.URL != "" || got.Headers != nil {
+		t.Fatalf("bridged server should be a plain stdio server: %#v", got)
+	}
+	if spec.URL == "" {
+		t.Fatal("input spec should not be modified")
+	}
+}
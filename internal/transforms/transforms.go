@@ -1,16 +1,31 @@
 package transforms
 
 import (
-	"fmt"
+	"regexp"
 	"strings"
+
+	"agent-align/internal/mcpconfig"
 )
 
+// Server is a single server entry rendered in an agent's native shape.
+type Server struct {
+	Name   string
+	Fields map[string]interface{}
+	// Disabled marks a server the agent should keep in its config but not
+	// start. Formatters record it in the agent's own exclusion setting.
+	Disabled bool
+	// AutoApprove lists the tools the agent may call without asking. A single
+	// "*" entry approves every tool of the server.
+	AutoApprove []string
+}
+
 // Transformer defines the interface for destination-specific transformations.
-// Each target agent can have its own transformer that manipulates server
-// configurations before they are written.
+// Each target agent can have its own transformer that validates the canonical
+// server definitions and renders them in the shape the agent expects.
 type Transformer interface {
-	// Transform modifies servers in place and returns an error if validation fails.
-	Transform(servers map[string]interface{}) error
+	// Transform renders servers for the agent and returns an error if
+	// validation fails. The provided servers are not modified.
+	Transform(servers mcpconfig.Servers) ([]Server, error)
 }
 
 // GetTransformer returns the appropriate transformer for a given agent.
@@ -25,68 +40,98 @@ func GetTransformer(agent string) Transformer {
 		return &CodexTransformer{}
 	case "gemini":
 		return &GeminiTransformer{}
+	case "vscode":
+		return &VSCodeTransformer{}
+	case "kilocode":
+		return &KilocodeTransformer{}
 	default:
 		return &NoOpTransformer{}
 	}
 }
 
-// NoOpTransformer performs no transformations.
-type NoOpTransformer struct{}
-
-// Transform returns nil without modifying servers.
-func (t *NoOpTransformer) Transform(servers map[string]interface{}) error {
-	return nil
+// render applies fn to every resolved server and collects the rendered entries.
+func render(servers mcpconfig.Servers, fn func(spec mcpconfig.ServerSpec) (map[string]interface{}, error)) ([]Server, error) {
+	return renderServers(servers, func(spec mcpconfig.ServerSpec) (Server, error) {
+		fields, err := fn(spec)
+		if err != nil {
+			return Server{}, err
+		}
+		return Server{Name: spec.Name, Fields: fields}, nil
+	})
 }
 
-// CopilotTransformer handles Copilot-specific transformations and validations.
-type CopilotTransformer struct{}
-
-// Transform applies Copilot-specific modifications:
-// - Adds an empty "tools" array to every server if not present
-// - Normalizes network transport types to the values Copilot expects
-// - Validates that network-based servers have both "type" and "url" fields
-func (t *CopilotTransformer) Transform(servers map[string]interface{}) error {
-	for name, serverRaw := range servers {
-		server, ok := serverRaw.(map[string]interface{})
-		if !ok {
-			continue
+// renderServers resolves every server's transport, validates its required
+// fields, and passes a private copy to fn.
+func renderServers(servers mcpconfig.Servers, fn func(spec mcpconfig.ServerSpec) (Server, error)) ([]Server, error) {
+	out := make([]Server, 0, len(servers))
+	for _, spec := range servers {
+		resolved, err := spec.Resolve()
+		if err != nil {
+			return nil, err
 		}
-
-		if err := t.transformServer(name, server); err != nil {
-			return err
+		server, err := fn(resolved)
+		if err != nil {
+			return nil, err
 		}
+		out = append(out, server)
 	}
-	return nil
+	return out, nil
 }
 
-// transformServer applies transformations to a single server configuration.
-func (t *CopilotTransformer) transformServer(name string, server map[string]interface{}) error {
-	addToolsArrayIfMissing(server)
-
-	if typ, ok := server["type"].(string); ok {
-		switch strings.ToLower(strings.TrimSpace(typ)) {
-		case "stdio":
-			server["type"] = "local"
-		case "streamable-http":
-			server["type"] = "http"
+// enabledOnly drops disabled servers for agents that cannot keep a server
+// listed but switched off.
+func enabledOnly(servers mcpconfig.Servers) mcpconfig.Servers {
+	out := make(mcpconfig.Servers, 0, len(servers))
+	for _, spec := range servers {
+		if !spec.Disabled {
+			out = append(out, spec)
 		}
 	}
+	return out
+}
 
-	if isNetworkServer(server) {
-		if err := validateNetworkServer(name, server); err != nil {
-			return err
-		}
-	}
+// NoOpTransformer renders servers with their canonical fields.
+type NoOpTransformer struct{}
+
+// Transform returns the canonical fields of every server.
+func (t *NoOpTransformer) Transform(servers mcpconfig.Servers) ([]Server, error) {
+	return render(servers, func(spec mcpconfig.ServerSpec) (map[string]interface{}, error) {
+		return spec.Fields(), nil
+	})
+}
+
+// transportTypes maps each transport to the type spelling an agent expects.
+type transportTypes map[mcpconfig.Transport]string
 
-	return nil
+// apply sets spec.Type to the agent's spelling of its transport.
+func (types transportTypes) apply(spec *mcpconfig.ServerSpec) {
+	spec.Type = types[spec.Transport]
 }
 
-// isNetworkServer returns true if the server appears to be a network-based server.
-// A network-based server has either "type" or "url" field (or both).
-func isNetworkServer(server map[string]interface{}) bool {
-	_, hasType := server["type"]
-	_, hasURL := server["url"]
-	return hasType || hasURL
+var (
+	copilotTypes  = transportTypes{mcpconfig.TransportStdio: "local", mcpconfig.TransportHTTP: "http", mcpconfig.TransportSSE: "sse"}
+	vscodeTypes   = transportTypes{mcpconfig.TransportStdio: "stdio", mcpconfig.TransportHTTP: "http", mcpconfig.TransportSSE: "sse"}
+	claudeTypes   = transportTypes{mcpconfig.TransportStdio: "stdio", mcpconfig.TransportHTTP: "http", mcpconfig.TransportSSE: "sse"}
+	kilocodeTypes = transportTypes{mcpconfig.TransportStdio: "stdio", mcpconfig.TransportHTTP: "streamable-http", mcpconfig.TransportSSE: "sse"}
+)
+
+// CopilotTransformer handles Copilot-specific transformations.
+type CopilotTransformer struct{}
+
+// Transform applies Copilot-specific modifications:
+// - Adds an empty "tools" array to every server if not present
+// - Writes the transport as "local", "http" or "sse"
+// - Omits disabled servers, since Copilot cannot switch a server off
+func (t *CopilotTransformer) Transform(servers mcpconfig.Servers) ([]Server, error) {
+	return render(enabledOnly(servers), t.transformServer)
+}
+
+// transformServer applies transformations to a single server configuration.
+func (t *CopilotTransformer) transformServer(spec mcpconfig.ServerSpec) (map[string]interface{}, error) {
+	copilotTypes.apply(&spec)
+	fields := spec.Fields()
+	addToolsArrayIfMissing(fields)
+	return fields, nil
 }
 
 // addToolsArrayIfMissing adds an empty "tools" array to the server if not present.
@@ -96,123 +141,257 @@ func addToolsArrayIfMissing(server map[string]interface{}) {
 	}
 }
 
-// validateNetworkServer ensures that network-based servers have both "type" and "url" fields.
-func validateNetworkServer(name string, server map[string]interface{}) error {
-	rawType, hasType := server["type"]
-	_, hasURL := server["url"]
-
-	if !hasType && !hasURL {
-		// Not a network server, nothing to validate
-		return nil
-	}
-
-	if hasType {
-		if t, ok := rawType.(string); ok {
-			if strings.EqualFold(strings.TrimSpace(t), "local") {
-				// Copilot local transports do not require a URL.
-				return nil
-			}
-		}
-	}
-
-	var missing []string
-	if !hasType {
-		missing = append(missing, "type")
-	}
-	if !hasURL {
-		missing = append(missing, "url")
-	}
+// VSCodeTransformer writes the "type" field VS Code requires on every server.
+type VSCodeTransformer struct{}
+
+// Transform sets type to "stdio", "http" or "sse" on every server. Disabled
+// servers are omitted: mcp.json has no per-server switch, as VS Code keeps
+// whether a server is enabled in its own state rather than in the file.
+func (t *VSCodeTransformer) Transform(servers mcpconfig.Servers) ([]Server, error) {
+	return render(enabledOnly(servers), func(spec mcpconfig.ServerSpec) (map[string]interface{}, error) {
+		vscodeTypes.apply(&spec)
+		return spec.Fields(), nil
+	})
+}
 
-	if len(missing) > 0 {
-		return fmt.Errorf("copilot validation error: network-based server %q is missing required field(s): %s. Network servers must have both 'type' and 'url' fields",
-			name, strings.Join(missing, ", "))
-	}
+// KilocodeTransformer writes Kilo Code's transport names.
+type KilocodeTransformer struct{}
 
-	return nil
+// Transform sets type to "stdio", "streamable-http" or "sse" on every server.
+// Disabled servers keep their entry with "disabled": true.
+func (t *KilocodeTransformer) Transform(servers mcpconfig.Servers) ([]Server, error) {
+	return render(servers, func(spec mcpconfig.ServerSpec) (map[string]interface{}, error) {
+		kilocodeTypes.apply(&spec)
+		return spec.Fields(), nil
+	})
 }
 
 // CodexTransformer applies Codex-specific conversions.
 type CodexTransformer struct{}
 
-// Transform converts GitHub Authorization headers into the env var token expected by Codex.
-func (t *CodexTransformer) Transform(servers map[string]interface{}) error {
-	githubRaw, ok := servers["github"]
-	if !ok {
-		return nil
-	}
+// codexGithubTokenEnvVar is used for the github server when its Authorization
+// header holds a literal token rather than an environment variable reference.
+const codexGithubTokenEnvVar = "CODEX_GITHUB_PERSONAL_ACCESS_TOKEN"
 
-	server, ok := githubRaw.(map[string]interface{})
-	if !ok {
-		return nil
-	}
+var (
+	bearerEnvPattern = regexp.MustCompile(`^Bearer\s+\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))$`)
+	envRefPattern    = regexp.MustCompile(`^\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))$`)
+)
 
-	headers, hasHeaders := server["headers"].(map[string]interface{})
-	if !hasHeaders {
-		return nil
-	}
+// Transform maps canonical servers onto the Codex MCP schema:
+// - "type" is dropped; Codex picks stdio or streamable HTTP from command or url
+// - "Authorization: Bearer ${VAR}" becomes bearer_token_env_var = "VAR"
+// - headers whose whole value is ${VAR} go to env_http_headers
+// - all other headers go to http_headers
+// - the millisecond "timeout" becomes tool_timeout_sec unless that is set
+// - a disabled server is written with enabled = false
+// - "envVars" becomes "env_vars"
+//
+// A bearer_token_env_var set on the server overrides the detected name.
+func (t *CodexTransformer) Transform(servers mcpconfig.Servers) ([]Server, error) {
+	return render(servers, func(spec mcpconfig.ServerSpec) (map[string]interface{}, error) {
+		spec.Type = ""
+		convertCodexHeaders(&spec)
+		if spec.ToolTimeoutSec == 0 && spec.Timeout > 0 {
+			spec.ToolTimeoutSec = (spec.Timeout + 999) / 1000
+		}
+		spec.Timeout = 0
+		if spec.Disabled {
+			spec.Disabled = false
+			if spec.Extra == nil {
+				spec.Extra = make(map[string]interface{})
+			}
+			spec.Extra["enabled"] = false
+		}
+		if envVars, ok := spec.Extra["envVars"]; ok {
+			delete(spec.Extra, "envVars")
+			if _, hasEnvVars := spec.Extra["env_vars"]; !hasEnvVars {
+				spec.Extra["env_vars"] = envVars
+			}
+		}
+		return spec.Fields(), nil
+	})
+}
 
-	if _, hasEnv := server["bearer_token_env_var"]; hasEnv {
-		delete(headers, "Authorization")
-		if len(headers) == 0 {
-			delete(server, "headers")
+// convertCodexHeaders moves spec.Headers into the Codex header keys in Extra.
+func convertCodexHeaders(spec *mcpconfig.ServerSpec) {
+	if len(spec.Headers) == 0 {
+		spec.Headers = nil
+		return
+	}
+	if spec.Extra == nil {
+		spec.Extra = make(map[string]interface{})
+	}
+	_, hasTokenVar := spec.Extra["bearer_token_env_var"]
+
+	httpHeaders := make(map[string]string)
+	envHeaders := make(map[string]string)
+	for _, key := range mcpconfig.SortedKeys(spec.Headers) {
+		template := spec.HeaderTemplates[key]
+		if strings.EqualFold(key, "Authorization") {
+			if hasTokenVar {
+				continue
+			}
+			if name, ok := matchEnvRef(bearerEnvPattern, template); ok {
+				spec.Extra["bearer_token_env_var"] = name
+				hasTokenVar = true
+				continue
+			}
+			if spec.Name == "github" {
+				spec.Extra["bearer_token_env_var"] = codexGithubTokenEnvVar
+				hasTokenVar = true
+				continue
+			}
 		}
-		return nil
+		if name, ok := matchEnvRef(envRefPattern, template); ok {
+			envHeaders[key] = name
+			continue
+		}
+		httpHeaders[key] = spec.Headers[key]
 	}
 
-	if _, hasAuth := headers["Authorization"]; !hasAuth {
-		return nil
+	spec.Headers = nil
+	if len(httpHeaders) > 0 {
+		spec.Extra["http_headers"] = httpHeaders
+	}
+	if len(envHeaders) > 0 {
+		spec.Extra["env_http_headers"] = envHeaders
 	}
+}
 
-	server["bearer_token_env_var"] = "CODEX_GITHUB_PERSONAL_ACCESS_TOKEN"
-	delete(headers, "Authorization")
-	if len(headers) == 0 {
-		delete(server, "headers")
+// matchEnvRef returns the variable name captured by pattern, which matches
+// both the ${VAR} and $VAR spellings.
+func matchEnvRef(pattern *regexp.Regexp, value string) (string, bool) {
+	m := pattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return "", false
+	}
+	if m[1] != "" {
+		return m[1], true
 	}
-	return nil
+	return m[2], true
 }
 
-// ClaudeTransformer applies minimal Claude-specific conversions. Currently it
-// normalizes legacy transport names like "streamable-http" to "http" so that
-// Claude configs use the simpler transport type.
+// ClaudeTransformer applies Claude-specific conversions. It writes the
+// transport as "stdio", "http" or "sse" and moves the alwaysAllow
+// and autoApprove keys, which Claude ignores in ~/.claude.json, into
+// AutoApprove so they can be written as permission rules.
 type ClaudeTransformer struct{}
 
-// Transform applies Claude-specific normalizations.
-func (t *ClaudeTransformer) Transform(servers map[string]interface{}) error {
-	for _, serverRaw := range servers {
-		server, ok := serverRaw.(map[string]interface{})
-		if !ok {
-			continue
+// Transform applies Claude-specific normalizations. Disabled servers are
+// marked Disabled: Claude can switch off the servers of a project's .mcp.json
+// through disabledMcpjsonServers in the project settings, but ~/.claude.json
+// has no per-server switch, so the syncer omits them there.
+func (t *ClaudeTransformer) Transform(servers mcpconfig.Servers) ([]Server, error) {
+	return renderServers(servers, func(spec mcpconfig.ServerSpec) (Server, error) {
+		disabled := spec.Disabled
+		spec.Disabled = false
+		claudeTypes.apply(&spec)
+		approved := approvedTools(spec.Extra)
+		delete(spec.Extra, "alwaysAllow")
+		delete(spec.Extra, "autoApprove")
+		return Server{Name: spec.Name, Fields: spec.Fields(), AutoApprove: approved, Disabled: disabled}, nil
+	})
+}
+
+// approvedTools collects the tools approved through alwaysAllow and
+// autoApprove. autoApprove: true approves every tool and is returned as "*".
+func approvedTools(extra map[string]interface{}) []string {
+	var tools []string
+	seen := make(map[string]struct{})
+	add := func(value interface{}) {
+		name, ok := value.(string)
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return
 		}
+		if _, dup := seen[name]; dup {
+			return
+		}
+		seen[name] = struct{}{}
+		tools = append(tools, name)
+	}
 
-		if typ, ok := server["type"].(string); ok {
-			switch strings.ToLower(strings.TrimSpace(typ)) {
-			case "streamable-http":
-				server["type"] = "http"
-			}
+	switch v := extra["autoApprove"].(type) {
+	case bool:
+		if v {
+			add("*")
+		}
+	case []interface{}:
+		for _, item := range v {
+			add(item)
 		}
 	}
-	return nil
+	if list, ok := extra["alwaysAllow"].([]interface{}); ok {
+		for _, item := range list {
+			add(item)
+		}
+	}
+
+	if _, all := seen["*"]; all {
+		return []string{"*"}
+	}
+	return tools
 }
 
-// GeminiTransformer removes fields that are not supported by Gemini's enhanced
-// MCP JSON config validation. As of recent Gemini updates, the validator rejects
-// configs that contain autoApprove, disabled, gallery, or type fields. These
-// fields must be removed before writing to ensure compatibility with Gemini.
+// GeminiTransformer translates canonical fields into the keys Gemini's MCP
+// settings understand. Gemini's validator rejects type, autoApprove, disabled
+// and gallery, so those are mapped onto Gemini's own keys instead:
+// - streamable HTTP servers use httpUrl; SSE servers keep url
+// - autoApprove: true becomes trust: true; autoApprove and alwaysAllow lists are dropped
+// - tools and disabledTools become includeTools and excludeTools
+// - disabled servers are marked Disabled so they are listed in mcp.excluded
+//
+// timeout (milliseconds) and cwd are passed through unchanged.
 type GeminiTransformer struct{}
 
-// Transform removes unsupported fields from all server configurations.
-func (t *GeminiTransformer) Transform(servers map[string]interface{}) error {
-	for _, serverRaw := range servers {
-		server, ok := serverRaw.(map[string]interface{})
-		if !ok {
-			continue
+// Transform renders every server for Gemini.
+func (t *GeminiTransformer) Transform(servers mcpconfig.Servers) ([]Server, error) {
+	return renderServers(servers, func(spec mcpconfig.ServerSpec) (Server, error) {
+		disabled := spec.Disabled
+		spec.Disabled = false
+		if approve, ok := spec.Extra["autoApprove"].(bool); ok && approve {
+			if _, hasTrust := spec.Extra["trust"]; !hasTrust {
+				spec.Extra["trust"] = true
+			}
 		}
+		renameToolList(spec.Extra, "tools", "includeTools")
+		renameToolList(spec.Extra, "disabledTools", "excludeTools")
+		delete(spec.Extra, "autoApprove")
+		delete(spec.Extra, "alwaysAllow")
+		delete(spec.Extra, "gallery")
+
+		transport := spec.Transport
+		spec.Type = ""
+		fields := spec.Fields()
+		if transport == mcpconfig.TransportHTTP && spec.URL != "" {
+			delete(fields, "url")
+			fields["httpUrl"] = spec.URL
+		}
+		return Server{Name: spec.Name, Fields: fields, Disabled: disabled}, nil
+	})
+}
 
-		// Remove fields that Gemini does not support
-		delete(server, "autoApprove")
-		delete(server, "disabled")
-		delete(server, "gallery")
-		delete(server, "type")
+// renameToolList moves a tool name list from one key to another. A list that
+// contains "*" allows every tool and is dropped. An existing value under the
+// destination key wins.
+func renameToolList(extra map[string]interface{}, from, to string) {
+	value, ok := extra[from]
+	if !ok {
+		return
+	}
+	delete(extra, from)
+	if _, exists := extra[to]; exists {
+		return
+	}
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return
+	}
+	for _, item := range list {
+		if item == "*" {
+			return
+		}
 	}
-	return nil
+	extra[to] = list
 }
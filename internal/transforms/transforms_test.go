@@ -1,8 +1,11 @@
 package transforms
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	"agent-align/internal/mcpconfig"
 )
 
 func TestGetTransformer(t *testing.T) {
@@ -48,31 +51,67 @@ func TestGetTransformer(t *testing.T) {
 	}
 }
 
+// renderedByName indexes rendered servers by name for assertions.
+func renderedByName(servers []Server) map[string]map[string]interface{} {
+	out := make(map[string]map[string]interface{}, len(servers))
+	for _, server := range servers {
+		out[server.Name] = server.Fields
+	}
+	return out
+}
+
+func spec(name string, fields map[string]interface{}) mcpconfig.ServerSpec {
+	s := mcpconfig.ServerSpec{Name: name, Extra: map[string]interface{}{}}
+	for k, v := range fields {
+		switch k {
+		case "type":
+			s.Type = v.(string)
+			s.Transport = mcpconfig.ParseTransport(s.Type)
+		case "command":
+			s.Command = v.(string)
+		case "url":
+			s.URL = v.(string)
+		case "headers":
+			s.Headers = v.(map[string]string)
+		case "env":
+			s.Env = v.(map[string]string)
+		case "args":
+			s.Args = v.([]string)
+		case "disabled":
+			s.Disabled = v.(bool)
+		default:
+			s.Extra[k] = v
+		}
+	}
+	return s
+}
+
 func TestCopilotTransformer_AddsToolsAndNormalizesTypes(t *testing.T) {
 	transformer := &CopilotTransformer{}
-	servers := map[string]interface{}{
-		"command": map[string]interface{}{
+	servers := mcpconfig.Servers{
+		spec("command", map[string]interface{}{
 			"command": "npx",
-		},
-		"network-stdio": map[string]interface{}{
-			"type": "stdio",
-			"url":  "http://example.test",
-		},
-		"network-stream": map[string]interface{}{
+		}),
+		spec("network-stdio", map[string]interface{}{
+			"type":    "stdio",
+			"command": "uvx",
+		}),
+		spec("network-stream", map[string]interface{}{
 			"type": "streamable-http",
 			"url":  "http://example.test",
 			"tools": []interface{}{
 				"kept",
 			},
-		},
+		}),
 	}
 
-	if err := transformer.Transform(servers); err != nil {
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	got := renderedByName(rendered)
 
-	for name, serverRaw := range servers {
-		server := serverRaw.(map[string]interface{})
+	for name, server := range got {
 		tools, ok := server["tools"]
 		if !ok {
 			t.Fatalf("server %s missing tools array", name)
@@ -82,45 +121,114 @@ func TestCopilotTransformer_AddsToolsAndNormalizesTypes(t *testing.T) {
 		}
 	}
 
-	if servers["network-stdio"].(map[string]interface{})["type"] != "local" {
-		t.Errorf("expected stdio to be normalized to local, got %v", servers["network-stdio"].(map[string]interface{})["type"])
+	if got["network-stdio"]["type"] != "local" {
+		t.Errorf("expected stdio to be normalized to local, got %v", got["network-stdio"]["type"])
+	}
+	if got["network-stream"]["type"] != "http" {
+		t.Errorf("expected streamable-http to be normalized to http, got %v", got["network-stream"]["type"])
 	}
-	if servers["network-stream"].(map[string]interface{})["type"] != "http" {
-		t.Errorf("expected streamable-http to be normalized to http, got %v", servers["network-stream"].(map[string]interface{})["type"])
+	if servers[1].Type != "stdio" {
+		t.Errorf("input servers should not be modified, got type %q", servers[1].Type)
 	}
 }
 
 func TestClaudeTransformer_NormalizesTypes(t *testing.T) {
 	transformer := &ClaudeTransformer{}
-	servers := map[string]interface{}{
-		"network-stream": map[string]interface{}{
+	servers := mcpconfig.Servers{
+		spec("network-stream", map[string]interface{}{
 			"type": "streamable-http",
 			"url":  "http://example.test",
-		},
-		"command": map[string]interface{}{
+		}),
+		spec("command", map[string]interface{}{
 			"command": "npx",
-		},
+		}),
 	}
 
-	if err := transformer.Transform(servers); err != nil {
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if servers["network-stream"].(map[string]interface{})["type"] != "http" {
-		t.Errorf("expected streamable-http to be normalized to http, got %v", servers["network-stream"].(map[string]interface{})["type"])
+	if got := renderedByName(rendered)["network-stream"]["type"]; got != "http" {
+		t.Errorf("expected streamable-http to be normalized to http, got %v", got)
+	}
+}
+
+func TestDisabledServersForClaudeAndVSCode(t *testing.T) {
+	servers := mcpconfig.Servers{
+		spec("on", map[string]interface{}{"command": "npx"}),
+		spec("off", map[string]interface{}{"command": "npx", "disabled": true}),
+	}
+
+	claude, err := (&ClaudeTransformer{}).Transform(servers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(claude) != 2 || claude[0].Disabled || !claude[1].Disabled {
+		t.Fatalf("claude should flag the disabled server: %+v", claude)
+	}
+	if _, ok := claude[1].Fields["disabled"]; ok {
+		t.Fatalf("claude should not write a disabled field: %v", claude[1].Fields)
+	}
+
+	vscode, err := (&VSCodeTransformer{}).Transform(servers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vscode) != 1 || vscode[0].Name != "on" {
+		t.Fatalf("vscode has no per-server switch and should omit the server: %+v", vscode)
+	}
+}
+
+func TestClaudeTransformer_MovesApprovals(t *testing.T) {
+	transformer := &ClaudeTransformer{}
+	servers := mcpconfig.Servers{
+		spec("listed", map[string]interface{}{
+			"command":     "npx",
+			"alwaysAllow": []interface{}{"search", "fetch"},
+			"autoApprove": []interface{}{"search", "read"},
+		}),
+		spec("trusted", map[string]interface{}{
+			"command":     "npx",
+			"alwaysAllow": []interface{}{"search"},
+			"autoApprove": true,
+		}),
+		spec("plain", map[string]interface{}{"command": "npx"}),
+	}
+
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string][]string{
+		"listed":  {"search", "read", "fetch"},
+		"trusted": {"*"},
+		"plain":   nil,
+	}
+	for _, server := range rendered {
+		if !reflect.DeepEqual(server.AutoApprove, want[server.Name]) {
+			t.Errorf("%s: AutoApprove = %v, want %v", server.Name, server.AutoApprove, want[server.Name])
+		}
+		if _, ok := server.Fields["alwaysAllow"]; ok {
+			t.Errorf("%s: alwaysAllow should not be rendered", server.Name)
+		}
+		if _, ok := server.Fields["autoApprove"]; ok {
+			t.Errorf("%s: autoApprove should not be rendered", server.Name)
+		}
 	}
 }
 
 func TestCopilotTransformer_Validation(t *testing.T) {
 	transformer := &CopilotTransformer{}
 	t.Run("missing url for http", func(t *testing.T) {
-		servers := map[string]interface{}{
-			"broken": map[string]interface{}{
+		servers := mcpconfig.Servers{
+			spec("broken", map[string]interface{}{
 				"type": "http",
-			},
+			}),
 		}
 
-		err := transformer.Transform(servers)
+		_, err := transformer.Transform(servers)
 		if err == nil {
 			t.Fatal("expected validation error for missing url")
 		}
@@ -130,114 +238,158 @@ func TestCopilotTransformer_Validation(t *testing.T) {
 	})
 
 	t.Run("local without url allowed", func(t *testing.T) {
-		servers := map[string]interface{}{
-			"local-server": map[string]interface{}{
-				"type": "local",
-			},
+		servers := mcpconfig.Servers{
+			spec("local-server", map[string]interface{}{
+				"type":    "local",
+				"command": "npx",
+			}),
 		}
-		if err := transformer.Transform(servers); err != nil {
+		if _, err := transformer.Transform(servers); err != nil {
 			t.Fatalf("expected local transport without url to pass, got %v", err)
 		}
 	})
 }
 
-func TestCopilotTransformer_NonMapServer(t *testing.T) {
+func TestCopilotTransformer_KeepsExtraFields(t *testing.T) {
 	transformer := &CopilotTransformer{}
-	servers := map[string]interface{}{
-		"invalid": "oops",
-		"valid": map[string]interface{}{
-			"type": "http",
-			"url":  "https://example.test",
-		},
+	servers := mcpconfig.Servers{
+		spec("valid", map[string]interface{}{
+			"type":    "http",
+			"url":     "https://example.test",
+			"timeout": 1000000,
+		}),
 	}
 
-	if err := transformer.Transform(servers); err != nil {
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if _, ok := servers["valid"].(map[string]interface{})["tools"]; !ok {
+	valid := renderedByName(rendered)["valid"]
+	if _, ok := valid["tools"]; !ok {
 		t.Fatalf("valid server missing tools array")
 	}
-}
-
-func TestIsNetworkServer(t *testing.T) {
-	tests := []struct {
-		name   string
-		server map[string]interface{}
-		want   bool
-	}{
-		{"type only", map[string]interface{}{"type": "http"}, true},
-		{"url only", map[string]interface{}{"url": "https://example.test"}, true},
-		{"both", map[string]interface{}{"type": "http", "url": "https://example.test"}, true},
-		{"command", map[string]interface{}{"command": "npx"}, false},
-		{"empty", map[string]interface{}{}, false},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := isNetworkServer(tt.server); got != tt.want {
-				t.Fatalf("isNetworkServer() = %v, want %v", got, tt.want)
-			}
-		})
+	if valid["timeout"] != 1000000 {
+		t.Fatalf("expected extra timeout to be kept as an int, got %#v", valid["timeout"])
 	}
 }
 
 func TestCodexTransformerGithubToken(t *testing.T) {
 	transformer := &CodexTransformer{}
-	servers := map[string]interface{}{
-		"github": map[string]interface{}{
+	servers := mcpconfig.Servers{
+		spec("github", map[string]interface{}{
 			"type": "streamable-http",
 			"url":  "https://api.example.test",
-			"headers": map[string]interface{}{
+			"headers": map[string]string{
 				"Authorization": "Bearer ghp_example",
 			},
-		},
+		}),
 	}
 
-	if err := transformer.Transform(servers); err != nil {
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	github := servers["github"].(map[string]interface{})
+	github := renderedByName(rendered)["github"]
 	if github["bearer_token_env_var"] != "CODEX_GITHUB_PERSONAL_ACCESS_TOKEN" {
 		t.Fatalf("expected bearer_token_env_var to be set, got %v", github["bearer_token_env_var"])
 	}
 	if headers, ok := github["headers"]; ok {
-		if len(headers.(map[string]interface{})) != 0 {
+		if len(headers.(map[string]string)) != 0 {
 			t.Fatalf("expected Authorization header to be removed, got %v", headers)
 		}
 	}
 }
 
+func TestCodexTransformerHeaderMapping(t *testing.T) {
+	transformer := &CodexTransformer{}
+	linear := spec("linear", map[string]interface{}{
+		"type": "http",
+		"url":  "https://mcp.linear.test",
+		"headers": map[string]string{
+			"Authorization": "Bearer secret",
+			"X-Api-Key":     "key",
+			"X-Team":        "core",
+		},
+	})
+	linear.HeaderTemplates = map[string]string{
+		"Authorization": "Bearer ${LINEAR_TOKEN}",
+		"X-Api-Key":     "$LINEAR_API_KEY",
+	}
+	override := spec("atlassian", map[string]interface{}{
+		"url":                  "https://mcp.atlassian.test",
+		"bearer_token_env_var": "ATLASSIAN_PAT",
+		"headers":              map[string]string{"Authorization": "Bearer secret"},
+	})
+	override.HeaderTemplates = map[string]string{"Authorization": "Bearer ${ATLASSIAN_TOKEN}"}
+	literal := spec("internal", map[string]interface{}{
+		"url":     "https://mcp.internal.test",
+		"headers": map[string]string{"Authorization": "Bearer literal"},
+	})
+
+	rendered, err := transformer.Transform(mcpconfig.Servers{linear, override, literal})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	byName := renderedByName(rendered)
+
+	got := byName["linear"]
+	if got["bearer_token_env_var"] != "LINEAR_TOKEN" {
+		t.Fatalf("expected bearer env var from header, got %v", got["bearer_token_env_var"])
+	}
+	if env := got["env_http_headers"].(map[string]string); env["X-Api-Key"] != "LINEAR_API_KEY" || len(env) != 1 {
+		t.Fatalf("unexpected env_http_headers: %v", env)
+	}
+	if static := got["http_headers"].(map[string]string); static["X-Team"] != "core" || len(static) != 1 {
+		t.Fatalf("unexpected http_headers: %v", static)
+	}
+	if _, ok := got["headers"]; ok {
+		t.Fatalf("headers should be replaced by Codex keys: %v", got)
+	}
+
+	if got := byName["atlassian"]; got["bearer_token_env_var"] != "ATLASSIAN_PAT" || got["http_headers"] != nil {
+		t.Fatalf("per-server bearer_token_env_var should win: %v", got)
+	}
+
+	if got := byName["internal"]; got["bearer_token_env_var"] != nil {
+		t.Fatalf("literal tokens should not become env vars: %v", got)
+	} else if static := got["http_headers"].(map[string]string); static["Authorization"] != "Bearer literal" {
+		t.Fatalf("literal Authorization should stay a static header: %v", static)
+	}
+}
+
 func TestGeminiTransformer_RemovesUnsupportedFields(t *testing.T) {
 	transformer := &GeminiTransformer{}
-	servers := map[string]interface{}{
-		"server1": map[string]interface{}{
+	servers := mcpconfig.Servers{
+		spec("server1", map[string]interface{}{
 			"command":     "npx",
-			"args":        []interface{}{"-y", "some-mcp-server"},
+			"args":        []string{"-y", "some-mcp-server"},
 			"autoApprove": []interface{}{},
 			"disabled":    false,
-		},
-		"server2": map[string]interface{}{
+		}),
+		spec("server2", map[string]interface{}{
 			"type":    "stdio",
 			"command": "uvx",
 			"gallery": true,
-			"env": map[string]interface{}{
+			"env": map[string]string{
 				"API_KEY": "test",
 			},
-		},
-		"server3": map[string]interface{}{
+		}),
+		spec("server3", map[string]interface{}{
 			"command":  "node",
 			"kept":     "value",
 			"disabled": true,
-		},
+		}),
 	}
 
-	if err := transformer.Transform(servers); err != nil {
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	got := renderedByName(rendered)
 
 	// Verify server1 has autoApprove and disabled removed but other fields remain
-	server1 := servers["server1"].(map[string]interface{})
+	server1 := got["server1"]
 	if _, exists := server1["autoApprove"]; exists {
 		t.Error("autoApprove should be removed from server1")
 	}
@@ -252,7 +404,7 @@ func TestGeminiTransformer_RemovesUnsupportedFields(t *testing.T) {
 	}
 
 	// Verify server2 has type and gallery removed but other fields remain
-	server2 := servers["server2"].(map[string]interface{})
+	server2 := got["server2"]
 	if _, exists := server2["type"]; exists {
 		t.Error("type should be removed from server2")
 	}
@@ -267,7 +419,7 @@ func TestGeminiTransformer_RemovesUnsupportedFields(t *testing.T) {
 	}
 
 	// Verify server3 has disabled removed but kept field remains
-	server3 := servers["server3"].(map[string]interface{})
+	server3 := got["server3"]
 	if _, exists := server3["disabled"]; exists {
 		t.Error("disabled should be removed from server3")
 	}
@@ -279,28 +431,94 @@ func TestGeminiTransformer_RemovesUnsupportedFields(t *testing.T) {
 	}
 }
 
-func TestGeminiTransformer_NonMapServer(t *testing.T) {
+func TestGeminiTransformerTranslatesFields(t *testing.T) {
 	transformer := &GeminiTransformer{}
-	servers := map[string]interface{}{
-		"invalid": "not-a-map",
-		"valid": map[string]interface{}{
+	servers := mcpconfig.Servers{
+		spec("streamable", map[string]interface{}{
+			"type":        "streamable-http",
+			"url":         "https://example.test/mcp",
+			"autoApprove": true,
+			"tools":       []interface{}{"search", "fetch"},
+			"timeout":     30000,
+		}),
+		spec("events", map[string]interface{}{
+			"type":          "sse",
+			"url":           "https://example.test/sse",
+			"tools":         []interface{}{"*"},
+			"disabledTools": []interface{}{"delete"},
+		}),
+		spec("local", map[string]interface{}{
 			"command":     "npx",
-			"autoApprove": []interface{}{},
-		},
+			"cwd":         "/srv/tool",
+			"autoApprove": []interface{}{"read"},
+			"alwaysAllow": []interface{}{"write"},
+			"disabled":    true,
+		}),
 	}
 
-	if err := transformer.Transform(servers); err != nil {
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	got := renderedByName(rendered)
 
-	// Valid server should have autoApprove removed
-	validServer := servers["valid"].(map[string]interface{})
-	if _, exists := validServer["autoApprove"]; exists {
-		t.Error("autoApprove should be removed from valid server")
+	streamable := got["streamable"]
+	if streamable["httpUrl"] != "https://example.test/mcp" || streamable["url"] != nil {
+		t.Fatalf("streamable HTTP servers should use httpUrl: %v", streamable)
+	}
+	if streamable["trust"] != true {
+		t.Fatalf("autoApprove: true should become trust: true: %v", streamable)
+	}
+	if include, ok := streamable["includeTools"].([]interface{}); !ok || len(include) != 2 {
+		t.Fatalf("tools should become includeTools: %v", streamable)
+	}
+	if streamable["timeout"] != 30000 || streamable["tools"] != nil {
+		t.Fatalf("unexpected timeout or tools: %v", streamable)
+	}
+
+	events := got["events"]
+	if events["url"] != "https://example.test/sse" || events["httpUrl"] != nil {
+		t.Fatalf("SSE servers should keep url: %v", events)
+	}
+	if events["includeTools"] != nil {
+		t.Fatalf("a wildcard tool list should not restrict tools: %v", events)
+	}
+	if exclude, ok := events["excludeTools"].([]interface{}); !ok || exclude[0] != "delete" {
+		t.Fatalf("disabledTools should become excludeTools: %v", events)
 	}
 
-	// Invalid server should remain unchanged (string)
-	if servers["invalid"] != "not-a-map" {
-		t.Error("non-map server should remain unchanged")
+	local := got["local"]
+	if local["cwd"] != "/srv/tool" || local["trust"] != nil {
+		t.Fatalf("cwd should pass through and approval lists should not grant trust: %v", local)
+	}
+	if local["autoApprove"] != nil || local["alwaysAllow"] != nil {
+		t.Fatalf("approval lists should be dropped: %v", local)
+	}
+	for _, server := range rendered {
+		if server.Disabled != (server.Name == "local") {
+			t.Fatalf("unexpected Disabled flag on %s: %v", server.Name, server.Disabled)
+		}
+	}
+}
+
+func TestGeminiTransformer_PreservesOrder(t *testing.T) {
+	transformer := &GeminiTransformer{}
+	servers := mcpconfig.Servers{
+		spec("zeta", map[string]interface{}{"command": "npx"}),
+		spec("alpha", map[string]interface{}{"command": "npx", "autoApprove": []interface{}{}}),
+	}
+
+	rendered, err := transformer.Transform(servers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rendered) != 2 || rendered[0].Name != "zeta" || rendered[1].Name != "alpha" {
+		t.Fatalf("expected servers in input order, got %v", rendered)
+	}
+	if _, exists := rendered[1].Fields["autoApprove"]; exists {
+		t.Error("autoApprove should be removed from alpha")
+	}
+	if _, exists := servers[1].Extra["autoApprove"]; !exists {
+		t.Error("input servers should not be modified")
 	}
 }